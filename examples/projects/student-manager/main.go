@@ -2,9 +2,20 @@ package main
 
 import (
 	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,6 +26,10 @@ type Student struct {
 	Name   string    `json:"name"`
 	Age    int       `json:"age"`
 	Scores []float64 `json:"scores"`
+	// 每个成绩对应的科目，与Scores下标一一对应（旧数据可能没有）
+	Subjects []string `json:"subjects,omitempty"`
+	// 合并重复记录时被并入本记录的学生ID
+	MergedIDs []int `json:"merged_ids,omitempty"`
 }
 
 // 计算平均分
@@ -32,29 +47,89 @@ func (s Student) Average() float64 {
 
 // 添加成绩
 func (s *Student) AddScore(score float64) {
+	s.AddSubjectScore("", score)
+}
+
+// 添加某一科目的成绩
+func (s *Student) AddSubjectScore(subject string, score float64) {
+	if subject != "" || len(s.Subjects) > 0 {
+		// 旧数据没有科目信息，先补齐再追加，保持下标对应
+		for len(s.Subjects) < len(s.Scores) {
+			s.Subjects = append(s.Subjects, "")
+		}
+		s.Subjects = append(s.Subjects, subject)
+	}
 	s.Scores = append(s.Scores, score)
 }
 
+// 第i个成绩的科目，没有记录时返回空字符串
+func (s *Student) SubjectAt(i int) string {
+	if i < len(s.Subjects) {
+		return s.Subjects[i]
+	}
+	return ""
+}
+
+// 深拷贝，用于批量操作失败时回滚
+func (s *Student) clone() *Student {
+	c := *s
+	c.Scores = append([]float64(nil), s.Scores...)
+	c.Subjects = append([]string(nil), s.Subjects...)
+	c.MergedIDs = append([]int(nil), s.MergedIDs...)
+	return &c
+}
+
 // 学生管理器
 type StudentManager struct {
 	students map[int]*Student
+	ids      []int // 按升序排列的学生ID，用于分页和有序遍历
 	nextID   int
 	filename string
+	// 非空时保存的文件使用由该密码派生的密钥加密
+	passphrase string
+	// 当前登录用户，修改类操作会检查其角色权限
+	user *User
 }
 
-// 创建新的学生管理器
-func NewStudentManager(filename string) *StudentManager {
+// 打开学生数据文件，passphrase为空表示不加密
+// 文件不存在时返回空的管理器，加载失败（如密码错误）时返回错误
+func OpenStudentManager(filename, passphrase string) (*StudentManager, error) {
 	sm := &StudentManager{
-		students: make(map[int]*Student),
-		nextID:   1,
-		filename: filename,
+		students:   make(map[int]*Student),
+		nextID:     1,
+		filename:   filename,
+		passphrase: passphrase,
 	}
-	sm.loadFromFile()
-	return sm
+	return sm, sm.loadFromFile()
+}
+
+// 设置当前操作的用户
+func (sm *StudentManager) SetUser(user *User) {
+	sm.user = user
+}
+
+// 当前用户是否拥有某项权限
+func (sm *StudentManager) Can(perm Permission) bool {
+	return sm.user != nil && sm.user.Role.Can(perm)
+}
+
+// 检查当前用户权限，没有权限时返回ErrPermissionDenied
+func (sm *StudentManager) authorize(perm Permission) error {
+	if sm.user == nil {
+		return fmt.Errorf("%w: not logged in", ErrPermissionDenied)
+	}
+	if !sm.user.Role.Can(perm) {
+		return fmt.Errorf("%w: %s (%s) cannot %s", ErrPermissionDenied, sm.user.Username, sm.user.Role, perm)
+	}
+	return nil
 }
 
 // 添加学生
-func (sm *StudentManager) AddStudent(name string, age int) *Student {
+func (sm *StudentManager) AddStudent(name string, age int) (*Student, error) {
+	if err := sm.authorize(PermEdit); err != nil {
+		return nil, err
+	}
+
 	student := &Student{
 		ID:     sm.nextID,
 		Name:   name,
@@ -63,8 +138,26 @@ func (sm *StudentManager) AddStudent(name string, age int) *Student {
 	}
 
 	sm.students[sm.nextID] = student
+	sm.ids = append(sm.ids, sm.nextID) // nextID递增，追加后仍然有序
 	sm.nextID++
-	return student
+	return student, nil
+}
+
+// 为学生添加成绩，成绩必须在0-100之间
+func (sm *StudentManager) AddScore(id int, subject string, score float64) (*Student, error) {
+	if err := sm.authorize(PermEdit); err != nil {
+		return nil, err
+	}
+	student, exists := sm.students[id]
+	if !exists {
+		return nil, fmt.Errorf("student with ID %d not found", id)
+	}
+	if score < 0 || score > 100 {
+		return nil, fmt.Errorf("score %.2f must be between 0 and 100", score)
+	}
+
+	student.AddSubjectScore(subject, score)
+	return student, nil
 }
 
 // 根据ID查找学生
@@ -76,8 +169,8 @@ func (sm *StudentManager) FindByID(id int) (*Student, bool) {
 // 根据姓名查找学生
 func (sm *StudentManager) FindByName(name string) []*Student {
 	var results []*Student
-	for _, student := range sm.students {
-		if strings.Contains(strings.ToLower(student.Name), strings.ToLower(name)) {
+	for _, id := range sm.ids {
+		if student := sm.students[id]; strings.Contains(strings.ToLower(student.Name), strings.ToLower(name)) {
 			results = append(results, student)
 		}
 	}
@@ -86,22 +179,77 @@ func (sm *StudentManager) FindByName(name string) []*Student {
 
 // 删除学生
 func (sm *StudentManager) DeleteStudent(id int) error {
+	if err := sm.authorize(PermDelete); err != nil {
+		return err
+	}
 	if _, exists := sm.students[id]; !exists {
 		return fmt.Errorf("student with ID %d not found", id)
 	}
-	delete(sm.students, id)
+	sm.removeStudent(id)
 	return nil
 }
 
-// 获取所有学生
+// 从map和有序ID列表中同时移除
+func (sm *StudentManager) removeStudent(id int) {
+	delete(sm.students, id)
+	if i := sort.SearchInts(sm.ids, id); i < len(sm.ids) && sm.ids[i] == id {
+		sm.ids = append(sm.ids[:i], sm.ids[i+1:]...)
+	}
+}
+
+// 根据map重建有序ID列表
+func (sm *StudentManager) reindex() {
+	sm.ids = make([]int, 0, len(sm.students))
+	for id := range sm.students {
+		sm.ids = append(sm.ids, id)
+	}
+	sort.Ints(sm.ids)
+}
+
+// 获取所有学生（按ID排序）
 func (sm *StudentManager) GetAllStudents() []*Student {
-	students := make([]*Student, 0, len(sm.students))
-	for _, student := range sm.students {
-		students = append(students, student)
+	students := make([]*Student, 0, len(sm.ids))
+	for _, id := range sm.ids {
+		students = append(students, sm.students[id])
 	}
 	return students
 }
 
+// 分页查询：返回ID大于cursor的至多limit名学生
+// next为下一页的游标，为0表示已经没有更多数据；首页传入cursor为0
+func (sm *StudentManager) List(cursor, limit int) (page []*Student, next int, err error) {
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("invalid page size %d", limit)
+	}
+
+	start := sort.SearchInts(sm.ids, cursor+1)
+	end := start + limit
+	if end > len(sm.ids) {
+		end = len(sm.ids)
+	}
+
+	page = make([]*Student, 0, end-start)
+	for _, id := range sm.ids[start:end] {
+		page = append(page, sm.students[id])
+	}
+	if end < len(sm.ids) {
+		next = sm.ids[end-1]
+	}
+	return page, next, nil
+}
+
+// 按ID顺序逐个遍历学生，fn返回false时提前结束，不会复制全部数据
+// 每一步都按上一个学生的ID查找下一个，fn中增删学生不会导致跳过或重复
+func (sm *StudentManager) Each(fn func(*Student) bool) {
+	for i := 0; i < len(sm.ids); {
+		id := sm.ids[i]
+		if !fn(sm.students[id]) {
+			return
+		}
+		i = sort.SearchInts(sm.ids, id+1)
+	}
+}
+
 // 保存到文件
 func (sm *StudentManager) SaveToFile() error {
 	data, err := json.MarshalIndent(sm.students, "", "  ")
@@ -109,11 +257,44 @@ func (sm *StudentManager) SaveToFile() error {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	err = os.WriteFile(sm.filename, data, 0644)
+	perm := os.FileMode(0644)
+	if sm.passphrase != "" {
+		if data, err = encryptData(data, sm.passphrase); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+
+	return writeFileAtomic(sm.filename, data, perm)
+}
+
+// 先写入同目录下的临时文件再重命名，保存中途失败不会损坏原文件；
+// 重命名会替换原文件，已有文件的权限（如加密前的0644）也随之更新
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
+	defer os.Remove(tmp.Name()) // 重命名成功后不再存在
 
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
 	return nil
 }
 
@@ -127,11 +308,22 @@ func (sm *StudentManager) loadFromFile() error {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
+	if isEncrypted(data) {
+		if sm.passphrase == "" {
+			return ErrPassphraseRequired
+		}
+		if data, err = decryptData(data, sm.passphrase); err != nil {
+			return err
+		}
+	}
+
 	err = json.Unmarshal(data, &sm.students)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal data: %w", err)
 	}
 
+	sm.reindex()
+
 	// 更新nextID
 	for id := range sm.students {
 		if id >= sm.nextID {
@@ -142,21 +334,981 @@ func (sm *StudentManager) loadFromFile() error {
 	return nil
 }
 
+// 批量操作结果：记录受影响的学生ID
+type BatchResult struct {
+	Operation   string
+	AffectedIDs []int
+}
+
+func (r *BatchResult) String() string {
+	return fmt.Sprintf("%s: 影响 %d 名学生 %v", r.Operation, len(r.AffectedIDs), r.AffectedIDs)
+}
+
+// 在当前数据上执行批量操作，fn返回错误时整体回滚（全部成功或全部不变）
+func (sm *StudentManager) transaction(fn func() error) error {
+	snapshot := make(map[int]*Student, len(sm.students))
+	for id, student := range sm.students {
+		snapshot[id] = student.clone()
+	}
+	nextID := sm.nextID
+
+	if err := fn(); err != nil {
+		sm.students = snapshot
+		sm.nextID = nextID
+		sm.reindex()
+		return err
+	}
+	return nil
+}
+
+// 按ID顺序返回满足查询条件的学生，保证批量操作结果稳定
+func (sm *StudentManager) match(query StudentQuery) []*Student {
+	var results []*Student
+	for _, id := range sm.ids {
+		if student := sm.students[id]; query.Match(student) {
+			results = append(results, student)
+		}
+	}
+	return results
+}
+
+// 从输入批量导入成绩
+// 每行格式为 "ID,成绩" 或 "ID,科目,成绩"，以#开头的行为注释
+func (sm *StudentManager) BatchAddScores(r io.Reader) (*BatchResult, error) {
+	if err := sm.authorize(PermEdit); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	result := &BatchResult{Operation: "导入成绩"}
+	seen := make(map[int]bool)
+
+	err := sm.transaction(func() error {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read scores: %w", err)
+			}
+			line, _ := reader.FieldPos(0)
+
+			var subject, scoreField string
+			switch len(record) {
+			case 2:
+				scoreField = record[1]
+			case 3:
+				subject, scoreField = strings.TrimSpace(record[1]), record[2]
+			default:
+				return fmt.Errorf("line %d: expected 2 or 3 fields, got %d", line, len(record))
+			}
+
+			id, err := strconv.Atoi(strings.TrimSpace(record[0]))
+			if err != nil {
+				return fmt.Errorf("line %d: invalid student ID %q", line, record[0])
+			}
+			student, exists := sm.students[id]
+			if !exists {
+				return fmt.Errorf("line %d: student with ID %d not found", line, id)
+			}
+			score, err := strconv.ParseFloat(strings.TrimSpace(scoreField), 64)
+			if err != nil || score < 0 || score > 100 {
+				return fmt.Errorf("line %d: score %q must be a number between 0 and 100", line, scoreField)
+			}
+
+			student.AddSubjectScore(subject, score)
+			if !seen[id] {
+				seen[id] = true
+				result.AffectedIDs = append(result.AffectedIDs, id)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(result.AffectedIDs)
+	return result, nil
+}
+
+// 按科目调整成绩：该科目的每个成绩加上points分，结果限制在0-100之间
+func (sm *StudentManager) CurveSubject(subject string, points float64) (*BatchResult, error) {
+	if err := sm.authorize(PermEdit); err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, errors.New("subject must not be empty")
+	}
+
+	result := &BatchResult{Operation: "调整" + subject + "成绩"}
+	err := sm.transaction(func() error {
+		for _, student := range sm.match(StudentQuery{}) {
+			curved := false
+			for i := range student.Scores {
+				if student.SubjectAt(i) != subject {
+					continue
+				}
+				score := student.Scores[i] + points
+				if score < 0 {
+					score = 0
+				} else if score > 100 {
+					score = 100
+				}
+				student.Scores[i] = score
+				curved = true
+			}
+			if curved {
+				result.AffectedIDs = append(result.AffectedIDs, student.ID)
+			}
+		}
+		if len(result.AffectedIDs) == 0 {
+			return fmt.Errorf("no scores found for subject %q", subject)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 删除所有满足查询条件的学生
+func (sm *StudentManager) DeleteWhere(query StudentQuery) (*BatchResult, error) {
+	if err := sm.authorize(PermDelete); err != nil {
+		return nil, err
+	}
+	if len(query) == 0 {
+		return nil, errors.New("refusing to delete with an empty query")
+	}
+
+	result := &BatchResult{Operation: "按条件删除"}
+	err := sm.transaction(func() error {
+		for _, student := range sm.match(query) {
+			if err := sm.DeleteStudent(student.ID); err != nil {
+				return err
+			}
+			result.AffectedIDs = append(result.AffectedIDs, student.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 批量修改年龄：满足条件的学生年龄加上delta（如新学年统一加1）
+func (sm *StudentManager) UpdateAgeWhere(query StudentQuery, delta int) (*BatchResult, error) {
+	if err := sm.authorize(PermEdit); err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{Operation: "批量修改年龄"}
+	err := sm.transaction(func() error {
+		for _, student := range sm.match(query) {
+			age := student.Age + delta
+			if age <= 0 {
+				return fmt.Errorf("student %d: age would become %d", student.ID, age)
+			}
+			student.Age = age
+			result.AffectedIDs = append(result.AffectedIDs, student.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 查询条件：多个条件之间为“且”关系，空查询匹配所有学生
+type StudentQuery []queryCondition
+
+type queryCondition struct {
+	field string // id、name、age、avg
+	op    string // ~ = != < <= > >=
+	text  string
+	num   float64
+}
+
+// 查询支持的运算符，长的放前面以便优先匹配
+var queryOperators = []string{"<=", ">=", "!=", "~", "=", "<", ">"}
+
+// 解析查询字符串，例如 "age>=18 avg<60" 或 "name~张"
+// 条件之间用空格或逗号分隔，含空格的值用双引号括起来，如 name="Mary Ann"
+func ParseStudentQuery(input string) (StudentQuery, error) {
+	var query StudentQuery
+	tokens, err := splitQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		cond, err := parseQueryCondition(token)
+		if err != nil {
+			return nil, err
+		}
+		query = append(query, cond)
+	}
+	return query, nil
+}
+
+// 按空格、制表符和逗号切分，双引号内的分隔符保留，引号本身去掉
+func splitQuery(input string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuote, started := false, false
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			started = true
+		case !inQuote && (r == ',' || r == ' ' || r == '\t'):
+			if started {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in query")
+	}
+	if started {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+func parseQueryCondition(token string) (queryCondition, error) {
+	for _, op := range queryOperators {
+		idx := strings.Index(token, op)
+		if idx <= 0 {
+			continue
+		}
+
+		cond := queryCondition{
+			field: strings.ToLower(token[:idx]),
+			op:    op,
+			text:  token[idx+len(op):],
+		}
+		// 空值的 name~ 会匹配所有学生，批量删除时尤其危险
+		if strings.TrimSpace(cond.text) == "" {
+			return cond, fmt.Errorf("empty value in %q", token)
+		}
+		switch cond.field {
+		case "name":
+			if op != "~" && op != "=" && op != "!=" {
+				return cond, fmt.Errorf("operator %q not supported for name", op)
+			}
+		case "id", "age", "avg":
+			if op == "~" {
+				return cond, fmt.Errorf("operator ~ only supported for name")
+			}
+			num, err := strconv.ParseFloat(cond.text, 64)
+			if err != nil {
+				return cond, fmt.Errorf("invalid number in %q", token)
+			}
+			cond.num = num
+		default:
+			return cond, fmt.Errorf("unknown field %q (want id, name, age or avg)", cond.field)
+		}
+		return cond, nil
+	}
+	return queryCondition{}, fmt.Errorf("invalid condition %q", token)
+}
+
+// 判断学生是否满足所有条件
+func (q StudentQuery) Match(s *Student) bool {
+	for _, cond := range q {
+		if !cond.match(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c queryCondition) match(s *Student) bool {
+	if c.field == "name" {
+		name, text := strings.ToLower(s.Name), strings.ToLower(c.text)
+		switch c.op {
+		case "~":
+			return strings.Contains(name, text)
+		case "=":
+			return name == text
+		default:
+			return name != text
+		}
+	}
+
+	var value float64
+	switch c.field {
+	case "id":
+		value = float64(s.ID)
+	case "age":
+		value = float64(s.Age)
+	case "avg":
+		value = s.Average()
+	}
+
+	switch c.op {
+	case "=":
+		return value == c.num
+	case "!=":
+		return value != c.num
+	case "<":
+		return value < c.num
+	case "<=":
+		return value <= c.num
+	case ">":
+		return value > c.num
+	default:
+		return value >= c.num
+	}
+}
+
+// 一组疑似重复的学生记录
+type DuplicateGroup struct {
+	IDs   []int
+	Exact bool // 姓名完全相同（忽略大小写和多余空白）
+}
+
+// 规范化姓名：小写并合并空白
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// 按字符（而不是字节）计算编辑距离，中文姓名也能正确比较
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// 模糊匹配：编辑距离不超过较长姓名长度的1/4。
+// 两个字以内的姓名（如“张三”和“张四”）差一个字就是不同的人，必须完全相同
+func similarNames(a, b string) bool {
+	longest := len([]rune(a))
+	if n := len([]rune(b)); n > longest {
+		longest = n
+	}
+	if longest <= 2 {
+		return a == b
+	}
+	return levenshtein(a, b)*4 <= longest
+}
+
+// 查找重复记录：年龄相同且姓名相同（fuzzy为true时姓名相近也算）
+func (sm *StudentManager) FindDuplicates(fuzzy bool) []DuplicateGroup {
+	// 只有同龄学生才可能重复，先按年龄分桶减少比较次数
+	byAge := make(map[int][]*Student)
+	for _, student := range sm.match(StudentQuery{}) {
+		byAge[student.Age] = append(byAge[student.Age], student)
+	}
+
+	var groups []DuplicateGroup
+	for _, candidates := range byAge {
+		// 并查集：把两两相似的记录连成一组
+		parent := make([]int, len(candidates))
+		for i := range parent {
+			parent[i] = i
+		}
+		find := func(i int) int {
+			for parent[i] != i {
+				parent[i] = parent[parent[i]]
+				i = parent[i]
+			}
+			return i
+		}
+
+		names := make([]string, len(candidates))
+		for i, student := range candidates {
+			names[i] = normalizeName(student.Name)
+		}
+		for i := range candidates {
+			for j := i + 1; j < len(candidates); j++ {
+				if names[i] == names[j] || (fuzzy && similarNames(names[i], names[j])) {
+					parent[find(j)] = find(i)
+				}
+			}
+		}
+
+		members := make(map[int][]int)
+		for i := range candidates {
+			root := find(i)
+			members[root] = append(members[root], i)
+		}
+		for _, idx := range members {
+			if len(idx) < 2 {
+				continue
+			}
+			group := DuplicateGroup{Exact: true}
+			for _, i := range idx {
+				group.IDs = append(group.IDs, candidates[i].ID)
+				if names[i] != names[idx[0]] {
+					group.Exact = false
+				}
+			}
+			sort.Ints(group.IDs)
+			groups = append(groups, group)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].IDs[0] < groups[j].IDs[0] })
+	return groups
+}
+
+// 合并学生记录：把mergeIDs的成绩并入keepID，删除被合并的记录并记录其ID
+// 合并会删除记录，因此需要删除权限
+func (sm *StudentManager) MergeStudents(keepID int, mergeIDs []int) (*BatchResult, error) {
+	if err := sm.authorize(PermDelete); err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{Operation: fmt.Sprintf("合并到学生%d", keepID)}
+	err := sm.transaction(func() error {
+		keep, exists := sm.students[keepID]
+		if !exists {
+			return fmt.Errorf("student with ID %d not found", keepID)
+		}
+
+		for _, id := range mergeIDs {
+			if id == keepID {
+				return fmt.Errorf("cannot merge student %d into itself", id)
+			}
+			other, exists := sm.students[id]
+			if !exists {
+				return fmt.Errorf("student with ID %d not found", id)
+			}
+
+			for i, score := range other.Scores {
+				keep.AddSubjectScore(other.SubjectAt(i), score)
+			}
+			keep.MergedIDs = append(keep.MergedIDs, id)
+			keep.MergedIDs = append(keep.MergedIDs, other.MergedIDs...)
+			sm.removeStudent(id)
+			result.AffectedIDs = append(result.AffectedIDs, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 加密相关错误
+var (
+	ErrPassphraseRequired = errors.New("data file is encrypted, passphrase required")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupted data file")
+)
+
+const (
+	encryptedFormat = "student-manager/aes-256-gcm"
+	kdfName         = "pbkdf2-sha256"
+	kdfIterations   = 600000
+	// 文件中记录的迭代次数必须在此范围内：过小会削弱密钥派生，过大会让启动长时间卡住
+	minKDFIterations = 100000
+	maxKDFIterations = 10000000
+	saltSize         = 16
+	keySize          = 32 // AES-256
+)
+
+// 加密后的文件内容，[]byte字段在JSON中以base64保存
+type encryptedFile struct {
+	Format     string `json:"format"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// 判断文件内容是否为加密格式（明文数据是以ID为键的对象，没有format字段）
+func isEncrypted(data []byte) bool {
+	var file encryptedFile
+	return json.Unmarshal(data, &file) == nil && file.Format == encryptedFormat
+}
+
+// 判断数据文件是否已加密，文件不存在时返回false
+func IsEncryptedFile(filename string) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	return isEncrypted(data), nil
+}
+
+// 设置加密密码，空字符串表示关闭加密，下次保存时生效
+func (sm *StudentManager) SetPassphrase(passphrase string) error {
+	if err := sm.authorize(PermAdmin); err != nil {
+		return err
+	}
+	sm.passphrase = passphrase
+	return nil
+}
+
+// 是否启用了加密
+func (sm *StudentManager) Encrypted() bool {
+	return sm.passphrase != ""
+}
+
+// 校验密码是否与当前使用的密码一致
+func (sm *StudentManager) VerifyPassphrase(passphrase string) bool {
+	return hmac.Equal([]byte(passphrase), []byte(sm.passphrase))
+}
+
+// 更换密码：校验当前密码后用新密码派生的密钥重新加密并立即保存
+func (sm *StudentManager) RotatePassphrase(current, next string) error {
+	if err := sm.authorize(PermAdmin); err != nil {
+		return err
+	}
+	if !sm.VerifyPassphrase(current) {
+		return ErrWrongPassphrase
+	}
+	if next == "" {
+		return errors.New("new passphrase must not be empty")
+	}
+
+	sm.passphrase = next
+	if err := sm.SaveToFile(); err != nil {
+		sm.passphrase = current
+		return fmt.Errorf("failed to re-encrypt data: %w", err)
+	}
+	return nil
+}
+
+// 每次加密都使用新的随机盐和nonce
+func encryptData(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt, kdfIterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	file := encryptedFile{
+		Format:     encryptedFormat,
+		KDF:        kdfName,
+		Iterations: kdfIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(encryptedFormat)),
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+func decryptData(data []byte, passphrase string) ([]byte, error) {
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted file: %w", err)
+	}
+	if file.KDF != kdfName {
+		return nil, fmt.Errorf("unsupported key derivation %q", file.KDF)
+	}
+	if file.Iterations < minKDFIterations || file.Iterations > maxKDFIterations {
+		return nil, fmt.Errorf("key derivation iterations %d out of range [%d, %d]",
+			file.Iterations, minKDFIterations, maxKDFIterations)
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+
+	// GCM认证失败：密码错误或文件被篡改，二者无法区分
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, []byte(encryptedFormat))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2SHA256([]byte(passphrase), salt, iterations, keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2-HMAC-SHA256（RFC 8018），由密码派生密钥
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
+
+// 权限相关错误
+var (
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrLastAdmin          = errors.New("at least one admin is required")
+)
+
+// 用户角色
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"   // 管理员：所有操作，包括删除和用户管理
+	RoleTeacher Role = "teacher" // 教师：添加学生、录入和调整成绩
+	RoleViewer  Role = "viewer"  // 只读：只能查看
+)
+
+// 操作权限
+type Permission int
+
+const (
+	PermView   Permission = iota // 查看学生信息
+	PermEdit                     // 添加和修改学生、成绩
+	PermDelete                   // 删除或合并学生记录
+	PermAdmin                    // 用户管理和加密设置
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermView:
+		return "view"
+	case PermEdit:
+		return "edit"
+	case PermDelete:
+		return "delete"
+	default:
+		return "administer"
+	}
+}
+
+// 各角色拥有的权限
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:   {PermView, PermEdit, PermDelete, PermAdmin},
+	RoleTeacher: {PermView, PermEdit},
+	RoleViewer:  {PermView},
+}
+
+// 判断角色是否拥有某项权限
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析角色名称
+func ParseRole(name string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (want admin, teacher or viewer)", name)
+	}
+	return role, nil
+}
+
+// 用户账号，只保存加盐后的密码哈希
+type User struct {
+	Username   string `json:"username"`
+	Role       Role   `json:"role"`
+	Salt       []byte `json:"salt"`
+	Hash       []byte `json:"hash"`
+	Iterations int    `json:"iterations"`
+}
+
+// 校验密码
+func (u *User) checkPassword(password string) bool {
+	// 与加密文件一样，不信任文件中超出范围的迭代次数
+	if u.Iterations < minKDFIterations || u.Iterations > maxKDFIterations {
+		return false
+	}
+	hash := pbkdf2SHA256([]byte(password), u.Salt, u.Iterations, len(u.Hash))
+	return hmac.Equal(hash, u.Hash)
+}
+
+// 用户存储，保存在本地JSON文件中
+type UserStore struct {
+	users    map[string]*User
+	filename string
+}
+
+// 加载用户文件，文件不存在时返回空的存储
+func LoadUserStore(filename string) (*UserStore, error) {
+	us := &UserStore{
+		users:    make(map[string]*User),
+		filename: filename,
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return us, nil
+		}
+		return nil, fmt.Errorf("failed to read user file: %w", err)
+	}
+	if err := json.Unmarshal(data, &us.users); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal users: %w", err)
+	}
+	return us, nil
+}
+
+// 是否还没有任何用户（首次运行时需要创建管理员）
+func (us *UserStore) Empty() bool {
+	return len(us.users) == 0
+}
+
+// 添加用户或重置已有用户的密码和角色
+func (us *UserStore) SetUser(username, password string, role Role) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return errors.New("username must not be empty")
+	}
+	if len(password) < 6 {
+		return errors.New("password must be at least 6 characters")
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	// 把最后一个管理员改成其他角色后，就再也没有人能管理用户了
+	if role != RoleAdmin && us.isLastAdmin(username) {
+		return ErrLastAdmin
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	us.users[username] = &User{
+		Username:   username,
+		Role:       role,
+		Salt:       salt,
+		Hash:       pbkdf2SHA256([]byte(password), salt, kdfIterations, keySize),
+		Iterations: kdfIterations,
+	}
+	return nil
+}
+
+// 删除用户
+func (us *UserStore) DeleteUser(username string) error {
+	if _, exists := us.users[username]; !exists {
+		return fmt.Errorf("user %q not found", username)
+	}
+	if us.isLastAdmin(username) {
+		return ErrLastAdmin
+	}
+	delete(us.users, username)
+	return nil
+}
+
+// 查找用户
+func (us *UserStore) Get(username string) (*User, bool) {
+	user, exists := us.users[username]
+	return user, exists
+}
+
+// 该用户是否为唯一的管理员
+func (us *UserStore) isLastAdmin(username string) bool {
+	user, exists := us.users[username]
+	if !exists || user.Role != RoleAdmin {
+		return false
+	}
+	for _, other := range us.users {
+		if other != user && other.Role == RoleAdmin {
+			return false
+		}
+	}
+	return true
+}
+
+// 按用户名排序返回所有用户
+func (us *UserStore) List() []*User {
+	users := make([]*User, 0, len(us.users))
+	for _, user := range us.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// 登录验证，用户名或密码错误时返回ErrInvalidCredentials
+func (us *UserStore) Authenticate(username, password string) (*User, error) {
+	user, exists := us.users[username]
+	if !exists || !user.checkPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// 保存用户文件，只允许当前系统用户读写
+func (us *UserStore) Save() error {
+	data, err := json.MarshalIndent(us.users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users: %w", err)
+	}
+	if err := writeFileAtomic(us.filename, data, 0600); err != nil {
+		return fmt.Errorf("failed to save users: %w", err)
+	}
+	return nil
+}
+
 // 应用程序
 type App struct {
 	manager *StudentManager
+	users   *UserStore
+	user    *User
 	scanner *bufio.Scanner
 }
 
+const (
+	dataFile  = "students.json"
+	usersFile = "users.json"
+	// 可以通过环境变量提供密码，避免每次启动时输入
+	passphraseEnv = "STUDENT_PASSPHRASE"
+)
+
 func NewApp() *App {
 	return &App{
-		manager: NewStudentManager("students.json"),
 		scanner: bufio.NewScanner(os.Stdin),
 	}
 }
 
+// 打开数据文件，文件已加密时提示输入密码（最多尝试3次）
+func (app *App) openData() error {
+	passphrase := os.Getenv(passphraseEnv)
+	encrypted, err := IsEncryptedFile(dataFile)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if encrypted && passphrase == "" {
+			fmt.Print("数据文件已加密，请输入密码: ")
+			passphrase = app.readLine()
+		}
+
+		manager, err := OpenStudentManager(dataFile, passphrase)
+		if err == nil {
+			manager.SetUser(app.user)
+			app.manager = manager
+			return nil
+		}
+		if !errors.Is(err, ErrWrongPassphrase) || attempt == 3 {
+			return err
+		}
+		fmt.Println("密码错误，请重试")
+		passphrase = ""
+	}
+}
+
+// 登录；首次运行时没有任何用户，先创建管理员账号
+func (app *App) login() error {
+	users, err := LoadUserStore(usersFile)
+	if err != nil {
+		return err
+	}
+	app.users = users
+
+	if users.Empty() {
+		fmt.Println("首次运行，请创建管理员账号")
+		fmt.Print("用户名: ")
+		username := app.readLine()
+		password, ok := app.readNewPassphrase()
+		if !ok {
+			return errors.New("failed to create admin account")
+		}
+		if err := users.SetUser(username, password, RoleAdmin); err != nil {
+			return err
+		}
+		if err := users.Save(); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		fmt.Print("用户名: ")
+		username := app.readLine()
+		fmt.Print("密码: ")
+		password := app.readLine()
+
+		user, err := users.Authenticate(username, password)
+		if err == nil {
+			app.user = user
+			fmt.Printf("登录成功，当前角色: %s\n", user.Role)
+			return nil
+		}
+		if attempt == 3 {
+			return err
+		}
+		fmt.Println("用户名或密码错误，请重试")
+	}
+}
+
 func (app *App) showMenu() {
-	fmt.Println("\n=== 学生管理系统 ===")
+	fmt.Printf("\n=== 学生管理系统（%s / %s）===\n", app.user.Username, app.user.Role)
 	fmt.Println("1. 添加学生")
 	fmt.Println("2. 查看所有学生")
 	fmt.Println("3. 查找学生（按ID）")
@@ -164,8 +1316,12 @@ func (app *App) showMenu() {
 	fmt.Println("5. 添加成绩")
 	fmt.Println("6. 删除学生")
 	fmt.Println("7. 保存数据")
-	fmt.Println("8. 退出")
-	fmt.Print("请选择操作（1-8）: ")
+	fmt.Println("8. 批量操作")
+	fmt.Println("9. 查找并合并重复学生")
+	fmt.Println("10. 加密设置")
+	fmt.Println("11. 用户管理")
+	fmt.Println("12. 退出")
+	fmt.Print("请选择操作（1-12）: ")
 }
 
 func (app *App) readLine() string {
@@ -194,27 +1350,42 @@ func (app *App) addStudent() {
 		return
 	}
 
-	student := app.manager.AddStudent(name, age)
+	student, err := app.manager.AddStudent(name, age)
+	if err != nil {
+		fmt.Printf("添加失败: %v\n", err)
+		return
+	}
 	fmt.Printf("成功添加学生: ID=%d, 姓名=%s, 年龄=%d\n", student.ID, student.Name, student.Age)
 }
 
-func (app *App) showAllStudents() {
-	students := app.manager.GetAllStudents()
-	if len(students) == 0 {
-		fmt.Println("没有学生记录")
-		return
-	}
+// 列表每页显示的学生数
+const pageSize = 10
 
-	fmt.Println("\n所有学生信息:")
-	fmt.Println("ID\t姓名\t年龄\t成绩\t平均分")
-	fmt.Println("-------------------------------------------")
-	for _, student := range students {
+func (app *App) showAllStudents() {
+	count := 0
+	app.manager.Each(func(student *Student) bool {
+		if count%pageSize == 0 {
+			if count > 0 {
+				fmt.Print("按回车查看下一页，输入q返回: ")
+				if strings.ToLower(app.readLine()) == "q" {
+					return false
+				}
+			}
+			fmt.Printf("\n所有学生信息（第 %d 页）:\n", count/pageSize+1)
+			fmt.Println("ID\t姓名\t年龄\t成绩\t平均分")
+			fmt.Println("-------------------------------------------")
+		}
 		scoresStr := fmt.Sprintf("%v", student.Scores)
 		if len(student.Scores) == 0 {
 			scoresStr = "无"
 		}
 		fmt.Printf("%d\t%s\t%d\t%s\t%.2f\n",
 			student.ID, student.Name, student.Age, scoresStr, student.Average())
+		count++
+		return true
+	})
+	if count == 0 {
+		fmt.Println("没有学生记录")
 	}
 }
 
@@ -261,12 +1432,14 @@ func (app *App) addScore() {
 		return
 	}
 
-	student, exists := app.manager.FindByID(id)
-	if !exists {
+	if _, exists := app.manager.FindByID(id); !exists {
 		fmt.Printf("未找到ID为 %d 的学生\n", id)
 		return
 	}
 
+	fmt.Print("请输入科目（可留空）: ")
+	subject := app.readLine()
+
 	fmt.Print("请输入成绩: ")
 	score, err := app.readFloat()
 	if err != nil {
@@ -274,17 +1447,21 @@ func (app *App) addScore() {
 		return
 	}
 
-	if score < 0 || score > 100 {
-		fmt.Println("成绩应该在0-100之间")
+	student, err := app.manager.AddScore(id, subject, score)
+	if err != nil {
+		fmt.Printf("添加成绩失败: %v\n", err)
 		return
 	}
-
-	student.AddScore(score)
 	fmt.Printf("成功为学生 %s 添加成绩 %.2f，当前平均分: %.2f\n",
 		student.Name, score, student.Average())
 }
 
 func (app *App) deleteStudent() {
+	if !app.manager.Can(PermDelete) {
+		fmt.Println("当前角色没有删除权限")
+		return
+	}
+
 	fmt.Print("请输入要删除的学生ID: ")
 	id, err := app.readInt()
 	if err != nil {
@@ -313,6 +1490,275 @@ func (app *App) deleteStudent() {
 	}
 }
 
+func (app *App) batchOperations() {
+	fmt.Println("\n--- 批量操作（全部成功或全部回滚） ---")
+	fmt.Println("1. 从文件导入全班成绩")
+	fmt.Println("2. 按科目调整成绩")
+	fmt.Println("3. 按条件删除学生")
+	fmt.Println("4. 批量修改年龄")
+	fmt.Print("请选择操作（1-4）: ")
+
+	var result *BatchResult
+	var err error
+
+	switch app.readLine() {
+	case "1":
+		fmt.Print("请输入成绩文件路径（每行: ID,成绩 或 ID,科目,成绩）: ")
+		file, openErr := os.Open(app.readLine())
+		if openErr != nil {
+			fmt.Printf("打开文件失败: %v\n", openErr)
+			return
+		}
+		defer file.Close()
+		result, err = app.manager.BatchAddScores(file)
+	case "2":
+		fmt.Print("请输入科目: ")
+		subject := app.readLine()
+		fmt.Print("请输入调整分数（可为负数）: ")
+		points, parseErr := app.readFloat()
+		if parseErr != nil {
+			fmt.Printf("无效的分数: %v\n", parseErr)
+			return
+		}
+		result, err = app.manager.CurveSubject(subject, points)
+	case "3":
+		query, ok := app.readQuery()
+		if !ok {
+			return
+		}
+		matched := app.manager.match(query)
+		if len(matched) == 0 {
+			fmt.Println("没有满足条件的学生")
+			return
+		}
+		fmt.Printf("将删除 %d 名学生，确认吗？(y/N): ", len(matched))
+		if strings.ToLower(app.readLine()) != "y" {
+			fmt.Println("取消删除操作")
+			return
+		}
+		result, err = app.manager.DeleteWhere(query)
+	case "4":
+		query, ok := app.readQuery()
+		if !ok {
+			return
+		}
+		fmt.Print("请输入年龄变化量（如 1 或 -1）: ")
+		delta, parseErr := app.readInt()
+		if parseErr != nil {
+			fmt.Printf("无效的数字: %v\n", parseErr)
+			return
+		}
+		result, err = app.manager.UpdateAgeWhere(query, delta)
+	default:
+		fmt.Println("无效的选择")
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("批量操作失败，已回滚: %v\n", err)
+		return
+	}
+	fmt.Printf("批量操作成功 - %s\n", result)
+}
+
+func (app *App) mergeDuplicates() {
+	fmt.Print("是否启用模糊匹配（姓名相近也视为重复）？(y/N): ")
+	fuzzy := strings.ToLower(app.readLine()) == "y"
+
+	groups := app.manager.FindDuplicates(fuzzy)
+	if len(groups) == 0 {
+		fmt.Println("没有发现重复记录")
+		return
+	}
+
+	fmt.Printf("发现 %d 组疑似重复记录\n", len(groups))
+	for n, group := range groups {
+		kind := "完全相同"
+		if !group.Exact {
+			kind = "姓名相近"
+		}
+		fmt.Printf("\n第 %d 组（%s）:\n", n+1, kind)
+		for _, id := range group.IDs {
+			student, _ := app.manager.FindByID(id)
+			fmt.Printf("  ID=%d, 姓名=%s, 年龄=%d, 成绩=%v\n",
+				student.ID, student.Name, student.Age, student.Scores)
+		}
+
+		fmt.Print("请输入要保留的ID（回车跳过本组）: ")
+		input := app.readLine()
+		if input == "" {
+			continue
+		}
+		keepID, err := strconv.Atoi(input)
+		if err != nil {
+			fmt.Printf("无效的ID: %v\n", err)
+			continue
+		}
+
+		var mergeIDs []int
+		for _, id := range group.IDs {
+			if id != keepID {
+				mergeIDs = append(mergeIDs, id)
+			}
+		}
+		if len(mergeIDs) == len(group.IDs) {
+			fmt.Printf("ID %d 不在本组中\n", keepID)
+			continue
+		}
+
+		result, err := app.manager.MergeStudents(keepID, mergeIDs)
+		if err != nil {
+			fmt.Printf("合并失败: %v\n", err)
+			continue
+		}
+		fmt.Printf("合并成功 - %s\n", result)
+	}
+}
+
+func (app *App) readQuery() (StudentQuery, bool) {
+	fmt.Print("请输入查询条件（如 age>=18 avg<60 name~张，含空格的值加双引号，留空表示全部）: ")
+	query, err := ParseStudentQuery(app.readLine())
+	if err != nil {
+		fmt.Printf("无效的查询条件: %v\n", err)
+		return nil, false
+	}
+	return query, true
+}
+
+func (app *App) encryptionSettings() {
+	if !app.manager.Can(PermAdmin) {
+		fmt.Println("只有管理员可以修改加密设置")
+		return
+	}
+
+	if !app.manager.Encrypted() {
+		fmt.Print("数据文件当前未加密，是否启用加密？(y/N): ")
+		if strings.ToLower(app.readLine()) != "y" {
+			return
+		}
+		passphrase, ok := app.readNewPassphrase()
+		if !ok {
+			return
+		}
+		if err := app.manager.SetPassphrase(passphrase); err != nil {
+			fmt.Printf("启用加密失败: %v\n", err)
+			return
+		}
+		app.saveData()
+		return
+	}
+
+	fmt.Println("1. 更换密码")
+	fmt.Println("2. 关闭加密")
+	fmt.Print("请选择操作（1-2）: ")
+	choice := app.readLine()
+
+	fmt.Print("请输入当前密码: ")
+	current := app.readLine()
+
+	switch choice {
+	case "1":
+		next, ok := app.readNewPassphrase()
+		if !ok {
+			return
+		}
+		if err := app.manager.RotatePassphrase(current, next); err != nil {
+			fmt.Printf("更换密码失败: %v\n", err)
+			return
+		}
+		fmt.Println("密码已更换，数据已重新加密保存")
+	case "2":
+		if !app.manager.VerifyPassphrase(current) {
+			fmt.Println("密码错误")
+			return
+		}
+		if err := app.manager.SetPassphrase(""); err != nil {
+			fmt.Printf("关闭加密失败: %v\n", err)
+			return
+		}
+		app.saveData()
+	default:
+		fmt.Println("无效的选择")
+	}
+}
+
+func (app *App) manageUsers() {
+	if !app.user.Role.Can(PermAdmin) {
+		fmt.Println("只有管理员可以管理用户")
+		return
+	}
+
+	fmt.Println("1. 查看用户")
+	fmt.Println("2. 添加用户或重置密码")
+	fmt.Println("3. 删除用户")
+	fmt.Print("请选择操作（1-3）: ")
+
+	switch app.readLine() {
+	case "1":
+		for _, user := range app.users.List() {
+			fmt.Printf("%s\t%s\n", user.Username, user.Role)
+		}
+		return
+	case "2":
+		fmt.Print("用户名: ")
+		username := app.readLine()
+		fmt.Print("角色（admin/teacher/viewer）: ")
+		role, err := ParseRole(app.readLine())
+		if err != nil {
+			fmt.Printf("无效的角色: %v\n", err)
+			return
+		}
+		password, ok := app.readNewPassphrase()
+		if !ok {
+			return
+		}
+		if err := app.users.SetUser(username, password, role); err != nil {
+			fmt.Printf("保存用户失败: %v\n", err)
+			return
+		}
+		// SetUser 会替换用户记录，修改的是自己时更新当前会话，新角色立即生效
+		if user, ok := app.users.Get(app.user.Username); ok && user != app.user {
+			app.user = user
+			app.manager.SetUser(user)
+		}
+	case "3":
+		fmt.Print("用户名: ")
+		username := app.readLine()
+		if username == app.user.Username {
+			fmt.Println("不能删除当前登录的用户")
+			return
+		}
+		if err := app.users.DeleteUser(username); err != nil {
+			fmt.Printf("删除用户失败: %v\n", err)
+			return
+		}
+	default:
+		fmt.Println("无效的选择")
+		return
+	}
+
+	if err := app.users.Save(); err != nil {
+		fmt.Printf("保存用户失败: %v\n", err)
+		return
+	}
+	fmt.Println("用户信息已保存")
+}
+
+func (app *App) readNewPassphrase() (string, bool) {
+	fmt.Print("请输入新密码: ")
+	passphrase := app.readLine()
+	fmt.Print("请再次输入新密码: ")
+	if app.readLine() != passphrase {
+		fmt.Println("两次输入的密码不一致")
+		return "", false
+	}
+	if passphrase == "" {
+		fmt.Println("密码不能为空")
+		return "", false
+	}
+	return passphrase, true
+}
+
 func (app *App) saveData() {
 	err := app.manager.SaveToFile()
 	if err != nil {
@@ -325,6 +1771,17 @@ func (app *App) saveData() {
 func (app *App) Run() {
 	fmt.Println("欢迎使用学生管理系统!")
 
+	if err := app.login(); err != nil {
+		fmt.Printf("登录失败: %v\n", err)
+		return
+	}
+
+	// 打开失败时直接退出，避免自动保存覆盖无法解密的数据
+	if err := app.openData(); err != nil {
+		fmt.Printf("无法打开数据文件: %v\n", err)
+		return
+	}
+
 	defer func() {
 		// 程序退出时自动保存
 		app.manager.SaveToFile()
@@ -350,10 +1807,18 @@ func (app *App) Run() {
 		case "7":
 			app.saveData()
 		case "8":
+			app.batchOperations()
+		case "9":
+			app.mergeDuplicates()
+		case "10":
+			app.encryptionSettings()
+		case "11":
+			app.manageUsers()
+		case "12":
 			fmt.Println("感谢使用学生管理系统，再见!")
 			return
 		default:
-			fmt.Println("无效的选择，请输入1-8之间的数字")
+			fmt.Println("无效的选择，请输入1-12之间的数字")
 		}
 	}
 }
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 在临时目录中创建管理员登录的空管理器
+func newTestManager(t *testing.T) *StudentManager {
+	t.Helper()
+	sm, err := OpenStudentManager(filepath.Join(t.TempDir(), "students.json"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.SetUser(&User{Username: "admin", Role: RoleAdmin})
+	return sm
+}
+
+// 依次添加学生，返回按添加顺序排列的ID
+func addStudents(t *testing.T, sm *StudentManager, names ...string) []int {
+	t.Helper()
+	ids := make([]int, 0, len(names))
+	for _, name := range names {
+		student, err := sm.AddStudent(name, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, student.ID)
+	}
+	return ids
+}
+
+func TestListPages(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "a", "b", "c", "d", "e")
+	if err := sm.DeleteStudent(2); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	cursor := 0
+	for pages := 0; ; pages++ {
+		page, next, err := sm.List(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range page {
+			got = append(got, s.ID)
+		}
+		if next == 0 {
+			break
+		}
+		if pages > 5 {
+			t.Fatal("List did not terminate")
+		}
+		cursor = next
+	}
+	if want := []int{1, 3, 4, 5}; !equalInts(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+	if _, _, err := sm.List(0, 0); err == nil {
+		t.Error("List with limit 0 succeeded")
+	}
+}
+
+func TestEach(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "a", "b", "c", "d", "e")
+
+	var got []int
+	sm.Each(func(s *Student) bool {
+		got = append(got, s.ID)
+		return s.ID < 3
+	})
+	if want := []int{1, 2, 3}; !equalInts(got, want) {
+		t.Errorf("stopped early: visited %v, want %v", got, want)
+	}
+
+	// 遍历中删除当前和后面的学生、添加新学生，都不会跳过或重复
+	got = got[:0]
+	sm.Each(func(s *Student) bool {
+		got = append(got, s.ID)
+		if s.ID == 2 {
+			sm.DeleteStudent(2)
+			sm.DeleteStudent(4)
+			sm.AddStudent("f", 20)
+		}
+		return true
+	})
+	if want := []int{1, 2, 3, 5, 6}; !equalInts(got, want) {
+		t.Errorf("visited %v while mutating, want %v", got, want)
+	}
+}
+
+// 批量操作中途失败时所有修改都回滚
+func TestBatchRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(sm *StudentManager) error
+	}{
+		{"导入成绩遇到不存在的学生", func(sm *StudentManager) error {
+			_, err := sm.BatchAddScores(strings.NewReader("1,数学,90\n2,80\n99,70\n"))
+			return err
+		}},
+		{"导入成绩遇到无效分数", func(sm *StudentManager) error {
+			_, err := sm.BatchAddScores(strings.NewReader("# 注释\n1,90\n2,语文,101\n"))
+			return err
+		}},
+		{"调整不存在的科目", func(sm *StudentManager) error {
+			_, err := sm.CurveSubject("物理", 5)
+			return err
+		}},
+		{"年龄变为非正数", func(sm *StudentManager) error {
+			// 第一名学生修改成功，第二名失败
+			_, err := sm.UpdateAgeWhere(StudentQuery{}, -25)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newTestManager(t)
+			addStudents(t, sm, "张三", "李四", "王五")
+			sm.AddScore(1, "数学", 60)
+			sm.students[1].Age = 30
+			before := sm.GetAllStudents()
+			snapshot := make([]Student, len(before))
+			for i, s := range before {
+				snapshot[i] = *s.clone()
+			}
+
+			if err := tt.run(sm); err == nil {
+				t.Fatal("batch operation succeeded, want error")
+			}
+			after := sm.GetAllStudents()
+			if len(after) != len(snapshot) {
+				t.Fatalf("%d students after rollback, want %d", len(after), len(snapshot))
+			}
+			for i, s := range after {
+				if !reflect.DeepEqual(*s, snapshot[i]) {
+					t.Errorf("student %d = %+v after rollback, want %+v", s.ID, *s, snapshot[i])
+				}
+			}
+			// 回滚后 nextID 不变，新学生的ID紧接在原有学生之后
+			if ids := addStudents(t, sm, "赵六"); ids[0] != 4 {
+				t.Errorf("new student got ID %d after rollback, want 4", ids[0])
+			}
+		})
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "张三", "李四", "王五")
+
+	result, err := sm.BatchAddScores(strings.NewReader("# ID,科目,成绩\n2,数学,70\n1,数学,95\n2,语文,80\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(result.AffectedIDs, []int{1, 2}) {
+		t.Errorf("import affected %v, want [1 2]", result.AffectedIDs)
+	}
+
+	result, err = sm.CurveSubject("数学", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := sm.FindByID(1); s.Scores[0] != 100 {
+		t.Errorf("curved score = %v, want capped at 100", s.Scores[0])
+	}
+	if s, _ := sm.FindByID(2); s.Scores[0] != 80 || s.Scores[1] != 80 {
+		t.Errorf("student 2 scores = %v, want [80 80]", s.Scores)
+	}
+
+	query, err := ParseStudentQuery("avg<90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err = sm.DeleteWhere(query); err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(result.AffectedIDs, []int{2, 3}) {
+		t.Errorf("deleted %v, want [2 3]", result.AffectedIDs)
+	}
+	if _, err := sm.DeleteWhere(nil); err == nil {
+		t.Error("DeleteWhere with an empty query succeeded")
+	}
+}
+
+func TestParseStudentQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  StudentQuery
+	}{
+		{"", nil},
+		{"age>=18", StudentQuery{{field: "age", op: ">=", text: "18", num: 18}}},
+		{"AGE<=18", StudentQuery{{field: "age", op: "<=", text: "18", num: 18}}},
+		{"id!=3", StudentQuery{{field: "id", op: "!=", text: "3", num: 3}}},
+		{"name~张", StudentQuery{{field: "name", op: "~", text: "张"}}},
+		{"age>18, avg<60.5", StudentQuery{
+			{field: "age", op: ">", text: "18", num: 18},
+			{field: "avg", op: "<", text: "60.5", num: 60.5},
+		}},
+		{"  name=\"Mary Ann\"\tage=20 ", StudentQuery{
+			{field: "name", op: "=", text: "Mary Ann"},
+			{field: "age", op: "=", text: "20", num: 20},
+		}},
+		{`"name=a,b"`, StudentQuery{{field: "name", op: "=", text: "a,b"}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseStudentQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseStudentQuery(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStudentQuery(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseStudentQueryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"age", "invalid condition"},
+		{"=18", "invalid condition"},
+		{"name~", "empty value"},
+		{`name=""`, "empty value"},
+		{"name<a", "not supported for name"},
+		{"age~1", "only supported for name"},
+		{"age>=abc", "invalid number"},
+		{"grade=3", "unknown field"},
+		{`name="Mary`, "unterminated quote"},
+	}
+	for _, tt := range tests {
+		_, err := ParseStudentQuery(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseStudentQuery(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestStudentQueryMatch(t *testing.T) {
+	student := &Student{ID: 7, Name: "Mary Ann", Age: 19, Scores: []float64{50, 70}}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"name~mary", true},
+		{"name~bob", false},
+		{`name="mary ann"`, true},
+		{"name!=mary", true},
+		{"id=7", true},
+		{"age>19", false},
+		{"age>=19", true},
+		{"avg<60", false},
+		{"avg<=60", true},
+		// 多个条件之间为“且”
+		{"age<20 avg>60", false},
+		{"age<20 avg>=60", true},
+	}
+	for _, tt := range tests {
+		query, err := ParseStudentQuery(tt.query)
+		if err != nil {
+			t.Fatalf("ParseStudentQuery(%q): %v", tt.query, err)
+		}
+		if got := query.Match(student); got != tt.want {
+			t.Errorf("%q matches %+v = %v, want %v", tt.query, *student, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"jonathan smith", "jonathon smith", true},
+		{"katherine", "catherine", true},
+		{"bob", "rob", false},
+		{"张三", "张三", true},
+		{"张三", "张四", false},
+		{"欧阳娜娜", "欧阳娜", true},
+		{"alice", "bob", false},
+	}
+	for _, tt := range tests {
+		if got := similarNames(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarNames(%q, %q) = %v, want %v (distance %d)", tt.a, tt.b, got, tt.want, levenshtein(tt.a, tt.b))
+		}
+	}
+	if d := levenshtein("欧阳娜娜", "欧阳娜"); d != 1 {
+		t.Errorf("levenshtein counts bytes: got %d, want 1", d)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "Alice Smith", "Jonathan Smith", "alice  smith", "Jonathon Smith", "张三", "张四", "Bob")
+	// 年龄不同的同名学生不算重复
+	sm.students[7].Name, sm.students[7].Age = "Alice Smith", 21
+
+	exact := sm.FindDuplicates(false)
+	if want := []DuplicateGroup{{IDs: []int{1, 3}, Exact: true}}; !reflect.DeepEqual(exact, want) {
+		t.Errorf("exact duplicates = %+v, want %+v", exact, want)
+	}
+	fuzzy := sm.FindDuplicates(true)
+	want := []DuplicateGroup{{IDs: []int{1, 3}, Exact: true}, {IDs: []int{2, 4}, Exact: false}}
+	if !reflect.DeepEqual(fuzzy, want) {
+		t.Errorf("fuzzy duplicates = %+v, want %+v", fuzzy, want)
+	}
+}
+
+func TestMergeStudents(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "Jonathan Smith", "Jonathon Smith", "Jonathan  Smith")
+	sm.AddScore(1, "数学", 90)
+	sm.AddScore(2, "语文", 80)
+	sm.AddScore(3, "", 70)
+
+	result, err := sm.MergeStudents(1, []int{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(result.AffectedIDs, []int{2, 3}) {
+		t.Errorf("merged %v, want [2 3]", result.AffectedIDs)
+	}
+	keep, _ := sm.FindByID(1)
+	if !reflect.DeepEqual(keep.Scores, []float64{90, 80, 70}) || !reflect.DeepEqual(keep.Subjects, []string{"数学", "语文", ""}) {
+		t.Errorf("merged scores %v subjects %q", keep.Scores, keep.Subjects)
+	}
+	if !equalInts(keep.MergedIDs, []int{2, 3}) || len(sm.GetAllStudents()) != 1 {
+		t.Errorf("merged IDs %v, %d students left", keep.MergedIDs, len(sm.GetAllStudents()))
+	}
+
+	// 其中一个ID无效时整体回滚
+	addStudents(t, sm, "Jon Smith")
+	sm.AddScore(4, "英语", 60)
+	if _, err := sm.MergeStudents(1, []int{4, 99}); err == nil {
+		t.Fatal("merging a missing student succeeded")
+	}
+	keep, _ = sm.FindByID(1)
+	if _, ok := sm.FindByID(4); !ok || len(keep.Scores) != 3 || len(keep.MergedIDs) != 2 {
+		t.Errorf("failed merge was not rolled back: %+v", *keep)
+	}
+	if _, err := sm.MergeStudents(1, []int{1}); err == nil {
+		t.Error("merging a student into itself succeeded")
+	}
+}
+
+// RFC 7914 第11节的 PBKDF2-HMAC-SHA256 测试向量
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "张三", "李四")
+	sm.AddScore(1, "数学", 95)
+	if err := sm.SetPassphrase("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := mustReadFile(t, sm.filename)
+	if encrypted, err := IsEncryptedFile(sm.filename); err != nil || !encrypted {
+		t.Fatalf("IsEncryptedFile = %v, %v; want true", encrypted, err)
+	}
+	if bytes.Contains(data, []byte("张三")) {
+		t.Error("encrypted file contains a student name in plain text")
+	}
+	if info, err := os.Stat(sm.filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("encrypted file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	loaded, err := OpenStudentManager(sm.filename, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := loaded.FindByID(1); !ok || s.Name != "张三" || s.Scores[0] != 95 || loaded.nextID != 3 {
+		t.Errorf("decrypted student 1 = %+v, nextID %d", s, loaded.nextID)
+	}
+
+	if _, err := OpenStudentManager(sm.filename, "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: err = %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := OpenStudentManager(sm.filename, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("no passphrase: err = %v, want ErrPassphraseRequired", err)
+	}
+
+	// 篡改密文或把迭代次数改到范围之外都无法打开
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	tampered := file
+	tampered.Ciphertext = append([]byte(nil), file.Ciphertext...)
+	tampered.Ciphertext[0] ^= 1
+	weak := file
+	weak.Iterations = 1
+	for name, f := range map[string]encryptedFile{"tampered": tampered, "weak": weak} {
+		data, _ := json.Marshal(f)
+		if _, err := decryptData(data, "correct horse"); err == nil {
+			t.Errorf("%s file decrypted", name)
+		}
+	}
+}
+
+func TestRotatePassphrase(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "张三")
+	sm.SetPassphrase("old secret")
+
+	if err := sm.RotatePassphrase("not it", "new secret"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("rotate with wrong passphrase: err = %v", err)
+	}
+	if err := sm.RotatePassphrase("old secret", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStudentManager(sm.filename, "new secret"); err != nil {
+		t.Errorf("open with rotated passphrase: %v", err)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role                     Role
+		view, edit, del, isAdmin bool
+	}{
+		{RoleAdmin, true, true, true, true},
+		{RoleTeacher, true, true, false, false},
+		{RoleViewer, true, false, false, false},
+		{Role("guest"), false, false, false, false},
+	}
+	for _, tt := range tests {
+		for perm, want := range map[Permission]bool{PermView: tt.view, PermEdit: tt.edit, PermDelete: tt.del, PermAdmin: tt.isAdmin} {
+			if got := tt.role.Can(perm); got != want {
+				t.Errorf("%s.Can(%s) = %v, want %v", tt.role, perm, got, want)
+			}
+		}
+	}
+	if role, err := ParseRole(" Teacher "); err != nil || role != RoleTeacher {
+		t.Errorf("ParseRole = %q, %v", role, err)
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("ParseRole accepted an unknown role")
+	}
+}
+
+// 管理器的修改操作按当前用户的角色检查权限
+func TestManagerAuthorization(t *testing.T) {
+	sm := newTestManager(t)
+	addStudents(t, sm, "张三")
+
+	operations := []struct {
+		name string
+		perm Permission
+		run  func() error
+	}{
+		{"AddStudent", PermEdit, func() error { _, err := sm.AddStudent("李四", 20); return err }},
+		{"AddScore", PermEdit, func() error { _, err := sm.AddScore(1, "数学", 90); return err }},
+		{"CurveSubject", PermEdit, func() error { _, err := sm.CurveSubject("数学", 1); return err }},
+		{"DeleteStudent", PermDelete, func() error { return sm.DeleteStudent(99) }},
+		{"MergeStudents", PermDelete, func() error { _, err := sm.MergeStudents(1, nil); return err }},
+		{"SetPassphrase", PermAdmin, func() error { return sm.SetPassphrase("") }},
+	}
+	users := []*User{nil, {Username: "v", Role: RoleViewer}, {Username: "t", Role: RoleTeacher}, {Username: "a", Role: RoleAdmin}}
+	for _, user := range users {
+		sm.SetUser(user)
+		for _, op := range operations {
+			allowed := user != nil && user.Role.Can(op.perm)
+			err := op.run()
+			if denied := errors.Is(err, ErrPermissionDenied); denied == allowed {
+				t.Errorf("%s as %+v: err = %v, want allowed=%v", op.name, user, err, allowed)
+			}
+		}
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	users, err := LoadUserStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !users.Empty() {
+		t.Fatal("new user store is not empty")
+	}
+	if err := users.SetUser("admin", "short", RoleAdmin); err == nil {
+		t.Error("accepted a 5 character password")
+	}
+	if err := users.SetUser("admin", "admin-secret", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUser("teacher", "teacher-secret", RoleTeacher); err != nil {
+		t.Fatal(err)
+	}
+
+	// 最后一个管理员不能被删除或降级
+	if err := users.DeleteUser("admin"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("DeleteUser(last admin): err = %v", err)
+	}
+	if err := users.SetUser("admin", "admin-secret", RoleViewer); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demote last admin: err = %v", err)
+	}
+	if err := users.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUserStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := loaded.Authenticate("teacher", "teacher-secret")
+	if err != nil || user.Role != RoleTeacher {
+		t.Errorf("Authenticate(teacher) = %+v, %v", user, err)
+	}
+	if _, err := loaded.Authenticate("teacher", "admin-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+	if _, err := loaded.Authenticate("nobody", "teacher-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user: err = %v", err)
+	}
+	if bytes.Contains(mustReadFile(t, filename), []byte("teacher-secret")) {
+		t.Error("user file contains a plain text password")
+	}
+}
+
+func mustReadFile(t *testing.T, filename string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+异常检测与告警：
+
+按日志自身的时间把请求划分到固定宽度的时间窗口（默认1分钟），
+每个窗口结束时与之前若干个窗口组成的滚动基线比较：
+
+- 错误率：窗口内 5xx 占比的 z 分数超过阈值，且比基线至少高出 MinErrorRateDelta
+- 流量：请求数的 z 分数超过阈值为突增，低于负阈值为骤降
+
+基线的标准差有下限（错误率按二项分布、请求数按泊松分布估计），
+避免基线非常平稳时一点波动就告警。
+异常开始时发送一次告警，恢复正常时发送一次 Resolved 告警。
+告警在锁内生成、在锁外发送，webhook 响应慢时不会阻塞其他协程的分析。
+
+AnomalyDetector 包装另一个分析器，统计结果仍由内部分析器负责。
+窗口需要按时间顺序处理，所以它不支持分片，在并发流水线中由单个协程聚合。
+
+窗口通常在更晚的日志到达时结束。跟踪模式下服务完全中断时不再有日志，
+所以 StartTicker 还会按墙上时间推进：以最后一条日志的时间加上此后经过的
+墙上时间估计当前的日志时间，超过窗口结束时间 Window/2（留给迟到的日志）
+仍没有新日志时，把这段时间按空窗口结束，流量骤降告警因此能够触发。
+*/
+
+type AnomalyConfig struct {
+	Window            time.Duration // 窗口宽度，默认1分钟
+	Baseline          int           // 基线使用的历史窗口数，默认30
+	MinBaseline       int           // 历史窗口少于该数量时不检测，默认5
+	Threshold         float64       // z 分数阈值，默认3
+	MinRequests       int64         // 请求数少于该值的窗口不检测错误率，默认20
+	MinErrorRateDelta float64       // 错误率至少比基线高出的比例，默认0.02
+}
+
+func (c AnomalyConfig) withDefaults() AnomalyConfig {
+	if c.Window <= 0 {
+		c.Window = time.Minute
+	}
+	if c.Baseline <= 0 {
+		c.Baseline = 30
+	}
+	if c.MinBaseline <= 0 {
+		c.MinBaseline = 5
+	}
+	if c.MinBaseline > c.Baseline {
+		c.MinBaseline = c.Baseline
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 3
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.MinErrorRateDelta <= 0 {
+		c.MinErrorRateDelta = 0.02
+	}
+	return c
+}
+
+type AlertKind string
+
+const (
+	AlertErrorRate    AlertKind = "error_rate"
+	AlertTrafficSpike AlertKind = "traffic_spike"
+	AlertTrafficDrop  AlertKind = "traffic_drop"
+)
+
+// 告警事件
+type Alert struct {
+	Kind        AlertKind `json:"kind"`
+	Resolved    bool      `json:"resolved"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Requests    int64     `json:"requests"`
+	Errors      int64     `json:"errors"`
+	Value       float64   `json:"value"`    // 错误率或请求数
+	Baseline    float64   `json:"baseline"` // 基线均值
+	StdDev      float64   `json:"stddev"`
+	ZScore      float64   `json:"zscore"`
+}
+
+func (a Alert) String() string {
+	state := "FIRING"
+	if a.Resolved {
+		state = "RESOLVED"
+	}
+	window := fmt.Sprintf("%s ~ %s", a.WindowStart.Format(time.RFC3339), a.WindowEnd.Format("15:04:05"))
+	if a.Kind == AlertErrorRate {
+		return fmt.Sprintf("[%s] %s %s: 5xx %.2f%% (%d/%d), baseline %.2f%%, z=%.1f",
+			state, a.Kind, window, a.Value*100, a.Errors, a.Requests, a.Baseline*100, a.ZScore)
+	}
+	return fmt.Sprintf("[%s] %s %s: %d requests, baseline %.1f, z=%.1f",
+		state, a.Kind, window, a.Requests, a.Baseline, a.ZScore)
+}
+
+// 告警的输出目标
+type AlertSink interface {
+	Send(alert Alert) error
+}
+
+// 每条告警输出一行文字
+type WriterSink struct {
+	W io.Writer
+}
+
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{W: os.Stdout}
+}
+
+func (s *WriterSink) Send(alert Alert) error {
+	_, err := fmt.Fprintln(s.W, alert)
+	return err
+}
+
+// 以 JSON Lines 格式追加到文件
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(filename string) (*FileSink, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open alert file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Send(alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write alert: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// 发送 HTTP POST 的接口，*http.Client 满足该接口，测试时可以替换
+type WebhookPoster interface {
+	Post(url, contentType string, body io.Reader) (*http.Response, error)
+}
+
+// 以 JSON 格式 POST 到 webhook 地址
+type WebhookSink struct {
+	URL    string
+	Client WebhookPoster // 为 nil 时使用10秒超时的 http.Client
+}
+
+func (s *WebhookSink) Send(alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// 同时发送到多个目标，返回第一个错误
+type MultiSink []AlertSink
+
+func (m MultiSink) Send(alert Alert) error {
+	var firstErr error
+	for _, sink := range m {
+		if err := sink.Send(alert); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ---------- 检测器 ----------
+
+type windowStats struct {
+	start    time.Time
+	requests int64
+	errors   int64
+}
+
+type AnomalyDetector struct {
+	// 发送告警失败时调用，为 nil 时忽略
+	OnError func(err error)
+
+	inner  LogAnalyzer
+	sink   AlertSink
+	config AnomalyConfig
+
+	mu      sync.Mutex
+	current *windowStats
+	history []windowStats // 最近 config.Baseline 个已结束的窗口，按时间顺序
+	active  map[AlertKind]bool
+	pending []Alert // 已生成、尚未发送的告警
+
+	lastSeen    time.Time        // 最晚的日志时间
+	lastArrival time.Time        // 收到最晚那条日志时的墙上时间
+	now         func() time.Time // 墙上时间，测试时可以替换
+	stop, done  chan struct{}
+
+	sendMu sync.Mutex // 保证告警按生成顺序发送
+}
+
+func NewAnomalyDetector(inner LogAnalyzer, sink AlertSink, config AnomalyConfig) *AnomalyDetector {
+	return &AnomalyDetector{
+		inner:  inner,
+		sink:   sink,
+		config: config.withDefaults(),
+		active: make(map[AlertKind]bool),
+		now:    time.Now,
+	}
+}
+
+func (d *AnomalyDetector) Analyze(entries []*LogEntry) *AnalysisResult {
+	d.mu.Lock()
+	for _, entry := range entries {
+		d.observe(entry)
+	}
+	alerts := d.takePending()
+	d.mu.Unlock()
+	d.send(alerts)
+	return d.inner.Analyze(entries)
+}
+
+// 结束当前窗口并检测，在数据流结束时调用
+func (d *AnomalyDetector) Flush() {
+	d.mu.Lock()
+	if d.current != nil {
+		d.closeWindow()
+		d.current = nil
+	}
+	alerts := d.takePending()
+	d.mu.Unlock()
+	d.send(alerts)
+}
+
+func (d *AnomalyDetector) unwrap() LogAnalyzer {
+	return d.inner
+}
+
+// 每隔 interval 按墙上时间推进窗口，直到 Stop
+func (d *AnomalyDetector) StartTicker(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	d.stop, d.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.tick()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止 StartTicker 启动的协程，等它退出后返回
+func (d *AnomalyDetector) Stop() {
+	d.mu.Lock()
+	stop, done := d.stop, d.done
+	d.stop, d.done = nil, nil
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// 按墙上时间估计当前的日志时间，结束早已过去却没有日志的窗口
+func (d *AnomalyDetector) tick() {
+	d.mu.Lock()
+	if d.current != nil {
+		logNow := d.lastSeen.Add(d.now().Sub(d.lastArrival))
+		if start := logNow.Add(-d.config.Window / 2).Truncate(d.config.Window); start.After(d.current.start) {
+			d.advanceTo(start)
+		}
+	}
+	alerts := d.takePending()
+	d.mu.Unlock()
+	d.send(alerts)
+}
+
+// 取出待发送的告警，调用时持有 d.mu
+func (d *AnomalyDetector) takePending() []Alert {
+	alerts := d.pending
+	d.pending = nil
+	return alerts
+}
+
+// 发送告警，调用时不持有 d.mu
+func (d *AnomalyDetector) send(alerts []Alert) {
+	if len(alerts) == 0 || d.sink == nil {
+		return
+	}
+	d.sendMu.Lock()
+	defer d.sendMu.Unlock()
+	for _, alert := range alerts {
+		if err := d.sink.Send(alert); err != nil && d.OnError != nil {
+			d.OnError(err)
+		}
+	}
+}
+
+func (d *AnomalyDetector) observe(entry *LogEntry) {
+	if entry.Timestamp.IsZero() {
+		return
+	}
+	if entry.Timestamp.After(d.lastSeen) {
+		d.lastSeen = entry.Timestamp
+		d.lastArrival = d.now()
+	}
+	start := entry.Timestamp.Truncate(d.config.Window)
+	if d.current == nil {
+		d.current = &windowStats{start: start}
+	}
+
+	// 早于当前窗口的乱序日志计入当前窗口
+	if start.After(d.current.start) {
+		d.advanceTo(start)
+	}
+
+	d.current.requests++
+	if entry.StatusCode >= 500 {
+		d.current.errors++
+	}
+}
+
+// 结束当前窗口，中间没有请求的窗口按0计入（流量骤降需要它们），
+// 然后从 start 开始新的窗口
+func (d *AnomalyDetector) advanceTo(start time.Time) {
+	d.closeWindow()
+	next := d.current.start.Add(d.config.Window)
+	if gap := int(start.Sub(next) / d.config.Window); gap > d.config.Baseline {
+		next = start.Add(-time.Duration(d.config.Baseline) * d.config.Window)
+	}
+	for ; next.Before(start); next = next.Add(d.config.Window) {
+		d.current = &windowStats{start: next}
+		d.closeWindow()
+	}
+	d.current = &windowStats{start: start}
+}
+
+func (d *AnomalyDetector) closeWindow() {
+	w := *d.current
+	if len(d.history) >= d.config.MinBaseline {
+		d.detect(w)
+	}
+	d.history = append(d.history, w)
+	if len(d.history) > d.config.Baseline {
+		d.history = d.history[1:]
+	}
+}
+
+func (d *AnomalyDetector) detect(w windowStats) {
+	alert := Alert{
+		WindowStart: w.start,
+		WindowEnd:   w.start.Add(d.config.Window),
+		Requests:    w.requests,
+		Errors:      w.errors,
+	}
+
+	// 流量
+	var counts []float64
+	for _, h := range d.history {
+		counts = append(counts, float64(h.requests))
+	}
+	mean, std := meanStdDev(counts)
+	std = math.Max(std, math.Max(math.Sqrt(mean), 1))
+	traffic := alert
+	traffic.Value, traffic.Baseline, traffic.StdDev = float64(w.requests), mean, std
+	traffic.ZScore = (traffic.Value - mean) / std
+	d.update(AlertTrafficSpike, traffic.ZScore >= d.config.Threshold, traffic)
+	d.update(AlertTrafficDrop, traffic.ZScore <= -d.config.Threshold, traffic)
+
+	// 错误率：请求太少的窗口不参与
+	if w.requests < d.config.MinRequests {
+		return
+	}
+	var rates []float64
+	for _, h := range d.history {
+		if h.requests >= d.config.MinRequests {
+			rates = append(rates, float64(h.errors)/float64(h.requests))
+		}
+	}
+	if len(rates) < d.config.MinBaseline {
+		return
+	}
+	mean, std = meanStdDev(rates)
+	std = math.Max(std, math.Max(math.Sqrt(mean*(1-mean)/float64(w.requests)), 1e-3))
+	errRate := alert
+	errRate.Kind = AlertErrorRate
+	errRate.Value, errRate.Baseline, errRate.StdDev = float64(w.errors)/float64(w.requests), mean, std
+	errRate.ZScore = (errRate.Value - mean) / std
+	d.update(AlertErrorRate, errRate.ZScore >= d.config.Threshold && errRate.Value-mean >= d.config.MinErrorRateDelta, errRate)
+}
+
+// 状态变化时生成告警，由调用方在释放锁之后发送
+func (d *AnomalyDetector) update(kind AlertKind, anomalous bool, alert Alert) {
+	if anomalous == d.active[kind] {
+		return
+	}
+	d.active[kind] = anomalous
+	alert.Kind = kind
+	alert.Resolved = !anomalous
+	d.pending = append(d.pending, alert)
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 把告警保存在内存中
+type MemorySink struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (s *MemorySink) Send(alert Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = append(s.alerts, alert)
+	return nil
+}
+
+func (s *MemorySink) Alerts() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Alert(nil), s.alerts...)
+}
+
+// 记录请求而不真正发送的 webhook 客户端
+type recordingPoster struct {
+	bodies []string
+}
+
+func (p *recordingPoster) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	p.bodies = append(p.bodies, string(data))
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(bytes.NewReader(nil))}, nil
+}
+
+var anomalyTestBase = time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+
+// 一分钟的请求：每 errorEvery 个请求中有一个 503
+func minuteBatch(minute, requests, errorEvery int) []*LogEntry {
+	batch := make([]*LogEntry, 0, requests)
+	for i := 0; i < requests; i++ {
+		status := 200
+		if i%errorEvery == 0 {
+			status = 503
+		}
+		batch = append(batch, &LogEntry{
+			Timestamp:  anomalyTestBase.Add(time.Duration(minute)*time.Minute + time.Duration(i)*time.Second/2),
+			StatusCode: status,
+		})
+	}
+	return batch
+}
+
+func alertKeys(alerts []Alert) map[string]int {
+	keys := make(map[string]int)
+	for _, a := range alerts {
+		keys[fmt.Sprintf("%s/%v", a.Kind, a.Resolved)]++
+	}
+	return keys
+}
+
+// 平稳流量中插入一段 5xx 突增和一段流量骤降
+func TestAnomalyDetection(t *testing.T) {
+	memory := &MemorySink{}
+	poster := &recordingPoster{}
+	sink := MultiSink{memory, &WebhookSink{URL: "http://alerts.example/hook", Client: poster}}
+	detector := NewAnomalyDetector(NewBasicAnalyzer(), sink, AnomalyConfig{})
+
+	for minute := 0; minute < 60; minute++ {
+		requests, errorEvery := 100, 50 // 平时 2% 的错误
+		switch {
+		case minute >= 30 && minute < 33:
+			errorEvery = 4 // 25% 的错误
+		case minute >= 45 && minute < 47:
+			requests = 10
+		}
+		detector.Analyze(minuteBatch(minute, requests, errorEvery))
+	}
+	detector.Flush()
+
+	got := alertKeys(memory.Alerts())
+	want := []string{"error_rate/false", "error_rate/true", "traffic_drop/false", "traffic_drop/true"}
+	if len(memory.Alerts()) != len(want) {
+		t.Errorf("got %d alerts %v, want %v", len(memory.Alerts()), got, want)
+	}
+	for _, key := range want {
+		if got[key] != 1 {
+			t.Errorf("alert %s sent %d times, want 1", key, got[key])
+		}
+	}
+	if len(poster.bodies) != len(want) {
+		t.Errorf("webhook received %d alerts, want %d", len(poster.bodies), len(want))
+	}
+}
+
+// 阻塞在 Send 中直到 release 被关闭
+type blockingSink struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSink) Send(Alert) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return nil
+}
+
+// 发送告警时不持有锁，其他协程仍然可以分析
+func TestAnomalyDetectorSendsOutsideLock(t *testing.T) {
+	sink := &blockingSink{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	detector := NewAnomalyDetector(NewBasicAnalyzer(), sink, AnomalyConfig{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for minute := 0; minute < 40; minute++ {
+			errorEvery := 50
+			if minute >= 30 {
+				errorEvery = 4
+			}
+			detector.Analyze(minuteBatch(minute, 100, errorEvery))
+		}
+	}()
+
+	select {
+	case <-sink.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert was sent")
+	}
+	analyzed := make(chan struct{})
+	go func() {
+		detector.Analyze(nil)
+		close(analyzed)
+	}()
+	select {
+	case <-analyzed:
+	case <-time.After(5 * time.Second):
+		t.Error("Analyze blocked while another goroutine was sending an alert")
+	}
+	close(sink.release)
+	<-done
+}
+
+// 日志完全停止时，按墙上时间结束空窗口，流量骤降告警仍然触发
+func TestAnomalyDetectorTickDetectsOutage(t *testing.T) {
+	memory := &MemorySink{}
+	detector := NewAnomalyDetector(NewBasicAnalyzer(), memory, AnomalyConfig{})
+	wall := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return wall }
+
+	for minute := 0; minute < 40; minute++ {
+		detector.Analyze(minuteBatch(minute, 100, 50))
+		wall = wall.Add(time.Minute)
+	}
+	if alerts := memory.Alerts(); len(alerts) != 0 {
+		t.Fatalf("unexpected alerts before outage: %v", alertKeys(alerts))
+	}
+
+	// 一分钟后最后一个窗口正常结束，不产生告警
+	detector.tick()
+	if alerts := memory.Alerts(); len(alerts) != 0 {
+		t.Fatalf("closing the last window sent %v", alertKeys(alerts))
+	}
+
+	for i := 0; i < 3; i++ {
+		wall = wall.Add(time.Minute)
+		detector.tick()
+	}
+	got := alertKeys(memory.Alerts())
+	if got["traffic_drop/false"] != 1 || len(got) != 1 {
+		t.Errorf("alerts after outage = %v, want one traffic_drop", got)
+	}
+
+	// 实际的协程也能启动和停止
+	detector.StartTicker(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	detector.Stop()
+}
+
+// ProcessorConfig.Anomaly 创建检测器，处理完成后 Flush 结束最后的窗口
+func TestProcessorAnomalyConfig(t *testing.T) {
+	generator, err := NewLogGenerator(GeneratorConfig{Seed: 4, Lines: 50000, Duration: 3 * time.Hour, Bursts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	if _, err := generator.WriteTo(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	memory := &MemorySink{}
+	processor, err := CreateProcessor(ProcessorConfig{
+		ParserType: "combined",
+		Sessions:   &SessionConfig{},
+		Anomaly:    &AnomalyConfig{},
+		Alerts:     memory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processor.anomalyDetector() == nil {
+		t.Fatal("CreateProcessor did not install an AnomalyDetector")
+	}
+	result, err := processor.ProcessReader(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.Flush()
+
+	burst := generator.Bursts()[0]
+	fired := false
+	for _, a := range memory.Alerts() {
+		if a.Kind == AlertErrorRate && !a.Resolved && burst.Contains(a.WindowStart) {
+			fired = true
+		}
+	}
+	if !fired {
+		t.Errorf("no error_rate alert during burst %v ~ %v, got %v", burst.Start, burst.End, alertKeys(memory.Alerts()))
+	}
+	// 两层包装之后，内部分析器的统计和会话统计都在
+	if result.TotalRequests == 0 || result.Sessions == nil || result.Sessions.Sessions == 0 {
+		t.Errorf("result = %d requests, sessions %+v", result.TotalRequests, result.Sessions)
+	}
+}
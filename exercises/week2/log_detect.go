@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+自动识别日志格式：
+
+先用文件开头的若干行做样本，统计每种解析器能解析的行数，
+按匹配数从高到低排列解析器。之后每一行都按这个顺序尝试，
+第一个解析成功的格式记录在 LogEntry.Format 中。
+
+每行的匹配统计用原子计数，并发解析时不需要加锁；
+读锁只在取解析顺序时短暂持有，Detect 之后顺序不再改变。
+LogProcessor.FormatReport 取出统计，命令行把它放进 AnalysisResult.Formats，
+各种报告格式都会列出每种格式的行数。
+*/
+
+// 默认的样本行数
+const defaultSampleSize = 100
+
+// 参与识别的一种格式
+type NamedParser struct {
+	Name   string
+	Parser LogParser
+}
+
+type AutoDetectParser struct {
+	SampleSize int // 为0时使用 defaultSampleSize
+
+	mu        sync.RWMutex
+	parsers   []NamedParser     // 按样本匹配数从高到低排列
+	counts    map[string]*int64 // 创建后不再增删键，值用原子操作累加
+	unmatched int64
+}
+
+// 创建自动识别解析器，候选格式为 json、combined、common 以及给出的 Nginx log_format
+func NewAutoDetectParser(customFormats ...string) (*AutoDetectParser, error) {
+	parsers := []NamedParser{
+		{Name: "json", Parser: &JSONLogParser{}},
+		{Name: "combined", Parser: &CombinedLogParser{}},
+		{Name: "common", Parser: &CommonLogParser{}},
+	}
+	for i, format := range customFormats {
+		parser, err := NewNginxFormatParser(format)
+		if err != nil {
+			return nil, err
+		}
+		name := "custom"
+		if len(customFormats) > 1 {
+			name = fmt.Sprintf("custom%d", i+1)
+		}
+		parsers = append(parsers, NamedParser{Name: name, Parser: parser})
+	}
+	return NewAutoDetectParserWith(parsers...), nil
+}
+
+// 使用指定的候选解析器，顺序即为识别前的默认尝试顺序
+func NewAutoDetectParserWith(parsers ...NamedParser) *AutoDetectParser {
+	counts := make(map[string]*int64, len(parsers))
+	for _, np := range parsers {
+		counts[np.Name] = new(int64)
+	}
+	return &AutoDetectParser{
+		parsers: parsers,
+		counts:  counts,
+	}
+}
+
+// 用样本行确定尝试顺序，返回匹配最多的格式名；没有任何格式匹配时返回空字符串
+func (p *AutoDetectParser) Detect(sample []string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matches := make(map[string]int, len(p.parsers))
+	for _, line := range sample {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, np := range p.parsers {
+			if _, err := np.Parser.Parse(line); err == nil {
+				matches[np.Name]++
+			}
+		}
+	}
+
+	ordered := append([]NamedParser(nil), p.parsers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return matches[ordered[i].Name] > matches[ordered[j].Name]
+	})
+	p.parsers = ordered
+
+	if len(ordered) == 0 || matches[ordered[0].Name] == 0 {
+		return ""
+	}
+	return ordered[0].Name
+}
+
+// 用输入开头的样本行识别格式，不消耗 br 中的数据。
+// br 应当是已经解压的数据，ProcessReader 在开始处理前调用
+func (p *AutoDetectParser) SampleReader(br *bufio.Reader) string {
+	size := p.SampleSize
+	if size <= 0 {
+		size = defaultSampleSize
+	}
+	return p.Detect(peekLines(br, size))
+}
+
+// 按当前顺序逐个尝试，单行不匹配首选格式时自动回退到其他格式
+func (p *AutoDetectParser) Parse(line string) (*LogEntry, error) {
+	p.mu.RLock()
+	parsers := p.parsers
+	p.mu.RUnlock()
+
+	var firstErr error
+	for _, np := range parsers {
+		entry, err := np.Parser.Parse(line)
+		if err == nil {
+			entry.Format = np.Name
+			p.record(np.Name)
+			return entry, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	p.record("")
+	if firstErr == nil {
+		firstErr = &LogProcessingError{Line: line, Cause: fmt.Errorf("%w: no parsers configured", ErrMalformedLine)}
+	}
+	return nil, firstErr
+}
+
+func (p *AutoDetectParser) record(format string) {
+	if format == "" {
+		atomic.AddInt64(&p.unmatched, 1)
+		return
+	}
+	atomic.AddInt64(p.counts[format], 1)
+}
+
+// 所有格式都无法解析的行在报告中的名称
+const unmatchedFormat = "unmatched"
+
+// 每种格式匹配的行数，unmatchedFormat 为所有格式都无法解析的行数
+func (p *AutoDetectParser) Report() map[string]int64 {
+	report := make(map[string]int64, len(p.counts)+1)
+	for name, counter := range p.counts {
+		if n := atomic.LoadInt64(counter); n > 0 {
+			report[name] = n
+		}
+	}
+	if n := atomic.LoadInt64(&p.unmatched); n > 0 {
+		report[unmatchedFormat] = n
+	}
+	return report
+}
+
+// 能统计每种格式匹配行数的解析器
+type formatReporter interface {
+	Report() map[string]int64
+}
+
+// 自动识别格式时每种格式匹配的行数，在多次处理之间累计；其他解析器返回 nil
+func (p *LogProcessor) FormatReport() map[string]int64 {
+	if reporter, ok := p.parser.(formatReporter); ok {
+		return reporter.Report()
+	}
+	return nil
+}
+
+func (r *AnalysisResult) mergeFormats(other *AnalysisResult) {
+	if len(other.Formats) == 0 {
+		return
+	}
+	if r.Formats == nil {
+		r.Formats = make(map[string]int64, len(other.Formats))
+	}
+	for format, n := range other.Formats {
+		r.Formats[format] += n
+	}
+}
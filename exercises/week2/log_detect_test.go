@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 以 common 为主、夹杂 JSON 和无法解析的行
+func mixedFormatLog(t *testing.T) string {
+	t.Helper()
+	var data strings.Builder
+	for _, config := range []GeneratorConfig{
+		{Seed: 1, Format: "common", Lines: 300, MalformedRate: -1},
+		{Seed: 2, Format: "json", Lines: 100, MalformedRate: -1},
+	} {
+		generator, err := NewLogGenerator(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := generator.WriteTo(&data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data.WriteString("not a log line\n\n")
+	return data.String()
+}
+
+func TestAutoDetectParser(t *testing.T) {
+	parser, err := NewAutoDetectParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := mixedFormatLog(t)
+	if got := parser.SampleReader(bufio.NewReader(strings.NewReader(data))); got != "common" {
+		t.Errorf("detected %q, want common", got)
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		entry, err := parser.Parse(line)
+		if err == nil && entry.Format == "" {
+			t.Errorf("entry parsed from %q has no format", line)
+		}
+	}
+	want := map[string]int64{"common": 300, "json": 100, unmatchedFormat: 1}
+	if got := parser.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Report() = %v, want %v", got, want)
+	}
+}
+
+// 处理器把统计交给报告，各种输出格式都列出每种格式的行数
+func TestFormatReport(t *testing.T) {
+	processor, err := CreateProcessor(ProcessorConfig{ParserType: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.SetConcurrency(4)
+	result, err := processor.ProcessReader(strings.NewReader(mixedFormatLog(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.Formats = processor.FormatReport()
+
+	d := newReportData(result, 0)
+	want := []formatCount{
+		{Format: "common", Lines: 300, Percent: 300.0 * 100 / 401},
+		{Format: "json", Lines: 100, Percent: 100.0 * 100 / 401},
+		{Format: unmatchedFormat, Lines: 1, Percent: 100.0 / 401},
+	}
+	if !reflect.DeepEqual(d.Formats, want) {
+		t.Errorf("report formats = %+v, want %+v", d.Formats, want)
+	}
+
+	var text strings.Builder
+	if err := (&TextRenderer{}).Render(&text, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "== 日志格式 ==") || !strings.Contains(text.String(), "unmatched") {
+		t.Errorf("text report has no format section:\n%s", text.String())
+	}
+
+	// 合并结果时格式统计相加；固定格式的解析器没有统计
+	clone := result.Clone()
+	clone.Merge(result)
+	if clone.Formats["common"] != 600 {
+		t.Errorf("merged common lines = %d, want 600", clone.Formats["common"])
+	}
+	fixed, err := CreateProcessor(ProcessorConfig{ParserType: "common"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := fixed.FormatReport(); report != nil {
+		t.Errorf("FormatReport() for a fixed format = %v, want nil", report)
+	}
+}
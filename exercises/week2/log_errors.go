@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+错误处理策略：
+
+解析失败或超长的行如何处理由 ErrorPolicy 决定：
+
+- SkipErrors（默认）：跳过并计数
+- FailFast：遇到第一个错误即停止，返回带行号的 *LogProcessingError
+- QuarantineErrors：跳过，同时把原始行写入隔离文件，修正后可以重新处理
+- MaxErrorRate：与前面的模式组合，错误行占比超过阈值时中止处理，
+  返回 ErrErrorBudgetExceeded；处理的行数少于 MinLines 时，等到处理结束再检查
+
+每次处理结束后，ErrorSummary 按原因（哨兵错误）统计错误数量。
+并发处理时的中止通过取消流水线实现，输出通道关闭且不发送最终结果，
+调用方通过 Err 取得原因。
+*/
+
+type ErrorMode int
+
+const (
+	SkipErrors       ErrorMode = iota // 跳过并计数
+	FailFast                          // 遇到第一个错误即停止
+	QuarantineErrors                  // 跳过并写入隔离文件
+)
+
+func (m ErrorMode) String() string {
+	switch m {
+	case FailFast:
+		return "fail-fast"
+	case QuarantineErrors:
+		return "quarantine"
+	}
+	return "skip"
+}
+
+func ParseErrorMode(s string) (ErrorMode, error) {
+	switch strings.ToLower(s) {
+	case "", "skip":
+		return SkipErrors, nil
+	case "fail-fast":
+		return FailFast, nil
+	case "quarantine":
+		return QuarantineErrors, nil
+	}
+	return SkipErrors, fmt.Errorf("unknown error mode %q (want skip, fail-fast or quarantine)", s)
+}
+
+type ErrorPolicy struct {
+	Mode       ErrorMode
+	Quarantine io.Writer // QuarantineErrors 模式下写入无法解析的原始行，每行一条
+
+	MaxErrorRate float64 // 大于0时，错误行占比超过该值即中止处理
+	MinLines     int64   // 处理多少行之后开始检查错误率，默认1000，避免开头几行出错就中止
+}
+
+// 检查错误率前默认至少处理的行数
+const defaultMinErrorLines = 1000
+
+var ErrErrorBudgetExceeded = errors.New("error rate exceeds budget")
+
+// 设置错误处理策略，对之后的处理生效
+func (p *LogProcessor) SetErrorPolicy(policy ErrorPolicy) error {
+	if policy.Mode == QuarantineErrors && policy.Quarantine == nil {
+		return fmt.Errorf("quarantine mode requires a writer")
+	}
+	if policy.MaxErrorRate < 0 || policy.MaxErrorRate > 1 {
+		return fmt.Errorf("max error rate must be between 0 and 1, got %g", policy.MaxErrorRate)
+	}
+	if policy.MinLines <= 0 {
+		policy.MinLines = defaultMinErrorLines
+	}
+	p.errs.policy = policy
+	return nil
+}
+
+// 最近一次处理的错误统计
+func (p *LogProcessor) ErrorSummary() ErrorSummary {
+	return p.errs.summary()
+}
+
+// 最近一次处理因错误策略而中止的原因，没有中止时为 nil
+func (p *LogProcessor) Err() error {
+	p.errs.mu.Lock()
+	defer p.errs.mu.Unlock()
+	return p.errs.err
+}
+
+// ---------- 错误统计 ----------
+
+type ErrorSummary struct {
+	Lines       int64             `json:"lines"`  // 读取的非空行数
+	Errors      int64             `json:"errors"` // 被跳过的行数
+	Quarantined int64             `json:"quarantined,omitempty"`
+	ByCause     map[string]int64  `json:"by_cause,omitempty"` // 键为原因的描述，如 "invalid timestamp"
+	Examples    map[string]string `json:"examples,omitempty"` // 每种原因遇到的第一个错误
+}
+
+func (s ErrorSummary) ErrorRate() float64 {
+	if s.Lines == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Lines)
+}
+
+// 按数量从多到少排列的原因
+func (s ErrorSummary) Causes() []string {
+	causes := make([]string, 0, len(s.ByCause))
+	for cause := range s.ByCause {
+		causes = append(causes, cause)
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		ci, cj := s.ByCause[causes[i]], s.ByCause[causes[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return causes[i] < causes[j]
+	})
+	return causes
+}
+
+// 累加另一次处理的统计，例如依次处理多个文件时
+func (s *ErrorSummary) Merge(other ErrorSummary) {
+	s.Lines += other.Lines
+	s.Errors += other.Errors
+	s.Quarantined += other.Quarantined
+	for cause, n := range other.ByCause {
+		if s.ByCause == nil {
+			s.ByCause = make(map[string]int64)
+		}
+		s.ByCause[cause] += n
+	}
+	for cause, example := range other.Examples {
+		if s.Examples == nil {
+			s.Examples = make(map[string]string)
+		}
+		if _, ok := s.Examples[cause]; !ok {
+			s.Examples[cause] = example
+		}
+	}
+}
+
+func (s ErrorSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d lines (%.2f%%) skipped", s.Errors, s.Lines, s.ErrorRate()*100)
+	for i, cause := range s.Causes() {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d %s", s.ByCause[cause], cause)
+	}
+	if s.Quarantined > 0 {
+		fmt.Fprintf(&b, "; %d quarantined", s.Quarantined)
+	}
+	return b.String()
+}
+
+// 可以区分的错误原因，按从具体到笼统的顺序匹配
+var errorCauses = []error{
+	ErrLineTooLong,
+	ErrInvalidJSON,
+	ErrInvalidIP,
+	ErrInvalidTimestamp,
+	ErrInvalidRequest,
+	ErrInvalidStatus,
+	ErrInvalidSize,
+	ErrInvalidDuration,
+	ErrMalformedLine,
+}
+
+func errorCause(err error) string {
+	for _, cause := range errorCauses {
+		if errors.Is(err, cause) {
+			return cause.Error()
+		}
+	}
+	return "other"
+}
+
+// 一次处理中的错误计数，解析协程并发调用
+type errorTracker struct {
+	policy ErrorPolicy
+
+	lines  int64 // 原子更新
+	errors int64
+
+	mu          sync.Mutex
+	byCause     map[string]int64
+	examples    map[string]string
+	quarantined int64
+	err         error              // 中止处理的原因
+	cancel      context.CancelFunc // 并发处理时用于中止流水线
+	aborted     chan struct{}      // 中止时关闭
+}
+
+func (t *errorTracker) reset(cancel context.CancelFunc) {
+	atomic.StoreInt64(&t.lines, 0)
+	atomic.StoreInt64(&t.errors, 0)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byCause = make(map[string]int64)
+	t.examples = make(map[string]string)
+	t.quarantined = 0
+	t.err = nil
+	t.cancel = cancel
+	t.aborted = make(chan struct{})
+}
+
+func (t *errorTracker) line() {
+	atomic.AddInt64(&t.lines, 1)
+}
+
+// 记录一行错误，返回非 nil 表示应停止处理。
+// line 为原始行（超长行为空），lineNum 未知时为0
+func (t *errorTracker) record(line string, lineNum int, err error) error {
+	errCount := atomic.AddInt64(&t.errors, 1)
+	cause := errorCause(err)
+
+	var perr *LogProcessingError
+	if !errors.As(err, &perr) {
+		perr = &LogProcessingError{Line: line, Cause: err}
+	}
+	if perr.LineNumber == 0 {
+		perr.LineNumber = lineNum
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byCause == nil {
+		t.byCause = make(map[string]int64)
+		t.examples = make(map[string]string)
+	}
+	t.byCause[cause]++
+	if _, ok := t.examples[cause]; !ok {
+		t.examples[cause] = perr.Error()
+	}
+
+	var stop error
+	switch t.policy.Mode {
+	case FailFast:
+		stop = perr
+	case QuarantineErrors:
+		if line != "" {
+			if _, werr := io.WriteString(t.policy.Quarantine, line+"\n"); werr != nil {
+				stop = fmt.Errorf("failed to write quarantine: %w", werr)
+			} else {
+				t.quarantined++
+			}
+		}
+	}
+	if stop == nil && t.policy.MaxErrorRate > 0 {
+		if lines := atomic.LoadInt64(&t.lines); lines >= t.policy.MinLines {
+			stop = t.budgetError(errCount, lines)
+		}
+	}
+	if stop != nil {
+		t.abortLocked(stop)
+	}
+	return stop
+}
+
+// 处理结束时检查错误率，行数不足 MinLines 时也以最终比例为准
+func (t *errorTracker) finish() error {
+	if t.policy.MaxErrorRate <= 0 {
+		return nil
+	}
+	lines, errCount := atomic.LoadInt64(&t.lines), atomic.LoadInt64(&t.errors)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.budgetError(errCount, lines); err != nil {
+		t.abortLocked(err)
+		return err
+	}
+	return nil
+}
+
+func (t *errorTracker) budgetError(errCount, lines int64) error {
+	if lines == 0 || float64(errCount)/float64(lines) <= t.policy.MaxErrorRate {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d lines failed (%.2f%%, limit %.2f%%)", ErrErrorBudgetExceeded,
+		errCount, lines, float64(errCount)/float64(lines)*100, t.policy.MaxErrorRate*100)
+}
+
+// 只保留第一个中止原因
+func (t *errorTracker) abortLocked(err error) {
+	if t.err != nil {
+		return
+	}
+	t.err = err
+	if t.cancel != nil {
+		t.cancel()
+	}
+	if t.aborted != nil {
+		close(t.aborted)
+	}
+}
+
+// 中止时关闭的通道，reset 之后调用
+func (t *errorTracker) done() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.aborted
+}
+
+func (t *errorTracker) summary() ErrorSummary {
+	s := ErrorSummary{
+		Lines:  atomic.LoadInt64(&t.lines),
+		Errors: atomic.LoadInt64(&t.errors),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.Quarantined = t.quarantined
+	if len(t.byCause) > 0 {
+		s.ByCause = make(map[string]int64, len(t.byCause))
+		for cause, n := range t.byCause {
+			s.ByCause[cause] = n
+		}
+		s.Examples = make(map[string]string, len(t.examples))
+		for cause, example := range t.examples {
+			s.Examples[cause] = example
+		}
+	}
+	return s
+}
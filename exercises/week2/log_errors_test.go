@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 用生成的日志验证各种错误策略，顺序和并发处理的结果应一致
+func TestErrorPolicy(t *testing.T) {
+	log := generateLog(t, GeneratorConfig{Seed: 3, Lines: 20000, MalformedRate: 0.02})
+	want, firstBad := log.malformed, log.firstBad
+
+	for _, workers := range []int{1, 4} {
+		run := func(t *testing.T, policy ErrorPolicy) (*LogProcessor, error) {
+			processor, _, err := processLog(t, log, ProcessorConfig{ParserType: "combined", Errors: policy}, func(p *LogProcessor) {
+				p.SetConcurrency(workers)
+			})
+			return processor, err
+		}
+
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			t.Run("skip", func(t *testing.T) {
+				processor, err := run(t, ErrorPolicy{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				summary := processor.ErrorSummary()
+				var byCause int64
+				for _, n := range summary.ByCause {
+					byCause += n
+				}
+				if summary.Errors != want || byCause != want || summary.Lines != 20000 {
+					t.Errorf("skipped %d of %d lines (%d by cause), want %d of 20000", summary.Errors, summary.Lines, byCause, want)
+				}
+			})
+
+			t.Run("fail-fast", func(t *testing.T) {
+				_, err := run(t, ErrorPolicy{Mode: FailFast})
+				var perr *LogProcessingError
+				if !errors.As(err, &perr) {
+					t.Fatalf("err = %v, want *LogProcessingError", err)
+				}
+				// 并发处理时不知道行号
+				if workers == 1 && perr.LineNumber != firstBad {
+					t.Errorf("failed at line %d, want %d", perr.LineNumber, firstBad)
+				}
+			})
+
+			t.Run("quarantine", func(t *testing.T) {
+				var quarantine strings.Builder
+				processor, err := run(t, ErrorPolicy{Mode: QuarantineErrors, Quarantine: &quarantine})
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := int64(strings.Count(quarantine.String(), "\n"))
+				if lines != want || processor.ErrorSummary().Quarantined != want {
+					t.Errorf("quarantined %d lines (summary %d), want %d", lines, processor.ErrorSummary().Quarantined, want)
+				}
+			})
+
+			// 错误率约 2%
+			t.Run("max-error-rate", func(t *testing.T) {
+				if _, err := run(t, ErrorPolicy{MaxErrorRate: 0.01}); !errors.Is(err, ErrErrorBudgetExceeded) {
+					t.Errorf("1%% budget: err = %v, want ErrErrorBudgetExceeded", err)
+				}
+				if _, err := run(t, ErrorPolicy{MaxErrorRate: 0.05}); err != nil {
+					t.Errorf("5%% budget: err = %v, want nil", err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+过滤表达式：
+
+	status >= 500 && path =~ "^/api" && ip in 10.0.0.0/8
+	!(method == GET || method == HEAD) and latency > 500ms
+	status in [500, 502, 503] || ip not in [10.0.0.0/8, 192.168.0.0/16]
+
+- 组合：&& / and，|| / or，! / not，括号；优先级 ! > && > ||
+- 比较：== != < <= > >=，正则 =~ !~，集合 in / not in
+- 值：数字、不含空白和运算符的单词、Go 语法的双引号字符串、[a, b] 列表
+
+字段：
+	status            状态码               比较、in
+	size              响应字节数           比较、in
+	latency           响应耗时，如 250ms   比较（没有记录耗时的条目不通过）
+	time              RFC3339 或日志时间   比较
+	ip                客户端IP             == != in（IP 或 CIDR）
+	path method protocol user referer agent format
+	                  字符串               == != =~ !~ in
+
+状态码、时间、IP 的条件编译为 StatusCodeFilter、TimeRangeFilter、IPFilter，
+其余条件编译为 FilterFunc，组合条件编译为 AndFilter、OrFilter、NotFilter。
+*/
+
+// 过滤表达式的语法错误，Pos 为出错位置（从0开始的字节偏移）
+type FilterSyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("filter column %d: %s", e.Pos+1, e.Msg)
+}
+
+// 原表达式和指向出错位置的 ^，用于命令行输出
+func (e *FilterSyntaxError) Context() string {
+	// 制表符保持原样，其余字符用空格占位，保证 ^ 对齐
+	pad := []rune{}
+	for _, r := range e.Expr[:e.Pos] {
+		if r == '\t' {
+			pad = append(pad, '\t')
+		} else {
+			pad = append(pad, ' ')
+		}
+	}
+	return e.Expr + "\n" + string(pad) + "^"
+}
+
+// 把过滤表达式编译成过滤器
+func ParseFilter(expr string) (LogFilter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{expr: expr, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "empty filter expression")
+	}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s, expected && or ||", tok)
+	}
+	return filter, nil
+}
+
+// ---------- 词法分析 ----------
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string // 单词、运算符，或字符串去掉引号后的值
+	pos  int
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// 两个字符的运算符需要先于单个字符匹配
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "=", "!"}
+
+// 单词中不能出现的字符
+const filterDelimiters = "()[],\"!&|=<>~"
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, filterToken{tokLParen, "(", i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, filterToken{tokRParen, ")", i})
+			i++
+			continue
+		case c == '[':
+			tokens = append(tokens, filterToken{tokLBracket, "[", i})
+			i++
+			continue
+		case c == ']':
+			tokens = append(tokens, filterToken{tokRBracket, "]", i})
+			i++
+			continue
+		case c == ',':
+			tokens = append(tokens, filterToken{tokComma, ",", i})
+			i++
+			continue
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, &FilterSyntaxError{Expr: expr, Pos: i, Msg: "unterminated string"}
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, &FilterSyntaxError{Expr: expr, Pos: i, Msg: "invalid string literal"}
+			}
+			tokens = append(tokens, filterToken{tokString, value, i})
+			i = end + 1
+			continue
+		}
+
+		matched := false
+		for _, op := range filterOperators {
+			if !strings.HasPrefix(expr[i:], op) {
+				continue
+			}
+			kind, text := tokOp, op
+			switch op {
+			case "&&":
+				kind = tokAnd
+			case "||":
+				kind = tokOr
+			case "!":
+				kind = tokNot
+			case "=":
+				text = "=="
+			}
+			tokens = append(tokens, filterToken{kind, text, i})
+			i += len(op)
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+		if strings.IndexByte(filterDelimiters, c) >= 0 {
+			return nil, &FilterSyntaxError{Expr: expr, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+
+		start := i
+		for i < len(expr) && !strings.ContainsRune(" \t\n\r", rune(expr[i])) && strings.IndexByte(filterDelimiters, expr[i]) < 0 {
+			i++
+		}
+		word := expr[start:i]
+		kind := tokWord
+		switch strings.ToLower(word) {
+		case "and":
+			kind = tokAnd
+		case "or":
+			kind = tokOr
+		case "not":
+			kind = tokNot
+		}
+		tokens = append(tokens, filterToken{kind, word, start})
+	}
+	return append(tokens, filterToken{tokEOF, "", len(expr)}), nil
+}
+
+// ---------- 语法分析 ----------
+
+type filterParser struct {
+	expr   string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) errorf(tok filterToken, format string, args ...interface{}) error {
+	return &FilterSyntaxError{Expr: p.expr, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) parseOr() (LogFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	filters := []LogFilter{left}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, right)
+	}
+	if len(filters) == 1 {
+		return left, nil
+	}
+	return &OrFilter{Filters: filters}, nil
+}
+
+func (p *filterParser) parseAnd() (LogFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	filters := []LogFilter{left}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, right)
+	}
+	if len(filters) == 1 {
+		return left, nil
+	}
+	return &AndFilter{Filters: filters}, nil
+}
+
+func (p *filterParser) parseUnary() (LogFilter, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNot:
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotFilter{Inner: inner}, nil
+	case tokLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, p.errorf(closing, "expected ) to close ( at column %d, got %s", tok.pos+1, closing)
+		}
+		return inner, nil
+	case tokWord:
+		return p.parseComparison()
+	}
+	return nil, p.errorf(tok, "expected a field name, ! or (, got %s", tok)
+}
+
+// field op value
+func (p *filterParser) parseComparison() (LogFilter, error) {
+	fieldTok := p.next()
+	field := strings.ToLower(fieldTok.text)
+	kind, ok := filterFields[field]
+	if !ok {
+		return nil, p.errorf(fieldTok, "unknown field %q (known fields: %s)", fieldTok.text, knownFilterFields())
+	}
+
+	opTok := p.next()
+	op := opTok.text
+	switch {
+	case opTok.kind == tokOp:
+	case opTok.kind == tokWord && strings.EqualFold(op, "in"):
+		op = "in"
+	case opTok.kind == tokNot && p.peek().kind == tokWord && strings.EqualFold(p.peek().text, "in"):
+		p.next()
+		op = "not in"
+	default:
+		return nil, p.errorf(opTok, "expected an operator after %s, got %s", fieldTok, opTok)
+	}
+	if !kind.allows(op) {
+		return nil, p.errorf(opTok, "operator %s is not supported for field %s", op, field)
+	}
+
+	if op == "in" || op == "not in" {
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		filter, err := p.compileIn(field, values)
+		if err != nil {
+			return nil, err
+		}
+		if op == "not in" {
+			return &NotFilter{Inner: filter}, nil
+		}
+		return filter, nil
+	}
+
+	valueTok := p.next()
+	if valueTok.kind != tokWord && valueTok.kind != tokString {
+		return nil, p.errorf(valueTok, "expected a value after %s, got %s", op, valueTok)
+	}
+	filter, err := compileComparison(field, op, valueTok.text)
+	if err != nil {
+		return nil, p.errorf(valueTok, "%v", err)
+	}
+	return filter, nil
+}
+
+// 列表值：[a, b, c]，也可以是单个值
+func (p *filterParser) parseList() ([]filterToken, error) {
+	if p.peek().kind != tokLBracket {
+		tok := p.next()
+		if tok.kind != tokWord && tok.kind != tokString {
+			return nil, p.errorf(tok, "expected a value or [list] after in, got %s", tok)
+		}
+		return []filterToken{tok}, nil
+	}
+
+	open := p.next()
+	var values []filterToken
+	for {
+		tok := p.next()
+		if tok.kind == tokRBracket && len(values) == 0 {
+			return nil, p.errorf(tok, "empty list")
+		}
+		if tok.kind != tokWord && tok.kind != tokString {
+			return nil, p.errorf(tok, "expected a list value, got %s", tok)
+		}
+		values = append(values, tok)
+
+		switch sep := p.next(); sep.kind {
+		case tokComma:
+		case tokRBracket:
+			return values, nil
+		default:
+			return nil, p.errorf(sep, "expected , or ] to close [ at column %d, got %s", open.pos+1, sep)
+		}
+	}
+}
+
+// ---------- 编译 ----------
+
+type fieldKind int
+
+const (
+	kindNumber fieldKind = iota
+	kindDuration
+	kindTime
+	kindIP
+	kindString
+)
+
+var filterFields = map[string]fieldKind{
+	"status":   kindNumber,
+	"size":     kindNumber,
+	"latency":  kindDuration,
+	"time":     kindTime,
+	"ip":       kindIP,
+	"path":     kindString,
+	"url":      kindString,
+	"method":   kindString,
+	"protocol": kindString,
+	"user":     kindString,
+	"referer":  kindString,
+	"agent":    kindString,
+	"format":   kindString,
+}
+
+func knownFilterFields() string {
+	return "status, size, latency, time, ip, path, method, protocol, user, referer, agent, format"
+}
+
+func (k fieldKind) allows(op string) bool {
+	switch op {
+	case "==", "!=":
+		return true
+	case "<", "<=", ">", ">=":
+		return k == kindNumber || k == kindDuration || k == kindTime
+	case "=~", "!~":
+		return k == kindString
+	case "in", "not in":
+		return k == kindNumber || k == kindIP || k == kindString
+	}
+	return false
+}
+
+func compileComparison(field, op, value string) (LogFilter, error) {
+	switch filterFields[field] {
+	case kindNumber:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects a number, got %q", field, value)
+		}
+		if field == "status" && (op == "==" || op == "!=") {
+			return negateIf(op == "!=", &StatusCodeFilter{AllowedCodes: []int{int(n)}}), nil
+		}
+		get := numberGetter(field)
+		return FilterFunc(func(e *LogEntry) bool { return compareInt(get(e), op, n) }), nil
+
+	case kindDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("latency expects a duration such as 250ms, got %q", value)
+		}
+		return FilterFunc(func(e *LogEntry) bool {
+			return e.HasResponseTime && compareInt(int64(e.ResponseTime), op, int64(d))
+		}), nil
+
+	case kindTime:
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return compileTime(op, t), nil
+
+	case kindIP:
+		if _, err := parsePrefix(value); err != nil {
+			return nil, err
+		}
+		return negateIf(op == "!=", &IPFilter{AllowedIPs: []string{value}}), nil
+
+	default:
+		get := stringGetter(field)
+		switch op {
+		case "==":
+			return FilterFunc(func(e *LogEntry) bool { return get(e) == value }), nil
+		case "!=":
+			return FilterFunc(func(e *LogEntry) bool { return get(e) != value }), nil
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		match := op == "=~"
+		return FilterFunc(func(e *LogEntry) bool { return re.MatchString(get(e)) == match }), nil
+	}
+}
+
+func (p *filterParser) compileIn(field string, values []filterToken) (LogFilter, error) {
+	texts := make([]string, len(values))
+	for i, v := range values {
+		texts[i] = v.text
+	}
+
+	switch filterFields[field] {
+	case kindIP:
+		for _, v := range values {
+			if _, err := parsePrefix(v.text); err != nil {
+				return nil, p.errorf(v, "%v", err)
+			}
+		}
+		return &IPFilter{AllowedIPs: texts}, nil
+
+	case kindNumber:
+		nums := make([]int64, len(texts))
+		for i, v := range values {
+			n, err := strconv.ParseInt(v.text, 10, 64)
+			if err != nil {
+				return nil, p.errorf(v, "%s expects numbers, got %q", field, v.text)
+			}
+			nums[i] = n
+		}
+		if field == "status" {
+			codes := make([]int, len(nums))
+			for i, n := range nums {
+				codes[i] = int(n)
+			}
+			return &StatusCodeFilter{AllowedCodes: codes}, nil
+		}
+		get := numberGetter(field)
+		return FilterFunc(func(e *LogEntry) bool {
+			v := get(e)
+			for _, n := range nums {
+				if v == n {
+					return true
+				}
+			}
+			return false
+		}), nil
+
+	default:
+		set := make(map[string]bool, len(texts))
+		for _, text := range texts {
+			set[text] = true
+		}
+		get := stringGetter(field)
+		return FilterFunc(func(e *LogEntry) bool { return set[get(e)] }), nil
+	}
+}
+
+// 时间比较转换为左闭右开的 TimeRangeFilter
+func compileTime(op string, t time.Time) LogFilter {
+	next := t.Add(time.Nanosecond)
+	switch op {
+	case ">=":
+		return &TimeRangeFilter{StartTime: t}
+	case ">":
+		return &TimeRangeFilter{StartTime: next}
+	case "<":
+		return &TimeRangeFilter{EndTime: t}
+	case "<=":
+		return &TimeRangeFilter{EndTime: next}
+	case "!=":
+		return &AndFilter{Filters: []LogFilter{
+			FilterFunc(func(e *LogEntry) bool { return !e.Timestamp.IsZero() }),
+			&NotFilter{Inner: &TimeRangeFilter{StartTime: t, EndTime: next}},
+		}}
+	}
+	return &TimeRangeFilter{StartTime: t, EndTime: next}
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, CommonTimeLayout, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time expects RFC3339 such as 2023-12-25T10:00:00Z, got %q", value)
+}
+
+func negateIf(negate bool, filter LogFilter) LogFilter {
+	if negate {
+		return &NotFilter{Inner: filter}
+	}
+	return filter
+}
+
+func compareInt(v int64, op string, n int64) bool {
+	switch op {
+	case "==":
+		return v == n
+	case "!=":
+		return v != n
+	case "<":
+		return v < n
+	case "<=":
+		return v <= n
+	case ">":
+		return v > n
+	case ">=":
+		return v >= n
+	}
+	return false
+}
+
+func numberGetter(field string) func(*LogEntry) int64 {
+	if field == "size" {
+		return func(e *LogEntry) int64 { return e.Size }
+	}
+	return func(e *LogEntry) int64 { return int64(e.StatusCode) }
+}
+
+func stringGetter(field string) func(*LogEntry) string {
+	switch field {
+	case "method":
+		return func(e *LogEntry) string { return e.Method }
+	case "protocol":
+		return func(e *LogEntry) string { return e.Protocol }
+	case "user":
+		return func(e *LogEntry) string { return e.RemoteUser }
+	case "referer":
+		return func(e *LogEntry) string { return e.Referer }
+	case "agent":
+		return func(e *LogEntry) string { return e.UserAgent }
+	case "format":
+		return func(e *LogEntry) string { return e.Format }
+	}
+	return func(e *LogEntry) string { return e.URL }
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 过滤表达式测试使用的条目，按名字引用
+func filterTestEntries() map[string]*LogEntry {
+	at := func(clock string) time.Time {
+		t, err := time.Parse(time.RFC3339, "2023-12-25T"+clock+"Z")
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	return map[string]*LogEntry{
+		"a": {
+			Timestamp: at("10:00:00"), IP: "10.1.2.3", Method: "GET", URL: "/api/users", Protocol: "HTTP/1.1",
+			StatusCode: 200, Size: 512, UserAgent: "curl/8.4.0",
+			ResponseTime: 120 * time.Millisecond, HasResponseTime: true,
+		},
+		"b": {
+			Timestamp: at("10:30:00"), IP: "192.168.1.5", Method: "POST", URL: "/api/orders", Protocol: "HTTP/1.1",
+			StatusCode: 503, UserAgent: "Mozilla/5.0",
+			ResponseTime: 1500 * time.Millisecond, HasResponseTime: true,
+		},
+		// 没有记录耗时
+		"c": {
+			Timestamp: at("11:00:00"), IP: "8.8.8.8", Method: "HEAD", URL: "/index.html", Protocol: "HTTP/1.1",
+			StatusCode: 404, Referer: "https://example.com/",
+		},
+		// 耗时为0也是记录了耗时
+		"d": {
+			Timestamp: at("12:00:00"), IP: "2001:db8::1", Method: "GET", URL: "/static/app.js", Protocol: "HTTP/2.0",
+			StatusCode: 304, HasResponseTime: true,
+		},
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // 通过过滤的条目名
+	}{
+		// 优先级与括号：! > && > ||
+		{"status >= 500 || status == 404 && method == GET", "b"},
+		{"(status >= 500 || status == 404) && method == HEAD", "c"},
+		{"status == 200 or status == 503 and size > 0", "a"},
+		{"(status == 200 or status == 503) and size > 0", "a"},
+		{"(status == 200 or status == 503) and size == 0", "b"},
+		{"!method == GET", "bc"},
+		{"not status == 200 and size == 0", "bcd"},
+		{"!(method == GET || method == HEAD) and latency > 500ms", "b"},
+		{"!!(method = GET)", "ad"},
+		{"((status == 200))", "a"},
+
+		// in 与 not in
+		{"status in [500, 502, 503]", "b"},
+		{"status IN 404", "c"},
+		{"status not in [200, 304]", "bc"},
+		{"size in [0]", "bcd"},
+		{"method not in [GET, HEAD]", "b"},
+		{`path in ["/api/users", "/index.html"]`, "ac"},
+		{"protocol in HTTP/2.0", "d"},
+
+		// 耗时：没有记录耗时的条目不通过任何比较
+		{"latency > 500ms", "b"},
+		{"latency <= 120ms", "ad"},
+		{"latency == 0s", "d"},
+		{"latency != 120ms", "bd"},
+		{"latency >= 1.5s", "b"},
+		{"latency < 1m", "abd"},
+
+		// 时间
+		{"time >= 2023-12-25T10:30:00Z", "bcd"},
+		{"time > 2023-12-25T10:30:00Z", "cd"},
+		{`time < "2023-12-25 10:30:00"`, "a"},
+		{"time == 2023-12-25T11:00:00Z", "c"},
+		{"time != 2023-12-25T11:00:00Z", "abd"},
+		{`time <= "25/Dec/2023:11:00:00 +0000"`, "abc"},
+		{"time > 2023-12-25", "abcd"},
+		{"time >= 2023-12-25T10:00:00Z && time < 2023-12-25T11:00:00Z", "ab"},
+
+		// IP 与 CIDR
+		{"ip == 10.1.2.3", "a"},
+		{"ip != 10.1.2.3", "bcd"},
+		{"ip == 10.0.0.0/8", "a"},
+		{"ip in 2001:db8::/32", "d"},
+		{"ip in [10.0.0.0/8, 192.168.0.0/16]", "ab"},
+		{"ip not in [10.0.0.0/8, 192.168.0.0/16]", "cd"},
+		{"ip in [10.1.2.3/32, 8.8.8.0/24]", "ac"},
+
+		// 字符串
+		{`path =~ "^/api"`, "ab"},
+		{"agent !~ curl", "bcd"},
+		{`referer == "https://example.com/"`, "c"},
+		{"METHOD == GET", "ad"},
+	}
+
+	entries := filterTestEntries()
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			got := ""
+			for _, name := range []string{"a", "b", "c", "d"} {
+				if filter.Filter(entries[name]) {
+					got += name
+				}
+			}
+			if got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 状态码、时间、IP 条件编译为对应的过滤器
+func TestParseFilterTypes(t *testing.T) {
+	tests := []struct {
+		expr string
+		want LogFilter
+	}{
+		{"status == 404", &StatusCodeFilter{AllowedCodes: []int{404}}},
+		{"status in [500, 503]", &StatusCodeFilter{AllowedCodes: []int{500, 503}}},
+		{"status not in [500]", &NotFilter{Inner: &StatusCodeFilter{AllowedCodes: []int{500}}}},
+		{"ip in [10.0.0.0/8, ::1]", &IPFilter{AllowedIPs: []string{"10.0.0.0/8", "::1"}}},
+		{"ip != 10.0.0.1", &NotFilter{Inner: &IPFilter{AllowedIPs: []string{"10.0.0.1"}}}},
+		{"time >= 2023-12-25T10:00:00Z", &TimeRangeFilter{StartTime: time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)}},
+		{"time < 2023-12-25T10:00:00Z", &TimeRangeFilter{EndTime: time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFilter(%q) = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+		msg  string // 错误信息包含的内容
+	}{
+		{"", 0, "empty filter expression"},
+		{"   ", 3, "empty filter expression"},
+		{"status >", 8, "expected a value after >, got end of expression"},
+		{"stat == 200", 0, `unknown field "stat"`},
+		{"status == abc", 10, `status expects a number, got "abc"`},
+		{"status 200", 7, `expected an operator after "status", got "200"`},
+		{"(status == 200", 14, "expected ) to close ( at column 1, got end of expression"},
+		{"status == 200 status == 404", 14, `unexpected "status", expected && or ||`},
+		{"status == 200 && || method == GET", 17, `expected a field name, ! or (, got "||"`},
+		{"&& status == 200", 0, "expected a field name, ! or ("},
+		{`path == "abc`, 8, "unterminated string"},
+		{"status == 200 && ~", 17, "unexpected character"},
+		{"status in []", 11, "empty list"},
+		{"status in [200 404]", 15, "expected , or ] to close [ at column 11"},
+		{"status in [200, abc]", 16, `status expects numbers, got "abc"`},
+		{"ip < 10.0.0.1", 3, "operator < is not supported for field ip"},
+		{"path > /a", 5, "operator > is not supported for field path"},
+		{"latency in [1s]", 8, "operator in is not supported for field latency"},
+		{`path =~ "("`, 8, "invalid regular expression"},
+		{"latency > 5", 10, `latency expects a duration such as 250ms, got "5"`},
+		{"time > yesterday", 7, `time expects RFC3339 such as 2023-12-25T10:00:00Z, got "yesterday"`},
+		{"ip in [10.0.0.0/8, 300.1.1.1]", 19, "300.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr)
+			var syntaxErr *FilterSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error %v, want *FilterSyntaxError", err)
+			}
+			if syntaxErr.Pos != tt.pos {
+				t.Errorf("got Pos %d, want %d (%v)", syntaxErr.Pos, tt.pos, err)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.msg) {
+				t.Errorf("got Msg %q, want it to contain %q", syntaxErr.Msg, tt.msg)
+			}
+			if syntaxErr.Expr != tt.expr {
+				t.Errorf("got Expr %q, want %q", syntaxErr.Expr, tt.expr)
+			}
+		})
+	}
+}
+
+func TestFilterSyntaxErrorFormat(t *testing.T) {
+	tests := []struct {
+		expr    string
+		err     string
+		context string
+	}{
+		{
+			"status >= 5xx",
+			`filter column 11: status expects a number, got "5xx"`,
+			"status >= 5xx\n          ^",
+		},
+		{
+			"stat == 200",
+			"filter column 1: unknown field",
+			"stat == 200\n^",
+		},
+		// 制表符原样保留，^ 与出错位置对齐
+		{
+			"status == 200 &&\tmethod ==",
+			"filter column 27: expected a value after ==",
+			"status == 200 &&\tmethod ==\n                \t         ^",
+		},
+	}
+	for _, tt := range tests {
+		_, err := ParseFilter(tt.expr)
+		var syntaxErr *FilterSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseFilter(%q): got error %v, want *FilterSyntaxError", tt.expr, err)
+			continue
+		}
+		if got := syntaxErr.Error(); !strings.HasPrefix(got, tt.err) {
+			t.Errorf("Error() = %q, want prefix %q", got, tt.err)
+		}
+		if got := syntaxErr.Context(); got != tt.context {
+			t.Errorf("Context() = %q, want %q", got, tt.context)
+		}
+	}
+}
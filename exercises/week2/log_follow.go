@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+跟踪模式（类似 tail -F）：
+
+- 读到文件末尾后按间隔轮询，有新数据就继续读取；最后一行没有换行符时先保留，等写完整再处理
+- 日志轮转：
+  1. 改名（mv access.log access.log.1 后新建）：路径指向了另一个文件（os.SameFile 为 false），
+     先读完旧文件剩余的内容，再从头读取新文件
+  2. 复制后截断（copytruncate）：文件变得比已读取的位置还小，从头重新读取
+  3. 文件暂时不存在：继续读旧文件并等待新文件出现
+- 检查点：定期把已处理到的偏移量和文件开头若干字节的指纹写入 JSON 文件。
+  重启后指纹一致才从该位置继续，否则说明已经轮转，从头读取新文件。
+  分析结果本身不保存，重启后的结果只包含检查点之后的内容
+*/
+
+// 默认的轮询间隔
+const defaultPollInterval = 250 * time.Millisecond
+
+// 指纹使用文件开头的字节数
+const fingerprintSize = 256
+
+type FollowOptions struct {
+	PollInterval time.Duration // 为0时使用 defaultPollInterval
+	Checkpoint   string        // 检查点文件路径，为空时不保存
+	FromStart    bool          // 没有可用检查点时从文件开头读取，默认只读取新写入的内容
+
+	// 跟踪过程中的错误（文件暂时不存在、读取失败、检查点写入失败等），跟踪会继续重试
+	OnError func(err error)
+}
+
+// 检查点文件的内容
+type FollowCheckpoint struct {
+	Path            string    `json:"path"`
+	Offset          int64     `json:"offset"`
+	Fingerprint     string    `json:"fingerprint"`      // 文件开头 FingerprintSize 字节的 SHA-256
+	FingerprintSize int       `json:"fingerprint_size"` // 文件较小时少于 fingerprintSize
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// 读取检查点，文件不存在时返回 nil
+func LoadFollowCheckpoint(filename string) (*FollowCheckpoint, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var cp FollowCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", filename, err)
+	}
+	return &cp, nil
+}
+
+// 先写临时文件再改名，避免中途退出留下不完整的检查点
+func (cp *FollowCheckpoint) Save(filename string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// 跟踪日志文件并持续分析。
+// 输出通道按 SetSnapshotInterval 的间隔收到阶段性结果；
+// ctx 取消后停止读取，剩余的行处理完后输出最终结果并关闭通道
+func (p *LogProcessor) Follow(ctx context.Context, filename string, opts FollowOptions) (<-chan *AnalysisResult, error) {
+	t, err := newLogTailer(filename, opts, p.maxLineLength, p.bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	t.onTooLong = func() {
+		p.countLine()
+		p.lineError("", 0, ErrLineTooLong)
+	}
+	if p.monitor != nil {
+		t.onRead = p.monitor.RecordBytes
+	}
+
+	lines := make(chan string, p.batchSize)
+	// 流水线不使用 ctx：停止跟踪后仍要处理完已读取的行
+	results, err := p.ProcessStreamContext(context.Background(), lines)
+	if err != nil {
+		return nil, err
+	}
+	// 错误策略中止流水线后也停止跟踪
+	ctx, stop := context.WithCancel(ctx)
+	aborted := p.errs.done()
+	go func() {
+		select {
+		case <-aborted:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer stop()
+		defer close(lines)
+		t.run(ctx, lines)
+	}()
+	return results, nil
+}
+
+type logTailer struct {
+	filename string
+	opts     FollowOptions
+	maxLine  int
+	bufSize  int // 每次读取的字节数
+
+	file    *os.File
+	info    os.FileInfo
+	offset  int64  // 已处理的完整行之后的位置
+	pending []byte // 还没有遇到换行符的部分
+	partial int64  // offset 之后已读取的字节数，超长行被丢弃时大于 len(pending)
+	tooLong bool   // 当前行超长，丢弃到下一个换行符
+	saved   int64  // 上次写入检查点时的偏移，-1 表示需要重新写入
+
+	start     *FollowCheckpoint // 启动时读取的检查点
+	onTooLong func()
+	onRead    func(n int64)
+	lastErr   string
+}
+
+func newLogTailer(filename string, opts FollowOptions, maxLine, bufSize int) (*logTailer, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if maxLine <= 0 {
+		maxLine = defaultMaxLineLength
+	}
+	if bufSize <= 0 {
+		bufSize = defaultBufferSize
+	}
+	t := &logTailer{filename: filename, opts: opts, maxLine: maxLine, bufSize: bufSize, saved: -1}
+	if opts.Checkpoint != "" {
+		cp, err := LoadFollowCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && cp.Path == filename {
+			t.start = cp
+		}
+	}
+	return t, nil
+}
+
+func (t *logTailer) report(err error) {
+	// 同样的错误在轮询中会反复出现，只报告一次
+	if err == nil || err.Error() == t.lastErr {
+		return
+	}
+	t.lastErr = err.Error()
+	if t.opts.OnError != nil {
+		t.opts.OnError(err)
+	}
+}
+
+func (t *logTailer) run(ctx context.Context, lines chan<- string) {
+	defer func() {
+		t.saveCheckpoint()
+		if t.file != nil {
+			t.file.Close()
+		}
+	}()
+
+	// 启动时文件不存在，之后出现的文件是全新的，从头读取
+	initial := true
+	for t.file == nil {
+		if err := t.open(initial); err != nil {
+			t.report(err)
+			if !t.sleep(ctx) {
+				return
+			}
+			initial = false
+		}
+	}
+
+	buf := make([]byte, t.bufSize)
+	for {
+		// 读到当前末尾
+		for {
+			n, err := t.file.Read(buf)
+			if n > 0 && !t.consume(ctx, buf[:n], lines) {
+				return
+			}
+			if err == io.EOF || (n == 0 && err == nil) {
+				break
+			}
+			if err != nil {
+				t.report(fmt.Errorf("failed to read %s: %w", t.filename, err))
+				break
+			}
+		}
+
+		t.saveCheckpoint()
+		if !t.sleep(ctx) {
+			return
+		}
+		if !t.checkRotation(ctx, lines, buf) {
+			return
+		}
+	}
+}
+
+// 打开文件。首次打开时根据检查点或选项决定起始位置，轮转后的新文件总是从头读取
+func (t *logTailer) open(initial bool) error {
+	file, err := os.Open(t.filename)
+	if err != nil {
+		return fmt.Errorf("waiting for %s: %w", t.filename, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %w", t.filename, err)
+	}
+
+	var offset int64
+	if initial {
+		switch {
+		case t.start != nil && t.matches(file, info, t.start):
+			offset = t.start.Offset
+		case t.start != nil:
+			offset = 0 // 检查点之后文件已经轮转
+		case !t.opts.FromStart:
+			offset = info.Size()
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to seek %s: %w", t.filename, err)
+	}
+
+	t.file, t.info, t.offset = file, info, offset
+	t.pending, t.partial, t.tooLong = t.pending[:0], 0, false
+	t.lastErr = ""
+	return nil
+}
+
+// 检查点是否属于当前文件：偏移不超过文件大小，且开头的字节一致
+func (t *logTailer) matches(file *os.File, info os.FileInfo, cp *FollowCheckpoint) bool {
+	if cp.Offset > info.Size() {
+		return false
+	}
+	fp, size, err := fileFingerprint(file, cp.FingerprintSize)
+	return err == nil && size == cp.FingerprintSize && fp == cp.Fingerprint
+}
+
+// 文件开头至多 n 字节的 SHA-256 及实际使用的字节数
+func fileFingerprint(file *os.File, n int) (string, int, error) {
+	head := make([]byte, n)
+	read, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(head[:read])
+	return hex.EncodeToString(sum[:]), read, nil
+}
+
+// 按换行符切分新读到的数据并发送完整的行
+func (t *logTailer) consume(ctx context.Context, data []byte, lines chan<- string) bool {
+	if t.onRead != nil {
+		t.onRead(int64(len(data)))
+	}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.appendPending(data)
+			return true
+		}
+		t.appendPending(data[:i])
+		data = data[i+1:]
+		t.offset += t.partial + 1
+
+		line, tooLong := bytes.TrimRight(t.pending, "\r"), t.tooLong
+		t.pending, t.partial, t.tooLong = t.pending[:0], 0, false
+		if tooLong {
+			if t.onTooLong != nil {
+				t.onTooLong()
+			}
+			continue
+		}
+		if len(line) == 0 {
+			continue
+		}
+		select {
+		case lines <- string(line):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (t *logTailer) appendPending(data []byte) {
+	t.partial += int64(len(data))
+	if t.tooLong {
+		return
+	}
+	if len(t.pending)+len(data) > t.maxLine {
+		t.tooLong = true
+		t.pending = t.pending[:0]
+		return
+	}
+	t.pending = append(t.pending, data...)
+}
+
+// 没有换行符的最后一行在文件轮转后不会再被写完，直接作为完整的一行处理
+func (t *logTailer) flushPending(ctx context.Context, lines chan<- string) bool {
+	if t.partial == 0 {
+		return true
+	}
+	return t.consume(ctx, []byte{'\n'}, lines)
+}
+
+func (t *logTailer) sleep(ctx context.Context) bool {
+	timer := time.NewTimer(t.opts.PollInterval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 检查文件是否被改名替换或截断
+func (t *logTailer) checkRotation(ctx context.Context, lines chan<- string, buf []byte) bool {
+	info, err := os.Stat(t.filename)
+	if err != nil {
+		// 改名后新文件还没创建，继续读旧文件
+		t.report(fmt.Errorf("waiting for %s: %w", t.filename, err))
+		return true
+	}
+
+	if !os.SameFile(info, t.info) {
+		// 先读完旧文件中轮转前写入的内容
+		for {
+			n, err := t.file.Read(buf)
+			if n > 0 && !t.consume(ctx, buf[:n], lines) {
+				return false
+			}
+			if n == 0 || err != nil {
+				break
+			}
+		}
+		if !t.flushPending(ctx, lines) {
+			return false
+		}
+		t.file.Close()
+		t.file = nil
+		if err := t.open(false); err != nil {
+			t.report(err)
+			return t.reopen(ctx)
+		}
+		t.saved = -1
+		return true
+	}
+
+	if info.Size() < t.offset+t.partial {
+		// copytruncate：截断前没有换行符的内容已经不完整，丢弃
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			t.report(fmt.Errorf("failed to seek %s: %w", t.filename, err))
+			return true
+		}
+		t.info, t.offset = info, 0
+		t.pending, t.partial, t.tooLong = t.pending[:0], 0, false
+		t.saved = -1
+	}
+	return true
+}
+
+// 新文件打开失败（例如刚好又被改名）时等待重试
+func (t *logTailer) reopen(ctx context.Context) bool {
+	for {
+		if !t.sleep(ctx) {
+			return false
+		}
+		if err := t.open(false); err != nil {
+			t.report(err)
+			continue
+		}
+		return true
+	}
+}
+
+// 偏移有变化时写入检查点
+func (t *logTailer) saveCheckpoint() {
+	if t.opts.Checkpoint == "" || t.file == nil || t.offset == t.saved {
+		return
+	}
+	fp, size, err := fileFingerprint(t.file, fingerprintSize)
+	if err != nil {
+		t.report(fmt.Errorf("failed to fingerprint %s: %w", t.filename, err))
+		return
+	}
+	cp := &FollowCheckpoint{
+		Path:            t.filename,
+		Offset:          t.offset,
+		Fingerprint:     fp,
+		FingerprintSize: size,
+		UpdatedAt:       time.Now(),
+	}
+	if err := cp.Save(t.opts.Checkpoint); err != nil {
+		t.report(err)
+		return
+	}
+	t.saved = t.offset
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 在后台运行的 logTailer，读到的行留在 lines 中
+type tailRun struct {
+	lines  chan string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startTailer(t *testing.T, filename string, opts FollowOptions) *tailRun {
+	t.Helper()
+	opts.PollInterval = 10 * time.Millisecond
+	opts.OnError = func(error) {}
+	tailer, err := newLogTailer(filename, opts, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &tailRun{lines: make(chan string, 1000), cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		tailer.run(ctx, r.lines)
+	}()
+	t.Cleanup(r.stop)
+	return r
+}
+
+// 读取 n 行，超时则失败
+func (r *tailRun) read(t *testing.T, n int) []string {
+	t.Helper()
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case line := <-r.lines:
+			got = append(got, line)
+		case <-timeout:
+			t.Fatalf("got %d lines %q, want %d", len(got), got, n)
+		}
+	}
+	return got
+}
+
+// 停止跟踪，退出时写入检查点
+func (r *tailRun) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// 停止跟踪并返回还没读取的行
+func (r *tailRun) extra() []string {
+	r.stop()
+	var extra []string
+	for {
+		select {
+		case line := <-r.lines:
+			extra = append(extra, line)
+		default:
+			return extra
+		}
+	}
+}
+
+// 等待几个轮询周期，确认没有多读的行
+func assertNoMoreLines(t *testing.T, r *tailRun) {
+	t.Helper()
+	time.Sleep(50 * time.Millisecond)
+	if extra := r.extra(); len(extra) > 0 {
+		t.Errorf("got unexpected lines %q", extra)
+	}
+}
+
+func logLine(i int) string {
+	return fmt.Sprintf("line %03d", i)
+}
+
+func logLines(from, to int) []string {
+	var lines []string
+	for i := from; i < to; i++ {
+		lines = append(lines, logLine(i))
+	}
+	return lines
+}
+
+// 向文件追加第 from 到 to-1 行，newline 为 false 时最后一行不写换行符
+func appendLines(t *testing.T, filename string, from, to int, newline bool) {
+	t.Helper()
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := from; i < to; i++ {
+		line := logLine(i)
+		if newline || i < to-1 {
+			line += "\n"
+		}
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got lines %q, want %q", got, want)
+	}
+}
+
+func TestFollowRename(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	appendLines(t, filename, 0, 10, true)
+
+	r := startTailer(t, filename, FollowOptions{FromStart: true})
+	assertLines(t, r.read(t, 10), logLines(0, 10))
+
+	// 写日志的程序在改名后还会向旧文件写入，直到重新打开；
+	// 旧文件最后一行没有换行符，轮转后作为完整的一行处理
+	appendLines(t, filename, 10, 15, true)
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, filename+".1", 15, 18, false)
+	time.Sleep(30 * time.Millisecond) // 新文件暂时不存在
+	appendLines(t, filename, 18, 30, true)
+
+	assertLines(t, r.read(t, 20), logLines(10, 30))
+
+	// 之后的写入继续从新文件读取
+	appendLines(t, filename, 30, 35, true)
+	assertLines(t, r.read(t, 5), logLines(30, 35))
+	assertNoMoreLines(t, r)
+}
+
+func TestFollowCopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	appendLines(t, filename, 0, 10, true)
+
+	r := startTailer(t, filename, FollowOptions{FromStart: true})
+	assertLines(t, r.read(t, 10), logLines(0, 10))
+
+	// 复制出的旧文件不会被读取，截断后的文件从头读取
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename+".1", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filename, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, filename, 10, 13, true)
+	assertLines(t, r.read(t, 3), logLines(10, 13))
+
+	appendLines(t, filename, 13, 40, true)
+	assertLines(t, r.read(t, 27), logLines(13, 40))
+	assertNoMoreLines(t, r)
+}
+
+// 重启后从检查点继续：不重复已处理的行，也不丢失停止期间写入的行
+func TestFollowCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	appendLines(t, filename, 0, 10, true)
+
+	r := startTailer(t, filename, FollowOptions{FromStart: true, Checkpoint: checkpoint})
+	assertLines(t, r.read(t, 10), logLines(0, 10))
+	// 停止时第10行还没写完，检查点只记录完整的行
+	appendLines(t, filename, 10, 11, false)
+	time.Sleep(30 * time.Millisecond)
+	if extra := r.extra(); len(extra) > 0 {
+		t.Fatalf("got unexpected lines %q", extra)
+	}
+
+	cp, err := LoadFollowCheckpoint(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil {
+		t.Fatal("checkpoint was not written")
+	}
+	if want := int64(10 * len(logLine(0)+"\n")); cp.Offset != want {
+		t.Errorf("got checkpoint offset %d, want %d", cp.Offset, want)
+	}
+	if cp.Path != filename {
+		t.Errorf("got checkpoint path %q, want %q", cp.Path, filename)
+	}
+
+	// 停止期间写完第10行并继续写入
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	appendLines(t, filename, 11, 20, true)
+
+	// FromStart 只在没有可用检查点时生效
+	r = startTailer(t, filename, FollowOptions{FromStart: true, Checkpoint: checkpoint})
+	assertLines(t, r.read(t, 10), logLines(10, 20))
+	assertNoMoreLines(t, r)
+
+	cp, err = LoadFollowCheckpoint(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(20 * len(logLine(0)+"\n")); cp.Offset != want {
+		t.Errorf("got checkpoint offset %d after restart, want %d", cp.Offset, want)
+	}
+}
+
+// 停止期间文件已经轮转：指纹不一致，从头读取新文件
+func TestFollowCheckpointRotated(t *testing.T) {
+	tests := []struct {
+		name   string
+		rotate func(t *testing.T, filename string)
+	}{
+		{"改名", func(t *testing.T, filename string) {
+			if err := os.Rename(filename, filename+".1"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"截断", func(t *testing.T, filename string) {
+			if err := os.Truncate(filename, 0); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "access.log")
+			checkpoint := filepath.Join(dir, "checkpoint.json")
+			appendLines(t, filename, 0, 10, true)
+
+			r := startTailer(t, filename, FollowOptions{Checkpoint: checkpoint, FromStart: true})
+			assertLines(t, r.read(t, 10), logLines(0, 10))
+			r.stop()
+
+			// 新文件比检查点的偏移更长，只能靠指纹发现轮转
+			tt.rotate(t, filename)
+			appendLines(t, filename, 10, 40, true)
+
+			r = startTailer(t, filename, FollowOptions{Checkpoint: checkpoint})
+			assertLines(t, r.read(t, 30), logLines(10, 40))
+			assertNoMoreLines(t, r)
+		})
+	}
+}
+
+// 没有检查点时默认只读取启动后写入的内容
+func TestFollowFromEnd(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	appendLines(t, filename, 0, 10, true)
+
+	r := startTailer(t, filename, FollowOptions{})
+	time.Sleep(50 * time.Millisecond) // 等待打开文件
+	appendLines(t, filename, 10, 15, true)
+	assertLines(t, r.read(t, 5), logLines(10, 15))
+	assertNoMoreLines(t, r)
+}
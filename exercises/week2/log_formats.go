@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+扩展的日志格式：
+
+1. Combined Log Format：在 Common 格式后追加 "referer" "user-agent"
+2. 自定义格式：由 Nginx 的 log_format 字符串编译出解析器，例如
+   $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent
+*/
+
+// Nginx 默认的 combined 格式
+const NginxCombinedFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+// Combined Log Format 解析器，严格/宽松模式与 CommonLogParser 一致
+type CombinedLogParser struct {
+	CommonLogParser
+}
+
+func (p *CombinedLogParser) Parse(line string) (*LogEntry, error) {
+	fields, err := splitLogFields(line)
+	if err != nil && !p.Lenient {
+		return nil, &LogProcessingError{Line: line, Cause: err}
+	}
+
+	if p.Lenient {
+		entry, err := p.salvage(fields)
+		if err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+		p.salvageAgent(entry, fields)
+		return entry, nil
+	}
+
+	if len(fields) != 9 || !isQuoted(fields[7]) || !isQuoted(fields[8]) {
+		return nil, &LogProcessingError{
+			Line:  line,
+			Cause: fmt.Errorf("%w: expected 9 fields ending with quoted referer and user agent", ErrMalformedLine),
+		}
+	}
+	entry := &LogEntry{}
+	if err := p.parseFields(entry, fields[:7]); err != nil {
+		return nil, &LogProcessingError{Line: line, Cause: err}
+	}
+	entry.Referer = dashToEmpty(unquoteField(fields[7]))
+	entry.UserAgent = dashToEmpty(unquoteField(fields[8]))
+	return entry, nil
+}
+
+// 请求行之后的两个带引号字段依次是 referer 和 user agent
+func (p *CombinedLogParser) salvageAgent(entry *LogEntry, fields []string) {
+	var quoted []string
+	for _, field := range fields {
+		if isQuoted(field) {
+			quoted = append(quoted, field)
+		}
+	}
+	if len(quoted) > 1 {
+		entry.Referer = dashToEmpty(unquoteField(quoted[1]))
+	}
+	if len(quoted) > 2 {
+		entry.UserAgent = dashToEmpty(unquoteField(quoted[2]))
+	} else {
+		entry.Partial = true
+	}
+}
+
+// 由 Nginx log_format 编译出的解析器
+// 未知的变量会被匹配但忽略，因此可以直接使用线上的格式定义
+type NginxFormatParser struct {
+	format  string
+	pattern *regexp.Regexp
+	vars    []string // 与正则分组一一对应的变量名
+}
+
+// 匹配 $name 或 ${name}
+var nginxVarPattern = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// 编译 log_format 字符串
+func NewNginxFormatParser(format string) (*NginxFormatParser, error) {
+	matches := nginxVarPattern.FindAllStringSubmatchIndex(format, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("log format %q contains no variables", format)
+	}
+
+	var expr strings.Builder
+	var vars []string
+	expr.WriteString("^")
+	last := 0
+	for i, m := range matches {
+		literal := format[last:m[0]]
+		// 两个变量之间没有分隔符时无法确定边界
+		if i > 0 && literal == "" {
+			return nil, fmt.Errorf("log format %q: variables $%s and the next one are not separated", format, vars[len(vars)-1])
+		}
+		expr.WriteString(regexp.QuoteMeta(literal))
+
+		var name string
+		if m[2] >= 0 {
+			name = format[m[2]:m[3]] // ${name}
+		} else {
+			name = format[m[4]:m[5]] // $name
+		}
+		vars = append(vars, name)
+		switch {
+		case i == len(matches)-1 && m[1] == len(format):
+			expr.WriteString("(.*)")
+		case strings.HasPrefix(format[m[1]:], `"`):
+			// 引号内的值可能包含 \" 转义，不能在第一个引号处截断
+			expr.WriteString(`((?:[^"\\]|\\.)*)`)
+		default:
+			expr.WriteString("(.*?)")
+		}
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(format[last:]))
+	expr.WriteString("$")
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile log format %q: %w", format, err)
+	}
+	return &NginxFormatParser{format: format, pattern: pattern, vars: vars}, nil
+}
+
+func (p *NginxFormatParser) Parse(line string) (*LogEntry, error) {
+	values := p.pattern.FindStringSubmatch(line)
+	if values == nil {
+		return nil, &LogProcessingError{
+			Line:  line,
+			Cause: fmt.Errorf("%w: does not match log format", ErrMalformedLine),
+		}
+	}
+
+	entry := &LogEntry{}
+	for i, name := range p.vars {
+		if err := p.assign(entry, name, values[i+1]); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+	}
+	return entry, nil
+}
+
+// 把变量的值写入对应字段
+func (p *NginxFormatParser) assign(entry *LogEntry, name, value string) error {
+	var err error
+	switch name {
+	case "remote_addr":
+		entry.IP, err = parseIP(value)
+	case "remote_user":
+		entry.RemoteUser = dashToEmpty(value)
+	case "time_local":
+		entry.Timestamp, err = parseTimeValue(CommonTimeLayout, value)
+	case "time_iso8601":
+		entry.Timestamp, err = parseTimeValue(time.RFC3339, value)
+	case "request":
+		entry.Method, entry.URL, entry.Protocol, err = parseRequest(value)
+	case "request_method":
+		entry.Method = value
+	case "request_uri", "uri":
+		entry.URL = value
+	case "server_protocol":
+		entry.Protocol = value
+	case "status":
+		entry.StatusCode, err = parseStatus(value)
+	case "body_bytes_sent", "bytes_sent":
+		entry.Size, err = parseSize(value)
+	case "http_referer":
+		entry.Referer = dashToEmpty(unescapeField(value))
+	case "http_user_agent":
+		entry.UserAgent = dashToEmpty(unescapeField(value))
+	case "request_time":
+		entry.ResponseTime, err = parseSeconds(value)
+		entry.HasResponseTime = err == nil
+	case "upstream_response_time":
+		// 只在没有 $request_time 时使用，$request_time 包含了完整的请求耗时
+		if entry.HasResponseTime || value == "-" {
+			break
+		}
+		entry.ResponseTime, err = parseUpstreamTime(value)
+		entry.HasResponseTime = err == nil
+	}
+	return err
+}
+
+// 以秒为单位、精确到毫秒的耗时，如 "0.123"
+func parseSeconds(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, value)
+	}
+	return time.Duration(math.Round(seconds * float64(time.Second))), nil
+}
+
+// 请求被转发给多个上游时各段耗时以 ", " 或 " : " 分隔，总耗时为各段之和
+func parseUpstreamTime(value string) (time.Duration, error) {
+	var total time.Duration
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		if part == "-" {
+			continue
+		}
+		d, err := parseSeconds(part)
+		if err != nil {
+			return 0, err
+		}
+		total += d
+	}
+	return total, nil
+}
+
+func parseTimeValue(layout, value string) (time.Time, error) {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, value)
+	}
+	return t, nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+测试日志生成器：
+
+按配置生成模拟的访问日志，用于驱动分析器的测试和性能测试。
+
+- 流量曲线：时间范围按分钟切分，每分钟的请求数与曲线的权重成正比，
+  日志按时间顺序输出，内存占用与总行数无关
+- 错误突发：随机选取若干时间段，段内 5xx 比例和响应时间大幅上升
+- 爬虫：一部分请求来自爬虫的 User-Agent 和IP段，均匀地访问各个页面
+- 格式错误的行：按比例输出截断的行、错误的时间戳或随机文本
+- 相同的 Seed 和配置总是生成完全相同的内容
+*/
+
+// 流量曲线，返回某一时刻的相对流量（非负）
+type TrafficCurve func(t time.Time) float64
+
+// 平稳流量
+func FlatCurve(time.Time) float64 { return 1 }
+
+// 日周期流量：下午两点左右最高，凌晨四点左右最低
+func DiurnalCurve(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return 1 + 0.8*math.Cos(2*math.Pi*(hour-14)/24)
+}
+
+type GeneratorConfig struct {
+	Seed     int64
+	Format   string        // common、combined 或 json，默认 combined
+	Lines    int           // 总行数（包括格式错误的行），默认10000
+	Start    time.Time     // 第一条日志的时间，默认 2023-12-25 00:00:00 UTC
+	Duration time.Duration // 日志覆盖的时间范围，默认24小时
+	Curve    TrafficCurve  // 默认 DiurnalCurve
+
+	Clients int // 普通访客的IP数量，默认1000
+	Paths   int // 不同页面的数量，默认500
+
+	// 以下比例为0时使用默认值，为负数时关闭
+	ErrorRate     float64 // 正常时段 4xx/5xx 的比例，默认0.02
+	BotRate       float64 // 爬虫请求的比例，默认0.1
+	MalformedRate float64 // 格式错误的行的比例，默认0.001
+
+	Bursts         int           // 错误突发的次数，默认0
+	BurstDuration  time.Duration // 每次突发的时长，默认5分钟
+	BurstErrorRate float64       // 突发期间 5xx 的比例，默认0.5
+}
+
+func (c GeneratorConfig) withDefaults() GeneratorConfig {
+	if c.Format == "" {
+		c.Format = "combined"
+	}
+	if c.Lines <= 0 {
+		c.Lines = 10000
+	}
+	if c.Start.IsZero() {
+		c.Start = time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+	}
+	if c.Duration <= 0 {
+		c.Duration = 24 * time.Hour
+	}
+	if c.Curve == nil {
+		c.Curve = DiurnalCurve
+	}
+	if c.Clients <= 0 {
+		c.Clients = 1000
+	}
+	if c.Paths <= 0 {
+		c.Paths = 500
+	}
+	c.ErrorRate = rateOrDefault(c.ErrorRate, 0.02)
+	c.BotRate = rateOrDefault(c.BotRate, 0.1)
+	c.MalformedRate = rateOrDefault(c.MalformedRate, 0.001)
+	if c.BurstDuration <= 0 {
+		c.BurstDuration = 5 * time.Minute
+	}
+	c.BurstErrorRate = rateOrDefault(c.BurstErrorRate, 0.5)
+	return c
+}
+
+func rateOrDefault(rate, def float64) float64 {
+	switch {
+	case rate < 0:
+		return 0
+	case rate == 0:
+		return def
+	case rate > 1:
+		return 1
+	}
+	return rate
+}
+
+// 错误突发的时间段 [Start, End)
+type GeneratorBurst struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (b GeneratorBurst) Contains(t time.Time) bool {
+	return !t.Before(b.Start) && t.Before(b.End)
+}
+
+type LogGenerator struct {
+	config  GeneratorConfig
+	rng     *rand.Rand
+	clients []string
+	paths   []string
+	visitor *rand.Zipf // 访客IP、页面和浏览器都是少数热门、大量冷门
+	pages   *rand.Zipf
+	agents  *rand.Zipf
+	bursts  []GeneratorBurst
+}
+
+func NewLogGenerator(config GeneratorConfig) (*LogGenerator, error) {
+	config = config.withDefaults()
+	switch config.Format {
+	case "common", "combined", "json":
+	default:
+		return nil, fmt.Errorf("unsupported generator format: %s", config.Format)
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	g := &LogGenerator{config: config, rng: rng}
+	g.clients = make([]string, config.Clients)
+	for i := range g.clients {
+		g.clients[i] = fmt.Sprintf("%d.%d.%d.%d", 1+rng.Intn(223), rng.Intn(256), rng.Intn(256), 1+rng.Intn(254))
+	}
+	g.paths = generatePaths(rng, config.Paths)
+	g.visitor = rand.NewZipf(rng, 1.1, 1, uint64(len(g.clients)-1))
+	g.pages = rand.NewZipf(rng, 1.2, 1, uint64(len(g.paths)-1))
+	g.agents = rand.NewZipf(rng, 1.5, 1, uint64(len(browserAgents)-1))
+
+	span := config.Duration - config.BurstDuration
+	for i := 0; i < config.Bursts; i++ {
+		start := config.Start
+		if span > 0 {
+			start = start.Add(time.Duration(rng.Int63n(int64(span)))).Truncate(time.Minute)
+		}
+		g.bursts = append(g.bursts, GeneratorBurst{Start: start, End: start.Add(config.BurstDuration)})
+	}
+	sort.Slice(g.bursts, func(i, j int) bool { return g.bursts[i].Start.Before(g.bursts[j].Start) })
+	return g, nil
+}
+
+// 错误突发的时间段，按开始时间排序
+func (g *LogGenerator) Bursts() []GeneratorBurst {
+	return g.bursts
+}
+
+// 按时间顺序逐行生成日志。entry 为生成该行的条目，格式错误的行为 nil；
+// emit 返回错误时停止生成并返回该错误
+func (g *LogGenerator) Generate(emit func(line string, entry *LogEntry) error) error {
+	config := g.config
+	step := time.Minute
+	if config.Duration < step {
+		step = config.Duration
+	}
+	buckets := int((config.Duration + step - 1) / step)
+	weights := make([]float64, buckets)
+	total := 0.0
+	for i := range weights {
+		w := config.Curve(config.Start.Add(time.Duration(i) * step))
+		if w < 0 {
+			w = 0
+		}
+		// 加一点随机波动，避免曲线过于平滑
+		w *= 0.9 + 0.2*g.rng.Float64()
+		weights[i] = w
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("traffic curve is zero over the whole range")
+	}
+
+	// 按累计权重四舍五入分配每分钟的行数，总数恰好为 Lines
+	cumulative := 0.0
+	emitted := 0
+	offsets := make([]int64, 0, 64)
+	for i, w := range weights {
+		cumulative += w
+		n := int(math.Round(cumulative/total*float64(config.Lines))) - emitted
+		if n <= 0 {
+			continue
+		}
+		emitted += n
+
+		bucketStart := config.Start.Add(time.Duration(i) * step)
+		width := int64(step)
+		if rest := int64(config.Duration) - int64(i)*int64(step); rest < width {
+			width = rest
+		}
+		offsets = offsets[:0]
+		for j := 0; j < n; j++ {
+			offsets = append(offsets, g.rng.Int63n(width))
+		}
+		sort.Slice(offsets, func(a, b int) bool { return offsets[a] < offsets[b] })
+
+		for _, off := range offsets {
+			ts := bucketStart.Add(time.Duration(off))
+			entry := g.entry(ts)
+			line := g.format(entry)
+			if g.rng.Float64() < config.MalformedRate {
+				line, entry = g.malform(line), nil
+			}
+			if err := emit(line, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 实现 io.WriterTo，每行以换行结尾
+func (g *LogGenerator) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriterSize(w, defaultBufferSize)
+	var written int64
+	err := g.Generate(func(line string, _ *LogEntry) error {
+		n, err := bw.WriteString(line)
+		written += int64(n)
+		if err == nil {
+			err = bw.WriteByte('\n')
+			if err == nil {
+				written++
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// 生成日志写入文件，文件名以 .gz 结尾时用 gzip 压缩
+func (g *LogGenerator) WriteFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filename, err)
+	}
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if strings.HasSuffix(filename, ".gz") {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+	_, err = g.WriteTo(w)
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ---------- 条目生成 ----------
+
+var (
+	browserAgents = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	}
+
+	generatorBots = []struct {
+		agent   string
+		network string // 爬虫来自固定的网段，IP 为网段前缀加最后一段
+	}{
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "66.249.66."},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "157.55.39."},
+		{"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)", "5.255.253."},
+		{"python-requests/2.31.0", "203.0.113."},
+		{"curl/8.4.0", "198.51.100."},
+	}
+
+	generatorReferers = []string{
+		"https://www.google.com/",
+		"https://www.bing.com/",
+		"https://news.ycombinator.com/",
+	}
+
+	generatorUsers = []string{"alice", "bob", "carol"}
+)
+
+// 生成页面路径：首页和静态资源之外，是带ID的API和文章页
+func generatePaths(rng *rand.Rand, n int) []string {
+	paths := []string{"/", "/index.html", "/static/app.js", "/static/style.css", "/favicon.ico", "/login", "/api/search"}
+	for len(paths) < n {
+		switch rng.Intn(4) {
+		case 0:
+			paths = append(paths, fmt.Sprintf("/api/users/%d", rng.Intn(100000)))
+		case 1:
+			paths = append(paths, fmt.Sprintf("/api/orders/%d", rng.Intn(1000000)))
+		case 2:
+			paths = append(paths, fmt.Sprintf("/blog/%d/post-%d.html", 2015+rng.Intn(9), rng.Intn(10000)))
+		default:
+			paths = append(paths, fmt.Sprintf("/static/img/%x.png", rng.Int63()))
+		}
+	}
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+func (g *LogGenerator) inBurst(t time.Time) bool {
+	for _, b := range g.bursts {
+		if b.Contains(t) {
+			return true
+		}
+		if t.Before(b.Start) {
+			break
+		}
+	}
+	return false
+}
+
+func (g *LogGenerator) entry(ts time.Time) *LogEntry {
+	rng := g.rng
+	entry := &LogEntry{
+		Timestamp: ts,
+		Method:    "GET",
+		Protocol:  "HTTP/1.1",
+		Referer:   "-",
+	}
+
+	if rng.Float64() < g.config.BotRate {
+		bot := generatorBots[rng.Intn(len(generatorBots))]
+		entry.IP = bot.network + strconv.Itoa(1+rng.Intn(254))
+		entry.UserAgent = bot.agent
+		// 爬虫均匀地访问各个页面，也会请求 robots.txt
+		if rng.Intn(20) == 0 {
+			entry.URL = "/robots.txt"
+		} else {
+			entry.URL = g.paths[rng.Intn(len(g.paths))]
+		}
+	} else {
+		entry.IP = g.clients[g.visitor.Uint64()]
+		entry.UserAgent = browserAgents[g.agents.Uint64()]
+		entry.URL = g.paths[g.pages.Uint64()]
+		switch p := rng.Float64(); {
+		case p < 0.1:
+			entry.Method = "POST"
+		case p < 0.12:
+			entry.Method = "PUT"
+		case p < 0.13:
+			entry.Method = "DELETE"
+		}
+		if rng.Intn(3) == 0 {
+			entry.Referer = generatorReferers[rng.Intn(len(generatorReferers))]
+		} else if rng.Intn(2) == 0 {
+			entry.Referer = "https://example.com" + g.paths[g.pages.Uint64()]
+		}
+		if rng.Intn(20) == 0 {
+			entry.RemoteUser = generatorUsers[rng.Intn(len(generatorUsers))]
+		}
+		if rng.Intn(10) == 0 {
+			entry.Protocol = "HTTP/2.0"
+		}
+	}
+
+	// 响应时间服从对数正态分布，中位数约30ms
+	latency := math.Exp(math.Log(30) + 0.8*rng.NormFloat64())
+	burst := g.inBurst(ts)
+	switch {
+	case burst && rng.Float64() < g.config.BurstErrorRate:
+		entry.StatusCode = []int{500, 502, 503, 503, 504}[rng.Intn(5)]
+		latency *= 20
+	case rng.Float64() < g.config.ErrorRate:
+		// 正常时段的错误以 4xx 为主
+		entry.StatusCode = []int{404, 404, 404, 403, 400, 401, 500, 502}[rng.Intn(8)]
+	case entry.Method == "POST" && entry.URL == "/login":
+		entry.StatusCode = 302
+	case rng.Intn(10) == 0:
+		entry.StatusCode = 304
+	default:
+		entry.StatusCode = 200
+	}
+	if burst {
+		latency *= 3
+	}
+	entry.ResponseTime = time.Duration(latency * float64(time.Millisecond)).Round(time.Millisecond)
+	entry.HasResponseTime = true
+
+	switch {
+	case entry.StatusCode == 304 || entry.StatusCode == 302:
+		entry.Size = 0
+	case entry.StatusCode >= 400:
+		entry.Size = int64(150 + rng.Intn(400))
+	default:
+		entry.Size = int64(math.Exp(8 + 1.2*rng.NormFloat64()))
+	}
+	return entry
+}
+
+// ---------- 输出格式 ----------
+
+// JSON 格式的时间精确到毫秒
+const generatedJSONTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// JSON 格式的字段名与 DefaultJSONFieldMapping 一致
+type generatedJSONLine struct {
+	Time       string `json:"time"`
+	RemoteAddr string `json:"remote_addr"`
+	RemoteUser string `json:"remote_user,omitempty"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	Protocol   string `json:"protocol"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	Referer    string `json:"referer,omitempty"`
+	UserAgent  string `json:"user_agent"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+func (g *LogGenerator) format(e *LogEntry) string {
+	if g.config.Format == "json" {
+		line := generatedJSONLine{
+			Time:       e.Timestamp.Format(generatedJSONTimeLayout),
+			RemoteAddr: e.IP,
+			RemoteUser: e.RemoteUser,
+			Method:     e.Method,
+			URL:        e.URL,
+			Protocol:   e.Protocol,
+			Status:     e.StatusCode,
+			Bytes:      e.Size,
+			UserAgent:  e.UserAgent,
+			DurationMs: e.ResponseTime.Milliseconds(),
+		}
+		if e.Referer != "-" {
+			line.Referer = e.Referer
+		}
+		data, _ := json.Marshal(line)
+		return string(data)
+	}
+
+	user := e.RemoteUser
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if e.Size > 0 {
+		size = strconv.FormatInt(e.Size, 10)
+	}
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		e.IP, user, e.Timestamp.Format(CommonTimeLayout), e.Method, e.URL, e.Protocol, e.StatusCode, size)
+	if g.config.Format == "combined" {
+		line += fmt.Sprintf(` "%s" "%s"`, e.Referer, e.UserAgent)
+	}
+	return line
+}
+
+// 把一行正常的日志变成格式错误的行
+func (g *LogGenerator) malform(line string) string {
+	switch g.rng.Intn(3) {
+	case 0:
+		// 截断，保证连请求行都不完整
+		if i := strings.IndexByte(line, '"'); i > 0 {
+			return line[:i+1+g.rng.Intn(4)]
+		}
+		return line[:len(line)/2]
+	case 1:
+		// 时间戳无法解析
+		if g.config.Format == "json" {
+			return strings.Replace(line, `"time":"`, `"time":"yesterday `, 1)
+		}
+		if i, j := strings.IndexByte(line, '['), strings.IndexByte(line, ']'); i >= 0 && j > i {
+			return line[:i+1] + "yesterday" + line[j:]
+		}
+	}
+	return fmt.Sprintf("garbage %x", g.rng.Int63())
+}
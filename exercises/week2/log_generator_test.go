@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成的时间在 JSON 格式中精确到毫秒，Common 格式中精确到秒
+func precisionOf(format string) time.Duration {
+	if format == "json" {
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+// 生成的日志，用作处理器测试的输入
+type generatedLog struct {
+	data      string
+	malformed int64 // 格式错误的行数
+	firstBad  int   // 第一个格式错误的行号，没有时为0
+}
+
+func generateLog(t testing.TB, config GeneratorConfig) generatedLog {
+	t.Helper()
+	generator, err := NewLogGenerator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log generatedLog
+	var data strings.Builder
+	lineNum := 0
+	generator.Generate(func(line string, entry *LogEntry) error {
+		data.WriteString(line + "\n")
+		lineNum++
+		if entry == nil {
+			log.malformed++
+			if log.firstBad == 0 {
+				log.firstBad = lineNum
+			}
+		}
+		return nil
+	})
+	log.data = data.String()
+	return log
+}
+
+// 按 config 创建处理器并处理 log，返回 ProcessReader 的结果和错误；
+// setup 不为 nil 时在处理前调用，用于设置并发数、监控、指标等
+func processLog(t testing.TB, log generatedLog, config ProcessorConfig, setup func(*LogProcessor)) (*LogProcessor, *AnalysisResult, error) {
+	t.Helper()
+	processor, err := CreateProcessor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(processor)
+	}
+	result, err := processor.ProcessReader(strings.NewReader(log.data))
+	return processor, result, err
+}
+
+// 生成日志并处理，处理出错时测试失败
+func processGenerated(t testing.TB, gen GeneratorConfig, config ProcessorConfig, setup func(*LogProcessor)) (*LogProcessor, *AnalysisResult) {
+	t.Helper()
+	processor, result, err := processLog(t, generateLog(t, gen), config, setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return processor, result
+}
+
+var generatorTestConfig = GeneratorConfig{Seed: 7, Lines: 50000, Bursts: 2, MalformedRate: 0.01}
+
+// 相同的种子生成相同的内容
+func TestLogGeneratorDeterministic(t *testing.T) {
+	digest := func(c GeneratorConfig) string {
+		g, err := NewLogGenerator(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.New()
+		if _, err := g.WriteTo(h); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%x", h.Sum(nil))
+	}
+	other := generatorTestConfig
+	other.Seed = 8
+	if digest(generatorTestConfig) != digest(generatorTestConfig) {
+		t.Error("seed 7 produced different output twice")
+	}
+	if digest(generatorTestConfig) == digest(other) {
+		t.Error("seed 7 and seed 8 produced the same output")
+	}
+}
+
+// 生成的日志能被对应的解析器还原，且统计特征与配置一致
+func TestLogGeneratorFormats(t *testing.T) {
+	for _, format := range []string{"common", "combined", "json"} {
+		t.Run(format, func(t *testing.T) {
+			c := generatorTestConfig
+			c.Format = format
+			g, err := NewLogGenerator(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parser, err := newParser(ProcessorConfig{ParserType: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lines, malformed int
+			var burstRequests, burstErrors, normalRequests, normalErrors int
+			last := time.Time{}
+			err = g.Generate(func(line string, entry *LogEntry) error {
+				lines++
+				parsed, err := parser.Parse(line)
+				if entry == nil {
+					malformed++
+					if err == nil {
+						t.Errorf("malformed line parsed: %q", line)
+					}
+					return nil
+				}
+				if err != nil {
+					t.Errorf("parse %q: %v", line, err)
+					return nil
+				}
+				if parsed.IP != entry.IP || parsed.URL != entry.URL || parsed.StatusCode != entry.StatusCode ||
+					parsed.Size != entry.Size || !parsed.Timestamp.Equal(entry.Timestamp.Truncate(precisionOf(format))) {
+					t.Errorf("parsed %+v, generated %+v", parsed, entry)
+				}
+				if entry.Timestamp.Before(last) {
+					t.Errorf("timestamp %v after %v", entry.Timestamp, last)
+				}
+				last = entry.Timestamp
+				if g.inBurst(entry.Timestamp) {
+					burstRequests++
+					if entry.StatusCode >= 500 {
+						burstErrors++
+					}
+				} else {
+					normalRequests++
+					if entry.StatusCode >= 500 {
+						normalErrors++
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if lines != c.Lines {
+				t.Errorf("generated %d lines, want %d", lines, c.Lines)
+			}
+			if rate := float64(malformed) / float64(lines); math.Abs(rate-c.MalformedRate) >= 0.003 {
+				t.Errorf("malformed rate = %.4f, want about %.4f", rate, c.MalformedRate)
+			}
+			burstRate := float64(burstErrors) / math.Max(float64(burstRequests), 1)
+			normalRate := float64(normalErrors) / math.Max(float64(normalRequests), 1)
+			if burstRate <= 0.3 || normalRate >= 0.02 {
+				t.Errorf("5xx rate = %.3f in bursts, %.4f otherwise; want > 0.3 and < 0.02", burstRate, normalRate)
+			}
+		})
+	}
+}
+
+// 用生成的日志测量处理速度
+func BenchmarkProcessGenerated(b *testing.B) {
+	log := generateLog(b, GeneratorConfig{Seed: 1, Lines: 200000})
+	b.SetBytes(int64(len(log.data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := processLog(b, log, ProcessorConfig{ParserType: "combined"}, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+/*
+IP 过滤的前缀树：
+
+IPv4 和 IPv6 各一棵二叉前缀树，每一层对应地址的一位，
+网段 a.b.c.d/n 存放在深度为 n 的节点上。查找时沿地址的各位向下走，
+途经的节点就是所有包含该地址的网段，越深越具体，
+因此查找耗时只与地址长度有关（最多32或128步），与网段数量无关。
+
+IPv4 映射的 IPv6 地址（::ffff:10.0.0.1）和网段（::ffff:10.0.0.0/104）
+统一转换为 IPv4 处理；包含整个映射段的 IPv6 网段（如 ::/0）同时覆盖所有 IPv4 地址。
+*/
+
+// 同时命中允许和拒绝列表时的处理规则
+type IPPrecedence int
+
+const (
+	DenyWins     IPPrecedence = iota // 命中拒绝列表即不通过（默认）
+	AllowWins                        // 命中允许列表即通过，用于在封禁网段中放行个别地址
+	LongestMatch                     // 更具体（前缀更长）的规则生效，前缀相同时拒绝
+)
+
+func (p IPPrecedence) String() string {
+	switch p {
+	case AllowWins:
+		return "allow-wins"
+	case LongestMatch:
+		return "longest-match"
+	}
+	return "deny-wins"
+}
+
+func ParseIPPrecedence(s string) (IPPrecedence, error) {
+	switch strings.ToLower(s) {
+	case "", "deny-wins":
+		return DenyWins, nil
+	case "allow-wins":
+		return AllowWins, nil
+	case "longest-match":
+		return LongestMatch, nil
+	}
+	return DenyWins, fmt.Errorf("unknown IP precedence %q (want deny-wins, allow-wins or longest-match)", s)
+}
+
+// 根据命中的最长前缀决定是否通过，-1 表示未命中
+func (p IPPrecedence) decide(allowLen, denyLen int, defaultAllow bool) bool {
+	switch {
+	case allowLen < 0 && denyLen < 0:
+		return defaultAllow
+	case allowLen < 0:
+		return false
+	case denyLen < 0:
+		return true
+	}
+	switch p {
+	case AllowWins:
+		return true
+	case LongestMatch:
+		return allowLen > denyLen
+	}
+	return false
+}
+
+// 把列表编译进前缀树
+func (f *IPFilter) compile() {
+	for _, spec := range f.AllowedIPs {
+		if prefix, err := parsePrefix(spec); err == nil {
+			f.insert(prefix, true)
+		}
+	}
+	for _, spec := range f.BlockedIPs {
+		if prefix, err := parsePrefix(spec); err == nil {
+			f.insert(prefix, false)
+		}
+	}
+}
+
+// 映射段 ::ffff:0:0/96 的起始地址
+var ipv4MappedPrefix = netip.MustParsePrefix("::ffff:0.0.0.0/96")
+
+func (f *IPFilter) insert(prefix netip.Prefix, allow bool) {
+	if prefix.Addr().Is4() {
+		f.v4.insert(prefix, allow)
+		return
+	}
+	f.v6.insert(prefix, allow)
+	// 包含整个映射段的 IPv6 网段也包含所有 IPv4 地址
+	if prefix.Bits() <= ipv4MappedPrefix.Bits() && prefix.Contains(ipv4MappedPrefix.Addr()) {
+		f.v4.insert(netip.PrefixFrom(netip.IPv4Unspecified(), 0), allow)
+	}
+}
+
+func (f *IPFilter) lookup(addr netip.Addr) (allowLen, denyLen int) {
+	addr = addr.Unmap().WithZone("")
+	if addr.Is4() {
+		return f.v4.lookup(addr)
+	}
+	return f.v6.lookup(addr)
+}
+
+// 解析单个IP或CIDR网段，单个IP视为只包含自身的网段；
+// IPv4 映射的地址和网段转换为 IPv4，主机位被清零
+func parsePrefix(spec string) (netip.Prefix, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.Contains(spec, "/") {
+		addr, err := netip.ParseAddr(spec)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidIP, spec)
+		}
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(spec)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: %q", ErrInvalidIP, spec)
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= ipv4MappedPrefix.Bits() {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-ipv4MappedPrefix.Bits())
+	}
+	return prefix.Masked(), nil
+}
+
+// 从文件读取IP列表：每行一个IP或网段，# 之后为注释，空行忽略
+func LoadIPList(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP list: %w", err)
+	}
+	defer file.Close()
+
+	var specs []string
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, err := parsePrefix(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, lineNum, err)
+		}
+		specs = append(specs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return specs, nil
+}
+
+// ---------- 前缀树 ----------
+
+type ipTrie struct {
+	root *ipTrieNode
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	allow    bool // 以该节点为结尾的网段在允许列表中
+	deny     bool // 在拒绝列表中
+}
+
+func addrBit(bytes []byte, i int) int {
+	return int(bytes[i/8]>>(7-i%8)) & 1
+}
+
+func addrBytes(addr netip.Addr) []byte {
+	if addr.Is4() {
+		b := addr.As4()
+		return b[:]
+	}
+	b := addr.As16()
+	return b[:]
+}
+
+func (t *ipTrie) insert(prefix netip.Prefix, allow bool) {
+	if t.root == nil {
+		t.root = &ipTrieNode{}
+	}
+	node := t.root
+	bytes := addrBytes(prefix.Addr())
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := addrBit(bytes, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	if allow {
+		node.allow = true
+	} else {
+		node.deny = true
+	}
+}
+
+// 返回命中的最长允许前缀和最长拒绝前缀的长度，未命中为 -1
+func (t *ipTrie) lookup(addr netip.Addr) (allowLen, denyLen int) {
+	allowLen, denyLen = -1, -1
+	bytes := addrBytes(addr)
+	node := t.root
+	for depth := 0; node != nil; depth++ {
+		if node.allow {
+			allowLen = depth
+		}
+		if node.deny {
+			denyLen = depth
+		}
+		if depth == addr.BitLen() {
+			break
+		}
+		node = node.children[addrBit(bytes, depth)]
+	}
+	return allowLen, denyLen
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 通过命令行参数（-allow、-deny、-ip-precedence）创建过滤器，覆盖边界情况
+func TestIPFilterEdgeCases(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      string
+		deny       string
+		precedence string
+		ip         string
+		want       bool
+	}{
+		{"单个IP", "10.0.0.1", "", "", "10.0.0.1", true},
+		{"单个IP不匹配", "10.0.0.1", "", "", "10.0.0.2", false},
+		{"CIDR网段", "10.0.0.0/8", "", "", "10.255.0.1", true},
+		{"CIDR主机位不为0", "10.1.2.3/8", "", "", "10.9.9.9", true},
+		{"有允许列表时默认拒绝", "10.0.0.0/8", "192.168.0.0/16", "", "8.8.8.8", false},
+		{"无效IP不通过", "", "10.0.0.0/8", "", "not-an-ip", false},
+
+		// IPv4 映射的 IPv6 地址按 IPv4 处理
+		{"映射地址匹配IPv4网段", "10.0.0.0/8", "", "", "::ffff:10.0.0.1", true},
+		{"映射地址不匹配其他网段", "10.0.0.0/8", "", "", "::ffff:11.0.0.1", false},
+		{"映射地址命中拒绝列表", "", "10.0.0.0/8", "", "::ffff:10.0.0.1", false},
+		{"映射网段匹配IPv4地址", "", "::ffff:10.0.0.0/104", "", "10.2.3.4", false},
+
+		// 前缀长度的边界
+		{"/0 覆盖全部IPv4", "", "0.0.0.0/0", "", "8.8.8.8", false},
+		{"IPv4的/0不匹配IPv6", "", "0.0.0.0/0", "", "2001:db8::1", true},
+		{"::/0 覆盖IPv4", "", "::/0", "", "1.1.1.1", false},
+		{"::/0 覆盖IPv6", "", "::/0", "", "2001:db8::1", false},
+		{"/32 命中", "", "192.168.1.1/32", "", "192.168.1.1", false},
+		{"/32 相邻地址", "", "192.168.1.1/32", "", "192.168.1.2", true},
+		{"/128 命中", "", "2001:db8::1/128", "", "2001:db8::1", false},
+		{"/128 相邻地址", "", "2001:db8::1/128", "", "2001:db8::2", true},
+		{"IPv6网段", "2001:db8::/32", "", "", "2001:db8:ffff::1", true},
+		{"IPv6不匹配", "2001:db8::/32", "", "", "2001:db9::1", false},
+		{"带zone的IPv6", "fe80::/10", "", "", "fe80::1%eth0", true},
+
+		// 允许和拒绝的网段重叠
+		{"deny-wins 默认", "10.0.0.5", "10.0.0.0/8", "", "10.0.0.5", false},
+		{"deny-wins 封禁网段内", "10.0.0.5", "10.0.0.0/8", "deny-wins", "10.0.0.5", false},
+		{"deny-wins 允许网段内", "10.0.0.0/8", "10.0.0.0/24", "deny-wins", "10.1.0.1", true},
+		{"allow-wins 放行个别地址", "10.0.0.5", "10.0.0.0/8", "allow-wins", "10.0.0.5", true},
+		{"allow-wins 其余仍拒绝", "10.0.0.5", "10.0.0.0/8", "allow-wins", "10.0.0.6", false},
+		{"allow-wins 映射地址", "10.0.0.5", "10.0.0.0/8", "allow-wins", "::ffff:10.0.0.5", true},
+		{"longest-match 更具体的允许", "10.0.0.5", "10.0.0.0/8", "longest-match", "10.0.0.5", true},
+		{"longest-match 更具体的拒绝", "10.0.0.0/8", "10.0.0.0/24", "longest-match", "10.0.0.7", false},
+		{"longest-match 同长度拒绝", "10.0.0.0/8", "10.0.0.0/8", "longest-match", "10.1.1.1", false},
+		{"longest-match 重叠之外", "10.0.0.0/8", "10.0.0.0/24", "longest-match", "10.0.1.7", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := buildIPFilter(cliOptions{allow: tt.allow, deny: tt.deny, precedence: tt.precedence})
+			if err != nil {
+				t.Fatalf("buildIPFilter: %v", err)
+			}
+			if got := filter.Filter(&LogEntry{IP: tt.ip}); got != tt.want {
+				t.Errorf("allow=%q deny=%q precedence=%q: Filter(%s) = %v, want %v",
+					tt.allow, tt.deny, tt.precedence, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFilterEmptyLists(t *testing.T) {
+	filter, err := buildIPFilter(cliOptions{})
+	if err != nil || filter != nil {
+		t.Fatalf("buildIPFilter with no lists = %v, %v; want nil, nil", filter, err)
+	}
+	if !(&IPFilter{}).Filter(&LogEntry{IP: "1.2.3.4"}) {
+		t.Error("empty IPFilter rejected 1.2.3.4")
+	}
+}
+
+func TestIPFilterInvalid(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "2001:db8::/129", "10.0.0.300", "example.com"} {
+		if _, err := NewIPFilter([]string{spec}, nil); err == nil {
+			t.Errorf("NewIPFilter(%q) succeeded, want error", spec)
+		}
+	}
+	if _, err := buildIPFilter(cliOptions{deny: "10.0.0.0/8", precedence: "first-match"}); err == nil {
+		t.Error("unknown -ip-precedence accepted")
+	}
+}
+
+// 大量网段时查找仍然只与地址长度有关
+func TestIPFilterManyPrefixes(t *testing.T) {
+	blocked := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		blocked = append(blocked, fmt.Sprintf("%d.%d.%d.0/24", 11+i/65536, i/256%256, i%256))
+	}
+	filter := &IPFilter{BlockedIPs: blocked}
+	if filter.Filter(&LogEntry{IP: "11.39.15.200"}) {
+		t.Error("11.39.15.200 should be blocked")
+	}
+	if !filter.Filter(&LogEntry{IP: "12.0.0.1"}) {
+		t.Error("12.0.0.1 should be allowed")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+/*
+响应耗时分位数：
+
+精确计算 p99 需要保存所有耗时，这里使用 DDSketch 的思路：
+按对数划分桶，第 i 个桶覆盖 (γ^(i-1), γ^i]，γ = (1+α)/(1-α)，
+每个桶只记录个数。返回的分位数与真实值的相对误差不超过 α，
+桶数只与耗时的数量级范围有关（1µs 到 1h 约一千个桶），
+两个草图合并时桶逐个相加即可。零值的草图可以直接使用，精度为默认值。
+*/
+
+// 默认的分位数相对误差
+const defaultLatencyAccuracy = 0.01
+
+// 小于该值的耗时计入零值桶（Nginx 的耗时精确到毫秒，0 很常见）
+const minTrackedLatency = time.Microsecond
+
+type LatencySketch struct {
+	accuracy float64
+	gamma    float64
+	logGamma float64
+	buckets  map[int]int64
+	zeros    int64
+
+	count    int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+// 创建相对误差为 accuracy 的草图，accuracy 不在 (0,1) 内时使用默认值
+func NewLatencySketch(accuracy float64) *LatencySketch {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = defaultLatencyAccuracy
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &LatencySketch{
+		accuracy: accuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]int64),
+	}
+}
+
+// 零值的草图在第一次写入时初始化
+func (s *LatencySketch) init() {
+	if s.buckets == nil {
+		*s = *NewLatencySketch(s.accuracy)
+	}
+}
+
+func (s *LatencySketch) Add(d time.Duration) {
+	s.addN(d, 1)
+}
+
+func (s *LatencySketch) addN(d time.Duration, n int64) {
+	s.init()
+	if d < 0 {
+		d = 0
+	}
+	if s.count == 0 || d < s.min {
+		s.min = d
+	}
+	if d > s.max {
+		s.max = d
+	}
+	s.count += n
+	s.sum += d * time.Duration(n)
+
+	if d < minTrackedLatency {
+		s.zeros += n
+		return
+	}
+	s.buckets[s.bucket(d)] += n
+}
+
+func (s *LatencySketch) bucket(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / s.logGamma))
+}
+
+// 桶内所有值都用这个代表值，与桶内任何值的相对误差不超过 α
+func (s *LatencySketch) bucketValue(i int) time.Duration {
+	return time.Duration(2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1))
+}
+
+func (s *LatencySketch) Count() int64 {
+	return s.count
+}
+
+func (s *LatencySketch) Mean() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / time.Duration(s.count)
+}
+
+func (s *LatencySketch) Max() time.Duration {
+	return s.max
+}
+
+// 第 q 分位数（0 <= q <= 1），没有数据时返回0
+func (s *LatencySketch) Quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := int64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+	for _, i := range s.sortedBuckets() {
+		seen += s.buckets[i]
+		if seen > rank {
+			// 代表值可能略超出实际范围，限制在最小值和最大值之间
+			v := s.bucketValue(i)
+			if v < s.min {
+				v = s.min
+			}
+			if v > s.max {
+				v = s.max
+			}
+			return v
+		}
+	}
+	return s.max
+}
+
+func (s *LatencySketch) sortedBuckets() []int {
+	keys := make([]int, 0, len(s.buckets))
+	for i := range s.buckets {
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// 合并另一个草图，other 不会被修改。
+// 精度不同时按对方每个桶的代表值重新分桶，误差为两者之和
+func (s *LatencySketch) Merge(other *LatencySketch) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	s.init()
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+	s.count += other.count
+	s.sum += other.sum
+	s.zeros += other.zeros
+
+	if other.gamma == s.gamma {
+		for i, n := range other.buckets {
+			s.buckets[i] += n
+		}
+		return
+	}
+	for i, n := range other.buckets {
+		s.buckets[s.bucket(other.bucketValue(i))] += n
+	}
+}
+
+func (s *LatencySketch) Clone() *LatencySketch {
+	clone := NewLatencySketch(s.accuracy)
+	clone.Merge(s)
+	return clone
+}
+
+// 常用的耗时统计
+type LatencySummary struct {
+	Count int64
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func (s *LatencySketch) Summary() LatencySummary {
+	return LatencySummary{
+		Count: s.count,
+		Mean:  s.Mean(),
+		P50:   s.Quantile(0.5),
+		P90:   s.Quantile(0.9),
+		P99:   s.Quantile(0.99),
+		Max:   s.max,
+	}
+}
+
+// 以统计摘要的形式输出 JSON
+func (s *LatencySketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Summary())
+}
+
+// 把一条日志的耗时计入结果
+func (r *AnalysisResult) addLatency(entry *LogEntry) {
+	if r.Latency == nil {
+		r.Latency = NewLatencySketch(defaultLatencyAccuracy)
+	}
+	r.Latency.Add(entry.ResponseTime)
+
+	r.statusLatency(entry.StatusCode).Add(entry.ResponseTime)
+
+	// 近似统计模式下 URL 数量不受控制，不按 URL 保存草图
+	if r.URLSketch != nil {
+		return
+	}
+	r.urlLatency(entry.URL).Add(entry.ResponseTime)
+}
+
+func (r *AnalysisResult) mergeLatency(other *AnalysisResult) {
+	if other.Latency != nil {
+		if r.Latency == nil {
+			r.Latency = NewLatencySketch(other.Latency.accuracy)
+		}
+		r.Latency.Merge(other.Latency)
+	}
+	for code, s := range other.StatusLatency {
+		r.statusLatency(code).Merge(s)
+	}
+	if r.URLSketch != nil {
+		r.URLLatency = nil
+		return
+	}
+	for url, s := range other.URLLatency {
+		r.urlLatency(url).Merge(s)
+	}
+}
+
+// 取出（必要时创建）某个状态码的耗时草图
+func (r *AnalysisResult) statusLatency(code int) *LatencySketch {
+	if r.StatusLatency == nil {
+		r.StatusLatency = make(map[int]*LatencySketch)
+	}
+	s, ok := r.StatusLatency[code]
+	if !ok {
+		s = NewLatencySketch(defaultLatencyAccuracy)
+		r.StatusLatency[code] = s
+	}
+	return s
+}
+
+func (r *AnalysisResult) urlLatency(url string) *LatencySketch {
+	if r.URLLatency == nil {
+		r.URLLatency = make(map[string]*LatencySketch)
+	}
+	s, ok := r.URLLatency[url]
+	if !ok {
+		s = NewLatencySketch(defaultLatencyAccuracy)
+		r.URLLatency[url] = s
+	}
+	return s
+}
+
+type URLLatency struct {
+	URL string
+	LatencySummary
+}
+
+// p99 耗时最高的 n 个 URL，n <= 0 返回全部
+func (r *AnalysisResult) SlowestURLs(n int) []URLLatency {
+	urls := make([]URLLatency, 0, len(r.URLLatency))
+	for url, s := range r.URLLatency {
+		urls = append(urls, URLLatency{URL: url, LatencySummary: s.Summary()})
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		if urls[i].P99 != urls[j].P99 {
+			return urls[i].P99 > urls[j].P99
+		}
+		return urls[i].URL < urls[j].URL
+	})
+	if n > 0 && len(urls) > n {
+		urls = urls[:n]
+	}
+	return urls
+}
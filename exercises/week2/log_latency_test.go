@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 对数正态分布的耗时，与 Nginx 的响应时间分布相近
+func lognormalDurations(rng *rand.Rand, n int) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		durations[i] = time.Duration(math.Exp(rng.NormFloat64()+3) * float64(time.Millisecond))
+	}
+	return durations
+}
+
+// 精确的第 q 分位数（与 Quantile 使用相同的排名）
+func exactQuantile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func checkQuantiles(t *testing.T, sketch *LatencySketch, durations []time.Duration, accuracy float64) {
+	t.Helper()
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, q := range []float64{0.01, 0.25, 0.5, 0.9, 0.99, 0.999} {
+		want := exactQuantile(sorted, q)
+		got := sketch.Quantile(q)
+		if relErr := math.Abs(float64(got-want)) / float64(want); relErr > accuracy {
+			t.Errorf("p%v = %v, exact %v, relative error %.4f > %.4f", q*100, got, want, relErr, accuracy)
+		}
+	}
+	if sketch.Max() != sorted[len(sorted)-1] || sketch.Quantile(0) != sorted[0] {
+		t.Errorf("min/max = %v/%v, want %v/%v", sketch.Quantile(0), sketch.Max(), sorted[0], sorted[len(sorted)-1])
+	}
+	if sketch.Count() != int64(len(durations)) {
+		t.Errorf("count = %d, want %d", sketch.Count(), len(durations))
+	}
+}
+
+// 分位数的相对误差不超过草图的精度
+func TestLatencySketch(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	durations := lognormalDurations(rng, 100000)
+	for _, accuracy := range []float64{0.005, defaultLatencyAccuracy, 0.05} {
+		sketch := NewLatencySketch(accuracy)
+		var sum time.Duration
+		for _, d := range durations {
+			sketch.Add(d)
+			sum += d
+		}
+		checkQuantiles(t, sketch, durations, accuracy)
+		if mean := sum / time.Duration(len(durations)); sketch.Mean() != mean {
+			t.Errorf("mean = %v, want %v", sketch.Mean(), mean)
+		}
+	}
+}
+
+func TestLatencySketchMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	durations := lognormalDurations(rng, 20000)
+
+	whole := NewLatencySketch(0)
+	parts := []*LatencySketch{NewLatencySketch(0), NewLatencySketch(0), NewLatencySketch(0)}
+	for i, d := range durations {
+		whole.Add(d)
+		parts[i%len(parts)].Add(d)
+	}
+	merged := NewLatencySketch(0)
+	for _, part := range parts {
+		merged.Merge(part)
+	}
+	// 精度相同时逐桶相加，与一次性统计完全相同
+	if merged.Summary() != whole.Summary() {
+		t.Errorf("merged summary %+v, want %+v", merged.Summary(), whole.Summary())
+	}
+
+	// 合并 nil 和空草图不改变结果，合并不修改对方
+	merged.Merge(nil)
+	merged.Merge(NewLatencySketch(0))
+	if merged.Summary() != whole.Summary() || parts[0].Count() != int64(len(durations)/3+1) {
+		t.Error("merging nil or empty sketches changed the result")
+	}
+
+	// 精度不同时重新分桶，误差为两者之和
+	coarse := NewLatencySketch(0.05)
+	for _, d := range durations[:10000] {
+		coarse.Add(d)
+	}
+	fine := NewLatencySketch(0.01)
+	for _, d := range durations[10000:] {
+		fine.Add(d)
+	}
+	fine.Merge(coarse)
+	checkQuantiles(t, fine, durations, 0.06)
+
+	// Clone 之后互不影响
+	clone := whole.Clone()
+	clone.Add(time.Hour)
+	if whole.Max() == time.Hour || clone.Count() != whole.Count()+1 {
+		t.Error("Clone shares state with the original")
+	}
+}
+
+func TestLatencySketchEmpty(t *testing.T) {
+	sketch := NewLatencySketch(0)
+	for _, q := range []float64{0, 0.5, 1} {
+		if got := sketch.Quantile(q); got != 0 {
+			t.Errorf("empty sketch: Quantile(%v) = %v, want 0", q, got)
+		}
+	}
+	if (sketch.Summary() != LatencySummary{}) {
+		t.Errorf("empty sketch summary = %+v", sketch.Summary())
+	}
+	data, err := json.Marshal(sketch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary LatencySummary
+	if err := json.Unmarshal(data, &summary); err != nil || summary != (LatencySummary{}) {
+		t.Errorf("empty sketch JSON = %s", data)
+	}
+}
+
+// 0 和负的耗时计入零值桶
+func TestLatencySketchZeroDurations(t *testing.T) {
+	sketch := NewLatencySketch(0)
+	for i := 0; i < 60; i++ {
+		sketch.Add(0)
+	}
+	sketch.Add(-time.Second)
+	for i := 0; i < 39; i++ {
+		sketch.Add(100 * time.Millisecond)
+	}
+	if got := sketch.Quantile(0.5); got != 0 {
+		t.Errorf("p50 = %v, want 0", got)
+	}
+	if got := sketch.Quantile(0.9); math.Abs(float64(got-100*time.Millisecond)) > 0.01*float64(100*time.Millisecond) {
+		t.Errorf("p90 = %v, want about 100ms", got)
+	}
+	if sketch.Quantile(0) != 0 || sketch.Count() != 100 {
+		t.Errorf("min %v, count %d; want 0 and 100", sketch.Quantile(0), sketch.Count())
+	}
+}
+
+// 零值的草图可以直接使用和合并
+func TestLatencySketchZeroValue(t *testing.T) {
+	var sketch LatencySketch
+	sketch.Add(10 * time.Millisecond)
+	sketch.Add(30 * time.Millisecond)
+	if sketch.Count() != 2 || sketch.Max() != 30*time.Millisecond {
+		t.Errorf("zero-value sketch: count %d, max %v", sketch.Count(), sketch.Max())
+	}
+	if p50 := sketch.Quantile(0.5); math.Abs(float64(p50-10*time.Millisecond)) > defaultLatencyAccuracy*float64(10*time.Millisecond) {
+		t.Errorf("zero-value sketch: p50 = %v, want about 10ms", p50)
+	}
+
+	var merged LatencySketch
+	merged.Merge(&sketch)
+	if merged.Summary() != sketch.Summary() {
+		t.Errorf("merged into zero value: %+v, want %+v", merged.Summary(), sketch.Summary())
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Prometheus 指标：
+
+MetricsCollector 接入 LogProcessor 后，每条通过过滤的日志和每个解析错误都会实时计入，
+通过 HTTP 以 Prometheus 文本格式（version 0.0.4）输出：
+
+	loganalyzer_lines_total                    读取的日志行数
+	loganalyzer_requests_total{code}           按状态码统计的请求数
+	loganalyzer_response_bytes_total           响应字节总数
+	loganalyzer_request_duration_seconds{class} 按状态码类别（2xx、5xx 等）的耗时直方图
+	loganalyzer_parse_errors_total{cause}      按原因统计的无法解析的行数
+	loganalyzer_last_log_timestamp_seconds     最新一条日志的时间
+
+所有计数器都是原子变量，解析协程并发更新时不加锁；
+计数器只增不减，依次处理多个文件或跟踪模式重启流水线时也不清零，符合 Prometheus 的约定。
+*/
+
+// 耗时直方图的上界（秒），与 Prometheus 客户端库的默认值相同
+var metricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 状态码类别 1xx~5xx，其他状态码计入最后一类
+const metricsStatusClasses = 6
+
+type MetricsCollector struct {
+	lines         int64
+	responseBytes int64
+	lastTimestamp int64 // Unix 纳秒
+
+	status      [600]int64 // 按状态码计数，100 以下和 600 以上的计入 otherStatus
+	otherStatus int64
+
+	durations [metricsStatusClasses]durationHistogram
+	errors    []int64 // 与 errorCauses 一一对应，最后一个为 "other"
+}
+
+type durationHistogram struct {
+	buckets []int64 // 每个区间的计数（非累计），最后一个为 +Inf
+	sum     int64   // 纳秒
+}
+
+func NewMetricsCollector() *MetricsCollector {
+	m := &MetricsCollector{errors: make([]int64, len(errorCauses)+1)}
+	for i := range m.durations {
+		m.durations[i].buckets = make([]int64, len(metricsDurationBuckets)+1)
+	}
+	return m
+}
+
+// 设置指标收集器，nil 表示不收集
+func (p *LogProcessor) SetMetrics(metrics *MetricsCollector) {
+	p.metrics = metrics
+}
+
+// 记录一条通过过滤的日志
+func (m *MetricsCollector) Observe(entry *LogEntry) {
+	if code := entry.StatusCode; code >= 100 && code < len(m.status) {
+		atomic.AddInt64(&m.status[code], 1)
+	} else {
+		atomic.AddInt64(&m.otherStatus, 1)
+	}
+	atomic.AddInt64(&m.responseBytes, entry.Size)
+
+	if entry.HasResponseTime {
+		h := &m.durations[statusClass(entry.StatusCode)]
+		seconds := entry.ResponseTime.Seconds()
+		i := sort.SearchFloat64s(metricsDurationBuckets, seconds)
+		atomic.AddInt64(&h.buckets[i], 1)
+		atomic.AddInt64(&h.sum, int64(entry.ResponseTime))
+	}
+
+	if !entry.Timestamp.IsZero() {
+		ts := entry.Timestamp.UnixNano()
+		for {
+			last := atomic.LoadInt64(&m.lastTimestamp)
+			if ts <= last || atomic.CompareAndSwapInt64(&m.lastTimestamp, last, ts) {
+				break
+			}
+		}
+	}
+}
+
+// 记录读取的一行，无论能否解析
+func (m *MetricsCollector) ObserveLine() {
+	atomic.AddInt64(&m.lines, 1)
+}
+
+// 记录一个解析错误
+func (m *MetricsCollector) ObserveError(err error) {
+	cause := errorCause(err)
+	for i, known := range errorCauses {
+		if known.Error() == cause {
+			atomic.AddInt64(&m.errors[i], 1)
+			return
+		}
+	}
+	atomic.AddInt64(&m.errors[len(errorCauses)], 1)
+}
+
+// 1xx~5xx 对应 0~4，其他为 5
+func statusClass(code int) int {
+	if code >= 100 && code < 600 {
+		return code/100 - 1
+	}
+	return metricsStatusClasses - 1
+}
+
+func statusClassLabel(class int) string {
+	if class < metricsStatusClasses-1 {
+		return strconv.Itoa(class+1) + "xx"
+	}
+	return "other"
+}
+
+// ---------- 文本格式输出 ----------
+
+// 按 Prometheus 文本格式写出所有指标
+func (m *MetricsCollector) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	writeHeader(bw, "loganalyzer_lines_total", "counter", "Log lines read, including lines that failed to parse.")
+	fmt.Fprintf(bw, "loganalyzer_lines_total %d\n", atomic.LoadInt64(&m.lines))
+
+	writeHeader(bw, "loganalyzer_requests_total", "counter", "Requests analyzed, by HTTP status code.")
+	for code := range m.status {
+		if n := atomic.LoadInt64(&m.status[code]); n > 0 {
+			fmt.Fprintf(bw, "loganalyzer_requests_total{code=\"%d\"} %d\n", code, n)
+		}
+	}
+	if n := atomic.LoadInt64(&m.otherStatus); n > 0 {
+		fmt.Fprintf(bw, "loganalyzer_requests_total{code=\"other\"} %d\n", n)
+	}
+
+	writeHeader(bw, "loganalyzer_response_bytes_total", "counter", "Response bytes sent, summed over analyzed requests.")
+	fmt.Fprintf(bw, "loganalyzer_response_bytes_total %d\n", atomic.LoadInt64(&m.responseBytes))
+
+	writeHeader(bw, "loganalyzer_request_duration_seconds", "histogram", "Request duration from the log, by status class.")
+	for class := range m.durations {
+		m.writeHistogram(bw, class)
+	}
+
+	writeHeader(bw, "loganalyzer_parse_errors_total", "counter", "Lines that could not be parsed, by cause.")
+	for i := range m.errors {
+		cause := "other"
+		if i < len(errorCauses) {
+			cause = errorCauses[i].Error()
+		}
+		fmt.Fprintf(bw, "loganalyzer_parse_errors_total{cause=\"%s\"} %d\n", escapeLabel(cause), atomic.LoadInt64(&m.errors[i]))
+	}
+
+	if ts := atomic.LoadInt64(&m.lastTimestamp); ts != 0 {
+		writeHeader(bw, "loganalyzer_last_log_timestamp_seconds", "gauge", "Timestamp of the newest analyzed log entry.")
+		fmt.Fprintf(bw, "loganalyzer_last_log_timestamp_seconds %s\n", formatFloat(float64(ts)/1e9))
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// 各个区间先读一遍再累加，保证 +Inf 和 _count 一致、累计值单调不减
+func (m *MetricsCollector) writeHistogram(w io.Writer, class int) {
+	h := &m.durations[class]
+	counts := make([]int64, len(h.buckets))
+	var total int64
+	for i := range h.buckets {
+		counts[i] = atomic.LoadInt64(&h.buckets[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return
+	}
+	label := statusClassLabel(class)
+	var cumulative int64
+	for i, bound := range metricsDurationBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "loganalyzer_request_duration_seconds_bucket{class=\"%s\",le=\"%s\"} %d\n", label, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "loganalyzer_request_duration_seconds_bucket{class=\"%s\",le=\"+Inf\"} %d\n", label, total)
+	sum := time.Duration(atomic.LoadInt64(&h.sum)).Seconds()
+	fmt.Fprintf(w, "loganalyzer_request_duration_seconds_sum{class=\"%s\"} %s\n", label, formatFloat(sum))
+	fmt.Fprintf(w, "loganalyzer_request_duration_seconds_count{class=\"%s\"} %d\n", label, total)
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 标签值中的反斜杠、双引号和换行需要转义
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// 记录写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// ---------- HTTP ----------
+
+// Prometheus 文本格式的 Content-Type
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func (m *MetricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	m.WriteTo(w)
+}
+
+// 只提供 /metrics 的 HTTP 处理器
+func NewMetricsMux(m *MetricsCollector) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return mux
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 指标与分析结果、错误统计一致，直方图格式正确
+func TestMetricsCollector(t *testing.T) {
+	metrics := NewMetricsCollector()
+	processor, result := processGenerated(t,
+		GeneratorConfig{Seed: 9, Format: "json", Lines: 50000, Bursts: 1, MalformedRate: 0.01},
+		ProcessorConfig{ParserType: "json"},
+		func(p *LogProcessor) {
+			p.SetConcurrency(4)
+			p.SetMetrics(metrics)
+		})
+
+	server := httptest.NewServer(NewMetricsMux(metrics))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("Content-Type"); got != metricsContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metricsContentType)
+	}
+
+	// 按指标名累加样本值，并检查直方图的累计值单调不减
+	sums := make(map[string]float64)
+	last := make(map[string]float64)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sp := strings.LastIndexByte(line, ' ')
+		name, labels := line[:sp], ""
+		if i := strings.IndexByte(name, '{'); i >= 0 {
+			name, labels = name[:i], name[i:]
+		}
+		value, err := strconv.ParseFloat(line[sp+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		if strings.HasSuffix(name, "_bucket") {
+			class := labels[:strings.Index(labels, ",le=")]
+			if value < last[class] {
+				t.Errorf("bucket %s%s = %v, below the previous bucket %v", name, labels, value, last[class])
+			}
+			last[class] = value
+			continue
+		}
+		sums[name] += value
+	}
+
+	summary := processor.ErrorSummary()
+	tests := []struct {
+		metric string
+		want   int64
+	}{
+		{"loganalyzer_requests_total", result.TotalRequests},
+		{"loganalyzer_response_bytes_total", result.TotalBytes},
+		{"loganalyzer_request_duration_seconds_count", result.Latency.Count()},
+		{"loganalyzer_parse_errors_total", summary.Errors},
+		{"loganalyzer_lines_total", summary.Lines},
+	}
+	for _, tt := range tests {
+		if got := int64(sums[tt.metric]); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.metric, got, tt.want)
+		}
+	}
+}
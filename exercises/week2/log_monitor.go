@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+性能监控的接入：
+
+- LogProcessor.SetMonitor 之后，处理的行数、错误数和读取的字节数都会计入监控器；
+  ProcessFile 会把文件大小计入总量，据此估算进度和剩余时间
+- 读取的字节在解压之前统计，gzip 文件的进度同样按文件大小计算
+- StartReporter 定期输出一行进度，Stop 之后用 GetReport 得到最终报告
+- 堆内存通过 runtime.ReadMemStats 读取，会短暂停止所有协程，
+  所以只在生成快照时采样，不在每一行上统计
+*/
+
+// 某一时刻的性能统计
+type PerformanceStats struct {
+	Elapsed     time.Duration
+	Lines       int64
+	Errors      int64
+	Bytes       int64
+	TotalBytes  int64 // 0 表示未知
+	LinesPerSec float64
+	BytesPerSec float64
+	ErrorRate   float64
+	Progress    float64       // 已读取的比例，总量未知时为0
+	ETA         time.Duration // 按当前速度估算的剩余时间，未知时为0
+	HeapAlloc   uint64
+	PeakHeap    uint64
+	NumGC       uint32
+}
+
+// 一行进度，例如：
+// 12.5s  1204332 lines (96346/s)  120.3 MB (9.6 MB/s)  42.1%  ETA 17s  errors 0.10%  heap 8.2 MB
+func (s PerformanceStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v  %d lines (%.0f/s)  %s (%s/s)", s.Elapsed.Round(100*time.Millisecond),
+		s.Lines, s.LinesPerSec, formatBytes(float64(s.Bytes)), formatBytes(s.BytesPerSec))
+	if s.TotalBytes > 0 {
+		fmt.Fprintf(&b, "  %.1f%%", s.Progress*100)
+		if s.ETA > 0 {
+			fmt.Fprintf(&b, "  ETA %v", s.ETA.Round(time.Second))
+		}
+	}
+	fmt.Fprintf(&b, "  errors %.2f%%  heap %s", s.ErrorRate*100, formatBytes(float64(s.HeapAlloc)))
+	return b.String()
+}
+
+func (pm *PerformanceMonitor) Snapshot() PerformanceStats {
+	s := PerformanceStats{
+		Lines:      atomic.LoadInt64(&pm.processedLines),
+		Errors:     atomic.LoadInt64(&pm.errors),
+		Bytes:      atomic.LoadInt64(&pm.bytes),
+		TotalBytes: atomic.LoadInt64(&pm.totalBytes),
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.HeapAlloc, s.NumGC = mem.HeapAlloc, mem.NumGC
+
+	pm.mu.Lock()
+	if mem.HeapAlloc > pm.peakHeap {
+		pm.peakHeap = mem.HeapAlloc
+	}
+	s.PeakHeap = pm.peakHeap
+	if !pm.startTime.IsZero() {
+		end := pm.endTime
+		if end.IsZero() {
+			end = time.Now()
+		}
+		s.Elapsed = end.Sub(pm.startTime)
+	}
+	pm.mu.Unlock()
+
+	if seconds := s.Elapsed.Seconds(); seconds > 0 {
+		s.LinesPerSec = float64(s.Lines) / seconds
+		s.BytesPerSec = float64(s.Bytes) / seconds
+	}
+	if s.Lines > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Lines)
+	}
+	if s.TotalBytes > 0 {
+		s.Progress = float64(s.Bytes) / float64(s.TotalBytes)
+		if s.Progress > 1 {
+			s.Progress = 1
+		}
+		if s.BytesPerSec > 0 && s.Bytes < s.TotalBytes {
+			s.ETA = time.Duration(float64(s.TotalBytes-s.Bytes) / s.BytesPerSec * float64(time.Second))
+		}
+	}
+	return s
+}
+
+// 每隔 interval 向 w 输出一行进度，直到 Stop
+func (pm *PerformanceMonitor) StartReporter(w io.Writer, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	pm.stop, pm.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintln(w, pm.Snapshot())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止计时和定期报告，等报告协程退出后返回，之后不会再向 w 写入
+func (pm *PerformanceMonitor) Stop() {
+	pm.mu.Lock()
+	stop, done := pm.stop, pm.done
+	pm.stop, pm.done = nil, nil
+	if !pm.startTime.IsZero() && pm.endTime.IsZero() {
+		pm.endTime = time.Now()
+	}
+	pm.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// 以 1024 为单位格式化字节数
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// ---------- 接入 LogProcessor ----------
+
+// 设置性能监控器，nil 表示不监控。监控器的 Start 和 Stop 由调用方负责，
+// 依次处理多个文件时计数会一直累加
+func (p *LogProcessor) SetMonitor(monitor *PerformanceMonitor) {
+	p.monitor = monitor
+}
+
+// 统计读取的字节数
+type countingReader struct {
+	r       io.Reader
+	monitor *PerformanceMonitor
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.monitor.RecordBytes(int64(n))
+	return n, err
+}
+
+// 读到一行待解析的日志
+func (p *LogProcessor) countLine() {
+	p.errs.line()
+	if p.monitor != nil {
+		p.monitor.RecordProcessedLine()
+	}
+	if p.metrics != nil {
+		p.metrics.ObserveLine()
+	}
+}
+
+// 一行无法处理，按错误策略决定是否继续，返回非 nil 表示应停止
+func (p *LogProcessor) lineError(line string, lineNum int, err error) error {
+	if p.monitor != nil {
+		p.monitor.RecordError()
+	}
+	if p.metrics != nil {
+		p.metrics.ObserveError(err)
+	}
+	return p.errs.record(line, lineNum, err)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 并发处理时计数准确，进度和速率合理
+func TestPerformanceMonitor(t *testing.T) {
+	log := generateLog(t, GeneratorConfig{Seed: 5, Lines: 100000, MalformedRate: 0.01})
+	size := int64(len(log.data))
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			monitor := &PerformanceMonitor{}
+			monitor.Start()
+			var progress strings.Builder
+			monitor.StartReporter(&progress, 10*time.Millisecond)
+
+			_, _, err := processLog(t, log, ProcessorConfig{ParserType: "combined"}, func(p *LogProcessor) {
+				p.SetConcurrency(workers)
+				p.SetMonitor(monitor)
+				monitor.AddTotalBytes(size)
+			})
+			monitor.Stop()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := monitor.Snapshot()
+			if s.Lines != 100000 || s.Errors != log.malformed || s.Bytes != size {
+				t.Errorf("counted %d lines, %d errors, %d bytes; want 100000, %d, %d",
+					s.Lines, s.Errors, s.Bytes, log.malformed, size)
+			}
+			if s.Progress != 1 || s.LinesPerSec <= 0 {
+				t.Errorf("progress %v, %v lines/s; want 1 and a positive rate", s.Progress, s.LinesPerSec)
+			}
+			// Stop 之后耗时不再增长
+			if elapsed := monitor.Snapshot().Elapsed; elapsed != s.Elapsed {
+				t.Errorf("elapsed grew from %v to %v after Stop", s.Elapsed, elapsed)
+			}
+		})
+	}
+}
+
+func TestPerformanceMonitorReport(t *testing.T) {
+	monitor := &PerformanceMonitor{}
+	monitor.Start()
+	monitor.RecordProcessedLine()
+	if report := monitor.GetReport(); !strings.Contains(report, "处理行数: 1") {
+		t.Errorf("report does not count the processed line:\n%s", report)
+	}
+}
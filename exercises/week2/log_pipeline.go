@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+并发流水线（扇出/扇入）：
+
+	lines ──> N个解析协程（解析+过滤）──> entries ──> K个聚合协程 ──> 合并协程 ──> 输出
+	                                                    （各自持有一个分片）
+
+- 所有通道都有缓冲上限，下游处理不过来时上游自然阻塞（背压）
+- 分析器实现了 ShardableAnalyzer 时，每个聚合协程使用独立分片，互不加锁；
+  聚合协程定期把分片交给合并协程并换一个新分片，合并协程据此输出阶段性结果
+- 不支持分片的分析器只使用一个聚合协程，阶段性结果是分析器结果的副本；
+  流式处理时未满的批次最多等待 maxBatchDelay 就交给分析器，异常检测等
+  按到达时间工作的分析器不会因为攒批而误以为日志停止
+- context 取消后所有协程退出，输出通道关闭，不再发送最终结果；
+  错误策略要求中止时（见 log_errors.go）同样取消流水线，原因由 Err 返回
+*/
+
+// 支持分片聚合的分析器
+type ShardableAnalyzer interface {
+	LogAnalyzer
+	// Fork 创建一个空的同类分析器作为分片
+	Fork() LogAnalyzer
+	// Join 把分片累计的统计并入自身，之后分片不再被使用
+	Join(shard LogAnalyzer)
+	// Snapshot 返回当前结果的独立副本，可以安全地交给其他协程
+	Snapshot() *AnalysisResult
+}
+
+// 默认的阶段性结果输出间隔
+const defaultSnapshotInterval = time.Second
+
+// 流式处理时不支持分片的分析器等待一个批次攒满的最长时间
+const maxBatchDelay = 100 * time.Millisecond
+
+// 设置并发处理的解析协程数，小于等于1时 ProcessFile 按顺序处理
+func (p *LogProcessor) SetConcurrency(workers int) {
+	p.workers = workers
+}
+
+// 设置 ProcessStream 输出阶段性结果的间隔，0 表示只输出最终结果。
+// 分析器是否支持分片都会输出，期间没有新数据时不输出
+func (p *LogProcessor) SetSnapshotInterval(interval time.Duration) {
+	p.snapshotInterval = interval
+}
+
+// 流式处理的带 context 版本。
+// 输出通道依次收到阶段性结果和最终结果，调用方需要一直读取到通道关闭
+func (p *LogProcessor) ProcessStreamContext(ctx context.Context, lines <-chan string) (<-chan *AnalysisResult, error) {
+	return p.startPipeline(ctx, lines, p.snapshotInterval)
+}
+
+func (p *LogProcessor) startPipeline(ctx context.Context, lines <-chan string, interval time.Duration) (<-chan *AnalysisResult, error) {
+	if p.parser == nil || p.analyzer == nil {
+		return nil, errors.New("processor requires a parser and an analyzer")
+	}
+
+	workers := p.workers
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.errs.reset(cancel)
+	out := make(chan *AnalysisResult, 1)
+	entries := make(chan *LogEntry, p.batchSize)
+
+	// 第一阶段：解析和过滤
+	var parseWG sync.WaitGroup
+	parseWG.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer parseWG.Done()
+			p.parseWorker(ctx, lines, entries)
+		}()
+	}
+	go func() {
+		parseWG.Wait()
+		close(entries)
+	}()
+
+	// 第二、三阶段：分片聚合与合并
+	root, shardable := p.analyzer.(ShardableAnalyzer)
+	if !shardable {
+		go func() {
+			defer cancel()
+			p.aggregateSingle(ctx, entries, out, interval)
+		}()
+		return out, nil
+	}
+
+	deltas := make(chan LogAnalyzer, workers)
+	var aggWG sync.WaitGroup
+	aggWG.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer aggWG.Done()
+			p.aggregateShard(ctx, root, entries, deltas, interval)
+		}()
+	}
+	go func() {
+		aggWG.Wait()
+		close(deltas)
+	}()
+	go func() {
+		defer cancel()
+		mergeShards(ctx, root, deltas, out, interval)
+	}()
+	return out, nil
+}
+
+func (p *LogProcessor) parseWorker(ctx context.Context, lines <-chan string, entries chan<- *LogEntry) {
+	for {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		if line == "" {
+			continue
+		}
+		p.countLine()
+		entry, err := p.parser.Parse(line)
+		if err != nil || entry == nil {
+			if err == nil {
+				err = ErrMalformedLine
+			}
+			// 并发解析时不知道行号
+			if p.lineError(line, 0, err) != nil {
+				return
+			}
+			continue
+		}
+		if !p.accept(entry) {
+			continue
+		}
+		if p.metrics != nil {
+			p.metrics.Observe(entry)
+		}
+
+		select {
+		case entries <- entry:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 不支持分片时，由唯一的聚合协程直接使用处理器的分析器。
+// interval > 0（流式处理）时未满的批次最多等待 maxBatchDelay，并定期输出阶段性结果
+func (p *LogProcessor) aggregateSingle(ctx context.Context, entries <-chan *LogEntry, out chan<- *AnalysisResult, interval time.Duration) {
+	defer close(out)
+
+	var batchTick, snapshotTick <-chan time.Time
+	if interval > 0 {
+		batchTicker := time.NewTicker(maxBatchDelay)
+		defer batchTicker.Stop()
+		snapshotTicker := time.NewTicker(interval)
+		defer snapshotTicker.Stop()
+		batchTick, snapshotTick = batchTicker.C, snapshotTicker.C
+	}
+
+	batch := make([]*LogEntry, 0, p.batchSize)
+	var result *AnalysisResult
+	changed := false
+	flush := func() {
+		result = p.analyzer.Analyze(batch)
+		batch = resetBatch(batch)
+		changed = true
+	}
+
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				flush()
+				select {
+				case out <- result:
+				case <-ctx.Done():
+				}
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) == cap(batch) {
+				flush()
+			}
+		case <-batchTick:
+			if len(batch) > 0 {
+				flush()
+			}
+		case <-snapshotTick:
+			if len(batch) > 0 {
+				flush()
+			}
+			if !changed {
+				continue
+			}
+			// 分析器之后还会修改 result，输出副本
+			select {
+			case out <- result.Clone():
+				changed = false
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 聚合协程：把条目累计到自己的分片中，定期和结束时把分片交给合并协程
+func (p *LogProcessor) aggregateShard(ctx context.Context, root ShardableAnalyzer, entries <-chan *LogEntry, deltas chan<- LogAnalyzer, interval time.Duration) {
+	shard := root.Fork()
+	batch := make([]*LogEntry, 0, p.batchSize)
+	dirty := false
+
+	handOff := func() bool {
+		if len(batch) > 0 {
+			shard.Analyze(batch)
+			batch = resetBatch(batch)
+		}
+		if !dirty {
+			return true
+		}
+		select {
+		case deltas <- shard:
+			shard, dirty = root.Fork(), false
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				handOff()
+				return
+			}
+			batch = append(batch, entry)
+			dirty = true
+			if len(batch) == cap(batch) {
+				shard.Analyze(batch)
+				batch = resetBatch(batch)
+			}
+		case <-tick:
+			if !handOff() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 合并协程：唯一修改 root 的协程，定期输出快照，全部分片合并后输出最终结果
+func mergeShards(ctx context.Context, root ShardableAnalyzer, deltas <-chan LogAnalyzer, out chan<- *AnalysisResult, interval time.Duration) {
+	defer close(out)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	changed := false
+	for {
+		select {
+		case shard, ok := <-deltas:
+			if !ok {
+				select {
+				case out <- root.Snapshot():
+				case <-ctx.Done():
+				}
+				return
+			}
+			root.Join(shard)
+			changed = true
+		case <-tick:
+			if !changed {
+				continue
+			}
+			select {
+			case out <- root.Snapshot():
+				changed = false
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 包装其他分析器、在数据流结束时需要收尾的分析器（AnomalyDetector、SessionAnalyzer）
+type wrappingAnalyzer interface {
+	LogAnalyzer
+	Flush()
+	unwrap() LogAnalyzer
+}
+
+// 全部输入处理完之后调用：结束异常检测的当前窗口和进行中的会话。
+// 依次处理多个文件时只在最后调用一次
+func (p *LogProcessor) Flush() {
+	for a := p.analyzer; ; {
+		w, ok := a.(wrappingAnalyzer)
+		if !ok {
+			return
+		}
+		w.Flush()
+		a = w.unwrap()
+	}
+}
+
+// 处理器使用的异常检测器，没有时返回 nil
+func (p *LogProcessor) anomalyDetector() *AnomalyDetector {
+	for a := p.analyzer; ; {
+		if d, ok := a.(*AnomalyDetector); ok {
+			return d
+		}
+		w, ok := a.(wrappingAnalyzer)
+		if !ok {
+			return nil
+		}
+		a = w.unwrap()
+	}
+}
+
+// 清空批次中的引用并复用底层数组
+func resetBatch(batch []*LogEntry) []*LogEntry {
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+// ProcessFile 在启用并发时使用：读取协程按行送入流水线，只取最终结果
+func (p *LogProcessor) processConcurrent(r io.Reader) (*AnalysisResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines := make(chan string, p.batchSize)
+	results, err := p.startPipeline(ctx, lines, 0)
+	if err != nil {
+		return nil, err
+	}
+	aborted := p.errs.done()
+
+	readDone := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		readDone <- p.readLines(r, func(line []byte, _ int) error {
+			select {
+			case lines <- string(line):
+				return nil
+			case <-aborted:
+				return p.Err()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	var result *AnalysisResult
+	for r := range results {
+		result = r
+	}
+	// 中止时流水线不输出最终结果，读取协程也随之退出
+	if err := p.Err(); err != nil {
+		<-readDone
+		return nil, err
+	}
+	if err := <-readDone; err != nil {
+		return nil, err
+	}
+	if err := p.errs.finish(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
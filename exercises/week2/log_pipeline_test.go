@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 在 release 关闭之前阻塞 Analyze，模拟处理不过来的下游
+type blockingAnalyzer struct {
+	LogAnalyzer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingAnalyzer() *blockingAnalyzer {
+	return &blockingAnalyzer{
+		LogAnalyzer: NewBasicAnalyzer(),
+		entered:     make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+}
+
+func (a *blockingAnalyzer) Analyze(entries []*LogEntry) *AnalysisResult {
+	select {
+	case a.entered <- struct{}{}:
+	default:
+	}
+	<-a.release
+	return a.LogAnalyzer.Analyze(entries)
+}
+
+func pipelineLine(i int) string {
+	return fmt.Sprintf(`10.0.%d.%d - - [25/Dec/2023:10:00:00 +0000] "GET /page/%d HTTP/1.1" 200 100`, i/256%256, i%256, i%50)
+}
+
+// 发送 n 行，ctx 取消时停止；返回已送出的行数
+func sendLines(ctx context.Context, n int) (<-chan string, *int64) {
+	lines := make(chan string)
+	var sent int64
+	go func() {
+		defer close(lines)
+		for i := 0; i < n; i++ {
+			select {
+			case lines <- pipelineLine(i):
+				atomic.AddInt64(&sent, 1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, &sent
+}
+
+// 分片合并的结果与顺序处理完全相同
+func TestPipelineShardMerge(t *testing.T) {
+	generator, err := NewLogGenerator(GeneratorConfig{Seed: 11, Format: "json", Lines: 5000, MalformedRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	if _, err := generator.WriteTo(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	var want *AnalysisResult
+	for _, workers := range []int{1, 2, 8} {
+		processor, err := CreateProcessor(ProcessorConfig{ParserType: "json", BatchSize: 64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		processor.SetConcurrency(workers)
+		result, err := processor.ProcessReader(strings.NewReader(data.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == nil {
+			if result.Latency == nil {
+				t.Fatal("generated JSON logs have no response times")
+			}
+			want = result
+			continue
+		}
+		if result.TotalRequests != want.TotalRequests || result.ErrorCount != want.ErrorCount ||
+			result.TotalBytes != want.TotalBytes || result.HourlyRequests != want.HourlyRequests ||
+			!result.FirstSeen.Equal(want.FirstSeen) || !result.LastSeen.Equal(want.LastSeen) {
+			t.Errorf("%d workers: totals differ from sequential processing", workers)
+		}
+		for name, maps := range map[string][2]interface{}{
+			"status":  {result.StatusCodes, want.StatusCodes},
+			"urls":    {result.URLCounts, want.URLCounts},
+			"ips":     {result.IPCounts, want.IPCounts},
+			"methods": {result.Methods, want.Methods},
+		} {
+			if !reflect.DeepEqual(maps[0], maps[1]) {
+				t.Errorf("%d workers: %s counts differ from sequential processing", workers, name)
+			}
+		}
+		if result.Latency.Count() != want.Latency.Count() || result.Latency.Quantile(0.99) != want.Latency.Quantile(0.99) {
+			t.Errorf("%d workers: latency p99 %v over %d, want %v over %d", workers,
+				result.Latency.Quantile(0.99), result.Latency.Count(), want.Latency.Quantile(0.99), want.Latency.Count())
+		}
+	}
+}
+
+// 分析器阻塞时上游只会多读入有限的几批，放行后全部处理完
+func TestPipelineBackpressure(t *testing.T) {
+	for _, shardable := range []bool{true, false} {
+		t.Run(fmt.Sprintf("shardable=%v", shardable), func(t *testing.T) {
+			processor, err := CreateProcessor(ProcessorConfig{ParserType: "common", BatchSize: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			processor.SetConcurrency(2)
+			// 分片聚合时由读取结果的一方阻塞：合并协程输出不了快照，分片就交不出去
+			var blocking *blockingAnalyzer
+			if !shardable {
+				blocking = newBlockingAnalyzer()
+				processor.analyzer = blocking
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			lines, sent := sendLines(ctx, 10000)
+			processor.SetSnapshotInterval(time.Millisecond)
+			results, err := processor.ProcessStreamContext(ctx, lines)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			n := atomic.LoadInt64(sent)
+			if blocking != nil {
+				// 批次 10 + entries 通道 10 + 每个解析协程 1，再留一些余量
+				if n > 40 {
+					t.Errorf("%d lines read while the analyzer was blocked", n)
+				}
+				close(blocking.release)
+			} else if n == 10000 {
+				t.Error("all lines read while nobody consumed the results")
+			}
+
+			var final *AnalysisResult
+			for r := range results {
+				final = r
+			}
+			if final == nil || final.TotalRequests != 10000 {
+				t.Errorf("final result = %+v, want 10000 requests", final)
+			}
+		})
+	}
+}
+
+// 取消后输出通道关闭，不再输出最终结果
+func TestPipelineCancel(t *testing.T) {
+	processor, err := CreateProcessor(ProcessorConfig{ParserType: "common", BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.SetConcurrency(4)
+	blocking := newBlockingAnalyzer()
+	processor.analyzer = blocking
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines, _ := sendLines(ctx, 10000)
+	results, err := processor.ProcessStreamContext(ctx, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-blocking.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("analyzer was never called")
+	}
+	cancel()
+	close(blocking.release)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return
+			}
+			if r.TotalRequests == 10000 {
+				t.Error("final result sent after cancel")
+			}
+		case <-timeout:
+			t.Fatal("output channel not closed after cancel")
+		}
+	}
+}
+
+// 不支持分片的分析器同样按间隔输出阶段性结果
+func TestPipelineSnapshots(t *testing.T) {
+	for _, config := range []ProcessorConfig{
+		{ParserType: "common"},
+		{ParserType: "common", Sessions: &SessionConfig{}},
+	} {
+		processor, err := CreateProcessor(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, shardable := processor.analyzer.(ShardableAnalyzer)
+		processor.SetConcurrency(2)
+		processor.SetSnapshotInterval(10 * time.Millisecond)
+
+		lines := make(chan string)
+		results, err := processor.ProcessStreamContext(context.Background(), lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			lines <- pipelineLine(i)
+		}
+		select {
+		case r := <-results:
+			if r.TotalRequests != 5 {
+				t.Errorf("shardable=%v: snapshot has %d requests, want 5", shardable, r.TotalRequests)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("shardable=%v: no snapshot while the stream was open", shardable)
+		}
+		for i := 5; i < 8; i++ {
+			lines <- pipelineLine(i)
+		}
+		close(lines)
+		var final *AnalysisResult
+		for r := range results {
+			final = r
+		}
+		if final == nil || final.TotalRequests != 8 {
+			t.Errorf("shardable=%v: final result = %+v, want 8 requests", shardable, final)
+		}
+	}
+}
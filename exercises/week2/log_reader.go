@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+/*
+大文件读取：
+
+- bufio.Scanner 默认单行最多 64KB，超过就报 token too long 并停止读取；
+  这里用 ReadSlice 自己拼接长行，行缓冲区在各行之间复用
+- 读取缓冲区的大小由 ProcessorConfig.BufferSize 决定，只影响每次系统调用
+  读取的数据量，比缓冲区长的行同样能完整读取
+- gzip 数据通过魔数自动识别并解压，只在 ProcessReader 中识别一次，
+  调用方传入原始数据即可
+*/
+
+// 默认的读取缓冲区大小
+const defaultBufferSize = 64 * 1024
+
+// 默认的单行最大长度，超过的行会被跳过并记为错误
+const defaultMaxLineLength = 1 << 20
+
+var ErrLineTooLong = errors.New("line exceeds maximum length")
+
+// gzip 文件的魔数
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// 根据开头的魔数判断是否为 gzip 数据，返回（解压后的）带缓冲的 Reader，
+// size 为缓冲区的字节数
+func decompressReader(r io.Reader, size int) (*bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, size)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReaderSize(gz, size), nil
+}
+
+// 不消耗数据地读取开头至多 n 个完整的行，样本最多为一个缓冲区的大小
+func peekLines(br *bufio.Reader, n int) []string {
+	data, err := br.Peek(br.Size())
+	lines := strings.Split(string(data), "\n")
+	// 没有读到结尾时，最后一行可能不完整
+	if err != io.EOF {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// 逐行读取，行缓冲区在各行之间复用
+type lineReader struct {
+	r       *bufio.Reader
+	buf     []byte
+	maxLine int
+	lineNum int
+}
+
+func newLineReader(r io.Reader, maxLine int) *lineReader {
+	if maxLine <= 0 {
+		maxLine = defaultMaxLineLength
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, defaultBufferSize)
+	}
+	return &lineReader{r: br, maxLine: maxLine}
+}
+
+// 读取下一行（不含换行符）。返回的切片在下次调用前有效。
+// 超长的行会被整行丢弃并返回 ErrLineTooLong，之后可以继续读取；
+// 读完时返回 io.EOF
+func (lr *lineReader) next() ([]byte, error) {
+	lr.buf = lr.buf[:0]
+	tooLong := false
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		if !tooLong {
+			if len(lr.buf)+len(chunk) > lr.maxLine+2 { // 预留 \r\n
+				tooLong = true
+				lr.buf = lr.buf[:0]
+			} else {
+				lr.buf = append(lr.buf, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || (len(lr.buf) == 0 && !tooLong)) {
+			return nil, err
+		}
+
+		lr.lineNum++
+		if tooLong {
+			return nil, ErrLineTooLong
+		}
+		return bytes.TrimRight(lr.buf, "\r\n"), nil
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 缓冲区大小只影响读取方式：比缓冲区长的行、gzip 数据和不同的缓冲区得到相同的结果
+func TestProcessReaderBufferSize(t *testing.T) {
+	generator, err := NewLogGenerator(GeneratorConfig{Seed: 6, Lines: 5000, MalformedRate: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if _, err := generator.WriteTo(&plain); err != nil {
+		t.Fatal(err)
+	}
+	longURL := "/search?q=" + strings.Repeat("x", 200*1024)
+	plain.WriteString(`10.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET ` + longURL + ` HTTP/1.1" 200 1 "-" "curl/8.4.0"` + "\r\n")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(plain.Bytes())
+	gz.Close()
+
+	var want *AnalysisResult
+	for _, size := range []int{0, 16, 4096, 1 << 20} {
+		for name, data := range map[string][]byte{"plain": plain.Bytes(), "gzip": compressed.Bytes()} {
+			processor, err := CreateProcessor(ProcessorConfig{ParserType: "combined", BufferSize: size, BatchSize: 7})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := processor.ProcessReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("buffer %d, %s: %v", size, name, err)
+			}
+			if summary := processor.ErrorSummary(); summary.Errors != 0 {
+				t.Errorf("buffer %d, %s: %d lines skipped", size, name, summary.Errors)
+			}
+			if result.URLCounts[longURL] != 1 {
+				t.Errorf("buffer %d, %s: long line not analyzed", size, name)
+			}
+			if want == nil {
+				want = result
+			} else if !reflect.DeepEqual(result, want) {
+				t.Errorf("buffer %d, %s: result differs from the default buffer", size, name)
+			}
+		}
+	}
+	if want.TotalRequests != 5001 {
+		t.Errorf("analyzed %d requests, want 5001", want.TotalRequests)
+	}
+}
+
+// 超过最大长度的行被跳过，之后的行继续读取
+func TestProcessReaderLineTooLong(t *testing.T) {
+	processor, err := CreateProcessor(ProcessorConfig{ParserType: "common", BufferSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.SetMaxLineLength(1024)
+	line := `10.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET / HTTP/1.1" 200 1`
+	long := `10.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET /` + strings.Repeat("x", 2048) + ` HTTP/1.1" 200 1`
+	result, err := processor.ProcessReader(strings.NewReader(line + "\n" + long + "\n" + line + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalRequests != 2 || processor.ErrorSummary().Errors != 1 {
+		t.Errorf("%d requests, %d errors; want 2 and 1", result.TotalRequests, processor.ErrorSummary().Errors)
+	}
+}
+
+func TestPeekLines(t *testing.T) {
+	br, err := decompressReader(strings.NewReader("a\r\nb\nccccccccccccccccccccccccccccccc\nd\n"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 样本最多为一个缓冲区，不完整的最后一行不算
+	if got := peekLines(br, 10); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("peekLines = %q, want [a b]", got)
+	}
+	if got := peekLines(br, 1); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("peekLines(1) = %q, want [a]", got)
+	}
+	// 不消耗数据
+	reader := newLineReader(br, 0)
+	if line, err := reader.next(); err != nil || string(line) != "a" {
+		t.Errorf("first line after peeking = %q, %v", line, err)
+	}
+}
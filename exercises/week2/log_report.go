@@ -0,0 +1,688 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+分析报告的输出格式：
+
+	text  对齐的文本表格（默认）
+	json  结构化数据，字段名为 snake_case，耗时单位为毫秒
+	csv   section,key,metric,value 四列的长表格，便于导入表格软件
+	html  单文件报告，图表为内联 SVG，不依赖外部资源
+
+所有格式共用 reportData，保证内容一致。
+*/
+
+type ReportRenderer interface {
+	Render(w io.Writer, r *AnalysisResult) error
+}
+
+// 支持的输出格式
+var reportFormats = []string{"text", "json", "csv", "html"}
+
+// 按名称创建渲染器，topN 为热门/最慢 URL 的数量
+func NewRenderer(format string, topN int) (ReportRenderer, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return &TextRenderer{TopN: topN}, nil
+	case "json":
+		return &JSONRenderer{TopN: topN, Indent: true}, nil
+	case "csv":
+		return &CSVRenderer{TopN: topN}, nil
+	case "html":
+		return &HTMLRenderer{TopN: topN}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want %s)", format, strings.Join(reportFormats, ", "))
+}
+
+// 输出格式的命令行参数，实现 flag.Value
+type ReportFormat string
+
+func (f *ReportFormat) String() string {
+	if *f == "" {
+		return "text"
+	}
+	return string(*f)
+}
+
+func (f *ReportFormat) Set(value string) error {
+	if _, err := NewRenderer(value, 0); err != nil {
+		return err
+	}
+	*f = ReportFormat(strings.ToLower(value))
+	return nil
+}
+
+// ---------- 报告数据 ----------
+
+const defaultReportTopN = 10
+
+type latencyReport struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func newLatencyReport(s LatencySummary) latencyReport {
+	return latencyReport{
+		Count:  s.Count,
+		MeanMs: durationMs(s.Mean),
+		P50Ms:  durationMs(s.P50),
+		P90Ms:  durationMs(s.P90),
+		P99Ms:  durationMs(s.P99),
+		MaxMs:  durationMs(s.Max),
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type urlLatencyReport struct {
+	URL string `json:"url"`
+	latencyReport
+}
+
+type statusCount struct {
+	Status int   `json:"status"`
+	Count  int64 `json:"count"`
+}
+
+type methodCount struct {
+	Method string `json:"method"`
+	Count  int64  `json:"count"`
+}
+
+type statusLatencyReport struct {
+	Status int `json:"status"`
+	latencyReport
+}
+
+// 自动识别格式时每种格式的行数，Percent 为占所有行的百分比
+type formatCount struct {
+	Format  string  `json:"format"`
+	Lines   int64   `json:"lines"`
+	Percent float64 `json:"percent"`
+}
+
+type journeyCount struct {
+	Pages []string `json:"pages"`
+	Count int64    `json:"count"`
+}
+
+// 会话时长以秒为单位
+type sessionReport struct {
+	Sessions        int64          `json:"sessions"`
+	PageViews       int64          `json:"page_views"`
+	PagesPerSession float64        `json:"pages_per_session"`
+	BounceRate      float64        `json:"bounce_rate"`
+	MeanDurationSec float64        `json:"mean_duration_sec"`
+	P50DurationSec  float64        `json:"p50_duration_sec"`
+	P90DurationSec  float64        `json:"p90_duration_sec"`
+	MaxDurationSec  float64        `json:"max_duration_sec"`
+	EntryPages      []URLCount     `json:"entry_pages"`
+	ExitPages       []URLCount     `json:"exit_pages"`
+	Journeys        []journeyCount `json:"journeys"`
+}
+
+func newSessionReport(s *SessionStats, topN int) *sessionReport {
+	duration := s.Duration.Summary()
+	report := &sessionReport{
+		Sessions:        s.Sessions,
+		PageViews:       s.PageViews,
+		PagesPerSession: s.PagesPerSession(),
+		BounceRate:      s.BounceRate(),
+		MeanDurationSec: duration.Mean.Seconds(),
+		P50DurationSec:  duration.P50.Seconds(),
+		P90DurationSec:  duration.P90.Seconds(),
+		MaxDurationSec:  duration.Max.Seconds(),
+		EntryPages:      s.TopEntryPages(topN),
+		ExitPages:       s.TopExitPages(topN),
+	}
+	for _, j := range s.TopJourneys(topN) {
+		report.Journeys = append(report.Journeys, journeyCount{Pages: strings.Split(j.URL, journeySeparator), Count: j.Count})
+	}
+	return report
+}
+
+type reportData struct {
+	TotalRequests  int64                 `json:"total_requests"`
+	ErrorCount     int64                 `json:"error_count"`
+	ErrorRate      float64               `json:"error_rate"`
+	TotalBytes     int64                 `json:"total_bytes"`
+	AverageSize    float64               `json:"average_size"`
+	UniqueVisitors int64                 `json:"unique_visitors"`
+	Approximate    bool                  `json:"approximate"` // 热门URL和独立访客为估计值
+	FirstSeen      *time.Time            `json:"first_seen,omitempty"`
+	LastSeen       *time.Time            `json:"last_seen,omitempty"`
+	StatusCodes    []statusCount         `json:"status_codes"`
+	Methods        []methodCount         `json:"methods"`
+	HourlyRequests [24]int64             `json:"hourly_requests"`
+	TopURLs        []URLCount            `json:"top_urls"`
+	Latency        *latencyReport        `json:"latency,omitempty"`
+	StatusLatency  []statusLatencyReport `json:"status_latency,omitempty"`
+	SlowestURLs    []urlLatencyReport    `json:"slowest_urls,omitempty"`
+	Sessions       *sessionReport        `json:"sessions,omitempty"`
+	Formats        []formatCount         `json:"formats,omitempty"`
+}
+
+func newReportData(r *AnalysisResult, topN int) *reportData {
+	if topN <= 0 {
+		topN = defaultReportTopN
+	}
+	d := &reportData{
+		TotalRequests:  r.TotalRequests,
+		ErrorCount:     r.ErrorCount,
+		ErrorRate:      r.ErrorRate(),
+		TotalBytes:     r.TotalBytes,
+		AverageSize:    r.AverageSize(),
+		UniqueVisitors: r.UniqueVisitors(),
+		Approximate:    r.URLSketch != nil || r.Visitors != nil,
+		HourlyRequests: r.HourlyRequests,
+		TopURLs:        r.TopURLs(topN),
+	}
+	if !r.FirstSeen.IsZero() {
+		first, last := r.FirstSeen, r.LastSeen
+		d.FirstSeen, d.LastSeen = &first, &last
+	}
+
+	for code, n := range r.StatusCodes {
+		d.StatusCodes = append(d.StatusCodes, statusCount{Status: code, Count: n})
+	}
+	sort.Slice(d.StatusCodes, func(i, j int) bool { return d.StatusCodes[i].Status < d.StatusCodes[j].Status })
+
+	for method, n := range r.Methods {
+		d.Methods = append(d.Methods, methodCount{Method: method, Count: n})
+	}
+	sort.Slice(d.Methods, func(i, j int) bool {
+		if d.Methods[i].Count != d.Methods[j].Count {
+			return d.Methods[i].Count > d.Methods[j].Count
+		}
+		return d.Methods[i].Method < d.Methods[j].Method
+	})
+
+	if r.Latency != nil {
+		overall := newLatencyReport(r.Latency.Summary())
+		d.Latency = &overall
+	}
+	for code, s := range r.StatusLatency {
+		d.StatusLatency = append(d.StatusLatency, statusLatencyReport{Status: code, latencyReport: newLatencyReport(s.Summary())})
+	}
+	sort.Slice(d.StatusLatency, func(i, j int) bool { return d.StatusLatency[i].Status < d.StatusLatency[j].Status })
+	for _, u := range r.SlowestURLs(topN) {
+		d.SlowestURLs = append(d.SlowestURLs, urlLatencyReport{URL: u.URL, latencyReport: newLatencyReport(u.LatencySummary)})
+	}
+	if r.Sessions != nil {
+		d.Sessions = newSessionReport(r.Sessions, topN)
+	}
+	d.Formats = newFormatCounts(r.Formats)
+	return d
+}
+
+// 按行数从高到低排列，无法解析的行放在最后
+func newFormatCounts(formats map[string]int64) []formatCount {
+	var total int64
+	for _, n := range formats {
+		total += n
+	}
+	var counts []formatCount
+	for format, n := range formats {
+		counts = append(counts, formatCount{Format: format, Lines: n, Percent: percent(n, total)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if (a.Format == unmatchedFormat) != (b.Format == unmatchedFormat) {
+			return b.Format == unmatchedFormat
+		}
+		if a.Lines != b.Lines {
+			return a.Lines > b.Lines
+		}
+		return a.Format < b.Format
+	})
+	return counts
+}
+
+// ---------- 文本 ----------
+
+type TextRenderer struct {
+	TopN int
+}
+
+func (t *TextRenderer) Render(w io.Writer, r *AnalysisResult) error {
+	d := newReportData(r, t.TopN)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "== 概览 ==")
+	fmt.Fprintf(tw, "总请求数\t%d\n", d.TotalRequests)
+	fmt.Fprintf(tw, "错误数 (>=400)\t%d\t%.2f%%\n", d.ErrorCount, d.ErrorRate*100)
+	fmt.Fprintf(tw, "总字节数\t%d\t平均 %.1f\n", d.TotalBytes, d.AverageSize)
+	visitors := strconv.FormatInt(d.UniqueVisitors, 10)
+	if d.Approximate {
+		visitors = "≈" + visitors
+	}
+	fmt.Fprintf(tw, "独立访客\t%s\n", visitors)
+	if d.FirstSeen != nil {
+		fmt.Fprintf(tw, "时间范围\t%s ~ %s\n", d.FirstSeen.Format(time.RFC3339), d.LastSeen.Format(time.RFC3339))
+	}
+
+	if len(d.Formats) > 0 {
+		fmt.Fprintln(tw, "\n== 日志格式 ==")
+		for _, f := range d.Formats {
+			fmt.Fprintf(tw, "%s\t%d\t%.2f%%\n", f.Format, f.Lines, f.Percent)
+		}
+	}
+
+	fmt.Fprintln(tw, "\n== 状态码 ==")
+	for _, s := range d.StatusCodes {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f%%\n", s.Status, s.Count, percent(s.Count, d.TotalRequests))
+	}
+
+	fmt.Fprintln(tw, "\n== 请求方法 ==")
+	for _, m := range d.Methods {
+		fmt.Fprintf(tw, "%s\t%d\n", m.Method, m.Count)
+	}
+
+	fmt.Fprintf(tw, "\n== 热门URL (前%d) ==\n", len(d.TopURLs))
+	for i, u := range d.TopURLs {
+		fmt.Fprintf(tw, "%d.\t%s\t%d\n", i+1, u.URL, u.Count)
+	}
+
+	fmt.Fprintln(tw, "\n== 按小时 ==")
+	var peak int64
+	for _, n := range d.HourlyRequests {
+		if n > peak {
+			peak = n
+		}
+	}
+	for hour, n := range d.HourlyRequests {
+		bar := ""
+		if peak > 0 {
+			bar = strings.Repeat("#", int(n*40/peak))
+		}
+		fmt.Fprintf(tw, "%02d:00\t%d\t%s\n", hour, n, bar)
+	}
+
+	if d.Latency != nil {
+		fmt.Fprintln(tw, "\n== 响应耗时 (ms) ==")
+		fmt.Fprintln(tw, "\t请求数\t平均\tp50\tp90\tp99\t最大")
+		writeLatencyRow(tw, "全部", *d.Latency)
+		for _, s := range d.StatusLatency {
+			writeLatencyRow(tw, strconv.Itoa(s.Status), s.latencyReport)
+		}
+		if len(d.SlowestURLs) > 0 {
+			fmt.Fprintln(tw, "\n== 最慢的URL (按p99) ==")
+			fmt.Fprintln(tw, "\t请求数\t平均\tp50\tp90\tp99\t最大")
+			for _, u := range d.SlowestURLs {
+				writeLatencyRow(tw, u.URL, u.latencyReport)
+			}
+		}
+	}
+
+	if s := d.Sessions; s != nil {
+		fmt.Fprintln(tw, "\n== 会话 ==")
+		fmt.Fprintf(tw, "会话数\t%d\t平均 %.1f 页\n", s.Sessions, s.PagesPerSession)
+		fmt.Fprintf(tw, "跳出率\t%.2f%%\n", s.BounceRate*100)
+		fmt.Fprintf(tw, "时长 (秒)\t平均 %.0f\tp50 %.0f\tp90 %.0f\t最大 %.0f\n",
+			s.MeanDurationSec, s.P50DurationSec, s.P90DurationSec, s.MaxDurationSec)
+		fmt.Fprintln(tw, "\n== 入口页 ==")
+		for i, u := range s.EntryPages {
+			fmt.Fprintf(tw, "%d.\t%s\t%d\n", i+1, u.URL, u.Count)
+		}
+		fmt.Fprintln(tw, "\n== 出口页 ==")
+		for i, u := range s.ExitPages {
+			fmt.Fprintf(tw, "%d.\t%s\t%d\n", i+1, u.URL, u.Count)
+		}
+		fmt.Fprintln(tw, "\n== 常见访问路径 ==")
+		for i, j := range s.Journeys {
+			fmt.Fprintf(tw, "%d.\t%s\t%d\n", i+1, strings.Join(j.Pages, journeySeparator), j.Count)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeLatencyRow(w io.Writer, name string, l latencyReport) {
+	fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", name, l.Count, l.MeanMs, l.P50Ms, l.P90Ms, l.P99Ms, l.MaxMs)
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// ---------- JSON ----------
+
+type JSONRenderer struct {
+	TopN   int
+	Indent bool
+}
+
+func (j *JSONRenderer) Render(w io.Writer, r *AnalysisResult) error {
+	encoder := json.NewEncoder(w)
+	if j.Indent {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(newReportData(r, j.TopN)); err != nil {
+		return fmt.Errorf("failed to write JSON report: %w", err)
+	}
+	return nil
+}
+
+// ---------- CSV ----------
+
+type CSVRenderer struct {
+	TopN int
+}
+
+func (c *CSVRenderer) Render(w io.Writer, r *AnalysisResult) error {
+	d := newReportData(r, c.TopN)
+	cw := csv.NewWriter(w)
+	row := func(section, key, metric string, value interface{}) {
+		cw.Write([]string{section, key, metric, fmt.Sprint(value)})
+	}
+
+	row("section", "key", "metric", "value")
+	row("summary", "", "total_requests", d.TotalRequests)
+	row("summary", "", "error_count", d.ErrorCount)
+	row("summary", "", "error_rate", strconv.FormatFloat(d.ErrorRate, 'f', 6, 64))
+	row("summary", "", "total_bytes", d.TotalBytes)
+	row("summary", "", "unique_visitors", d.UniqueVisitors)
+	row("summary", "", "approximate", d.Approximate)
+	if d.FirstSeen != nil {
+		row("summary", "", "first_seen", d.FirstSeen.Format(time.RFC3339))
+		row("summary", "", "last_seen", d.LastSeen.Format(time.RFC3339))
+	}
+	for _, f := range d.Formats {
+		row("format", f.Format, "lines", f.Lines)
+	}
+	for _, s := range d.StatusCodes {
+		row("status", strconv.Itoa(s.Status), "count", s.Count)
+	}
+	for _, m := range d.Methods {
+		row("method", m.Method, "count", m.Count)
+	}
+	for hour, n := range d.HourlyRequests {
+		row("hour", fmt.Sprintf("%02d", hour), "count", n)
+	}
+	for _, u := range d.TopURLs {
+		row("top_url", u.URL, "count", u.Count)
+	}
+	latencyRows := func(section, key string, l latencyReport) {
+		row(section, key, "count", l.Count)
+		row(section, key, "mean_ms", l.MeanMs)
+		row(section, key, "p50_ms", l.P50Ms)
+		row(section, key, "p90_ms", l.P90Ms)
+		row(section, key, "p99_ms", l.P99Ms)
+		row(section, key, "max_ms", l.MaxMs)
+	}
+	if d.Latency != nil {
+		latencyRows("latency", "", *d.Latency)
+	}
+	for _, s := range d.StatusLatency {
+		latencyRows("status_latency", strconv.Itoa(s.Status), s.latencyReport)
+	}
+	for _, u := range d.SlowestURLs {
+		latencyRows("url_latency", u.URL, u.latencyReport)
+	}
+	if s := d.Sessions; s != nil {
+		row("sessions", "", "sessions", s.Sessions)
+		row("sessions", "", "page_views", s.PageViews)
+		row("sessions", "", "pages_per_session", strconv.FormatFloat(s.PagesPerSession, 'f', 3, 64))
+		row("sessions", "", "bounce_rate", strconv.FormatFloat(s.BounceRate, 'f', 6, 64))
+		row("sessions", "", "mean_duration_sec", s.MeanDurationSec)
+		row("sessions", "", "p50_duration_sec", s.P50DurationSec)
+		row("sessions", "", "p90_duration_sec", s.P90DurationSec)
+		row("sessions", "", "max_duration_sec", s.MaxDurationSec)
+		for _, u := range s.EntryPages {
+			row("entry_page", u.URL, "count", u.Count)
+		}
+		for _, u := range s.ExitPages {
+			row("exit_page", u.URL, "count", u.Count)
+		}
+		for _, j := range s.Journeys {
+			row("journey", strings.Join(j.Pages, journeySeparator), "count", j.Count)
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV report: %w", err)
+	}
+	return nil
+}
+
+// ---------- HTML ----------
+
+type HTMLRenderer struct {
+	TopN  int
+	Title string // 为空时使用 "访问日志分析报告"
+}
+
+// SVG 柱状图中的一根柱子
+type svgBar struct {
+	X, Y, Width, Height float64
+	Label               string
+	Value               int64
+	Color               string
+}
+
+type svgChart struct {
+	Width, Height float64
+	Bars          []svgBar
+}
+
+const (
+	chartWidth  = 720.0
+	chartHeight = 220.0
+	chartBottom = 20.0 // 底部标签的高度
+)
+
+// 纵向柱状图
+func columnChart(labels []string, values []int64, color func(i int) string) svgChart {
+	chart := svgChart{Width: chartWidth, Height: chartHeight}
+	if len(values) == 0 {
+		return chart
+	}
+	var peak int64 = 1
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+	slot := chartWidth / float64(len(values))
+	for i, v := range values {
+		h := float64(v) / float64(peak) * (chartHeight - chartBottom - 14)
+		chart.Bars = append(chart.Bars, svgBar{
+			X:      float64(i)*slot + slot*0.1,
+			Y:      chartHeight - chartBottom - h,
+			Width:  slot * 0.8,
+			Height: h,
+			Label:  labels[i],
+			Value:  v,
+			Color:  color(i),
+		})
+	}
+	return chart
+}
+
+func statusColor(code int) string {
+	switch {
+	case code >= 500:
+		return "#d9534f"
+	case code >= 400:
+		return "#f0ad4e"
+	case code >= 300:
+		return "#5bc0de"
+	}
+	return "#5cb85c"
+}
+
+type htmlReport struct {
+	Title       string
+	Generated   string
+	Data        *reportData
+	Hourly      svgChart
+	Status      svgChart
+	URLMax      int64
+	ErrorRatePc float64
+}
+
+func (h *HTMLRenderer) Render(w io.Writer, r *AnalysisResult) error {
+	d := newReportData(r, h.TopN)
+	report := htmlReport{
+		Title:       h.Title,
+		Generated:   time.Now().Format(time.RFC3339),
+		Data:        d,
+		ErrorRatePc: d.ErrorRate * 100,
+	}
+	if report.Title == "" {
+		report.Title = "访问日志分析报告"
+	}
+
+	hours := make([]string, 24)
+	for i := range hours {
+		hours[i] = fmt.Sprintf("%02d", i)
+	}
+	report.Hourly = columnChart(hours, d.HourlyRequests[:], func(int) string { return "#337ab7" })
+
+	codes := make([]string, len(d.StatusCodes))
+	counts := make([]int64, len(d.StatusCodes))
+	for i, s := range d.StatusCodes {
+		codes[i], counts[i] = strconv.Itoa(s.Status), s.Count
+	}
+	report.Status = columnChart(codes, counts, func(i int) string { return statusColor(d.StatusCodes[i].Status) })
+
+	for _, u := range d.TopURLs {
+		if u.Count > report.URLMax {
+			report.URLMax = u.Count
+		}
+	}
+
+	if err := htmlReportTemplate.Execute(w, report); err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	return nil
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct":       func(n, total int64) float64 { return percent(n, total) },
+	"add":       func(a, b float64) float64 { return a + b },
+	"half":      func(a float64) float64 { return a / 2 },
+	"percentOf": func(ratio float64) float64 { return ratio * 100 },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", sans-serif; margin: 2em auto; max-width: 760px; color: #333; }
+h1 { font-size: 1.6em; } h2 { font-size: 1.2em; border-bottom: 1px solid #ddd; padding-bottom: .3em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; } td, th { padding: .3em .6em; text-align: left; border-bottom: 1px solid #eee; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; } .card { flex: 1; min-width: 140px; background: #f7f7f9; padding: .8em; border-radius: 6px; }
+.card b { display: block; font-size: 1.4em; } .muted { color: #888; font-size: .9em; }
+svg text { font-size: 10px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">生成时间 {{.Generated}}{{with .Data.FirstSeen}}，日志时间 {{.Format "2006-01-02 15:04:05"}} ~ {{$.Data.LastSeen.Format "2006-01-02 15:04:05"}}{{end}}</p>
+
+<div class="cards">
+<div class="card">总请求数<b>{{.Data.TotalRequests}}</b></div>
+<div class="card">错误率<b>{{printf "%.2f" .ErrorRatePc}}%</b><span class="muted">{{.Data.ErrorCount}} 个错误</span></div>
+<div class="card">独立访客<b>{{if .Data.Approximate}}≈{{end}}{{.Data.UniqueVisitors}}</b></div>
+<div class="card">平均响应<b>{{printf "%.0f" .Data.AverageSize}} B</b></div>
+{{with .Data.Latency}}<div class="card">p99 耗时<b>{{printf "%.1f" .P99Ms}} ms</b><span class="muted">p50 {{printf "%.1f" .P50Ms}} ms</span></div>{{end}}
+</div>
+
+{{if .Data.Formats}}
+<h2>日志格式</h2>
+<table>
+<tr><th>格式</th><th class="num">行数</th><th class="num">占比</th></tr>
+{{range .Data.Formats}}<tr><td>{{.Format}}</td><td class="num">{{.Lines}}</td><td class="num">{{printf "%.2f" .Percent}}%</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>按小时的请求数</h2>
+{{template "chart" .Hourly}}
+
+<h2>状态码</h2>
+{{template "chart" .Status}}
+<table>
+<tr><th>状态码</th><th class="num">请求数</th><th class="num">占比</th></tr>
+{{range .Data.StatusCodes}}<tr><td>{{.Status}}</td><td class="num">{{.Count}}</td><td class="num">{{printf "%.2f" (pct .Count $.Data.TotalRequests)}}%</td></tr>
+{{end}}</table>
+
+<h2>热门URL{{if .Data.Approximate}}（估计值）{{end}}</h2>
+<table>
+<tr><th>URL</th><th class="num">请求数</th><th></th></tr>
+{{range .Data.TopURLs}}<tr><td>{{.URL}}</td><td class="num">{{.Count}}</td>
+<td width="200"><svg width="200" height="12"><rect width="{{printf "%.1f" (pct .Count $.URLMax)}}%" height="12" fill="#337ab7"/></svg></td></tr>
+{{end}}</table>
+
+<h2>请求方法</h2>
+<table>
+{{range .Data.Methods}}<tr><td>{{.Method}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+
+{{if .Data.Latency}}
+<h2>响应耗时（毫秒）</h2>
+<table>
+<tr><th></th><th class="num">请求数</th><th class="num">平均</th><th class="num">p50</th><th class="num">p90</th><th class="num">p99</th><th class="num">最大</th></tr>
+{{with .Data.Latency}}<tr><td>全部</td>{{template "latency" .}}</tr>{{end}}
+{{range .Data.StatusLatency}}<tr><td>{{.Status}}</td>{{template "latency" .}}</tr>
+{{end}}</table>
+{{if .Data.SlowestURLs}}
+<h2>最慢的URL（按 p99）</h2>
+<table>
+<tr><th>URL</th><th class="num">请求数</th><th class="num">平均</th><th class="num">p50</th><th class="num">p90</th><th class="num">p99</th><th class="num">最大</th></tr>
+{{range .Data.SlowestURLs}}<tr><td>{{.URL}}</td>{{template "latency" .}}</tr>
+{{end}}</table>
+{{end}}
+{{end}}
+{{with .Data.Sessions}}
+<h2>会话</h2>
+<div class="cards">
+<div class="card">会话数<b>{{.Sessions}}</b><span class="muted">平均 {{printf "%.1f" .PagesPerSession}} 页</span></div>
+<div class="card">跳出率<b>{{printf "%.1f" (percentOf .BounceRate)}}%</b></div>
+<div class="card">平均时长<b>{{printf "%.0f" .MeanDurationSec}} s</b><span class="muted">p90 {{printf "%.0f" .P90DurationSec}} s</span></div>
+</div>
+<table>
+<tr><th>入口页</th><th class="num">会话数</th></tr>
+{{range .EntryPages}}<tr><td>{{.URL}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>出口页</th><th class="num">会话数</th></tr>
+{{range .ExitPages}}<tr><td>{{.URL}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>常见访问路径</th><th class="num">会话数</th></tr>
+{{range .Journeys}}<tr><td>{{range $i, $p := .Pages}}{{if $i}} → {{end}}{{$p}}{{end}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+{{define "latency"}}<td class="num">{{.Count}}</td><td class="num">{{printf "%.1f" .MeanMs}}</td><td class="num">{{printf "%.1f" .P50Ms}}</td><td class="num">{{printf "%.1f" .P90Ms}}</td><td class="num">{{printf "%.1f" .P99Ms}}</td><td class="num">{{printf "%.1f" .MaxMs}}</td>{{end}}
+{{define "chart"}}<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img">
+{{range .Bars}}<g><title>{{.Label}}: {{.Value}}</title>
+<rect x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" width="{{printf "%.1f" .Width}}" height="{{printf "%.1f" .Height}}" fill="{{.Color}}"/>
+<text x="{{printf "%.1f" (add .X (half .Width))}}" y="{{printf "%.1f" (add $.Height -6)}}" text-anchor="middle">{{.Label}}</text>
+</g>
+{{end}}</svg>{{end}}
+`))
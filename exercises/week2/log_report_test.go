@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 报告中来自日志的字符串可能包含 HTML
+const (
+	hostileURL   = `/search/<script>alert("x")</script>&a=1`
+	hostileAgent = `Mozilla/5.0 <img src=x onerror=alert(1)>`
+)
+
+// 5个请求、3个会话，覆盖报告的所有部分
+func reportFixture(t *testing.T) *AnalysisResult {
+	t.Helper()
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	entry := func(offset time.Duration, ip, agent, method, url string, status int, size int64, latency time.Duration) *LogEntry {
+		return &LogEntry{
+			Timestamp: start.Add(offset), IP: ip, UserAgent: agent, Method: method, URL: url, Protocol: "HTTP/1.1",
+			StatusCode: status, Size: size, ResponseTime: latency, HasResponseTime: true,
+		}
+	}
+	entries := []*LogEntry{
+		entry(0, "10.0.0.1", hostileAgent, "GET", "/index.html", 200, 1000, 100*time.Millisecond),
+		entry(time.Minute, "10.0.0.1", hostileAgent, "GET", hostileURL, 200, 500, 300*time.Millisecond),
+		entry(time.Hour, "10.0.0.2", "curl/8.4.0", "POST", "/api/orders", 503, 0, 2*time.Second),
+		entry(time.Hour+time.Minute, "10.0.0.2", "curl/8.4.0", "GET", `/a,b "quoted"`, 404, 0, 50*time.Millisecond),
+		entry(2*time.Hour, "10.0.0.3", "curl/8.4.0", "GET", "/index.html", 200, 1000, 80*time.Millisecond),
+	}
+
+	analyzer := NewSessionAnalyzer(NewBasicAnalyzer(), SessionConfig{})
+	result := analyzer.Analyze(entries)
+	analyzer.Flush()
+	result.Formats = map[string]int64{"combined": 5, unmatchedFormat: 1}
+	return result
+}
+
+func renderReport(t *testing.T, format string, r *AnalysisResult) string {
+	t.Helper()
+	renderer, err := NewRenderer(format, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := renderer.Render(&out, r); err != nil {
+		t.Fatalf("render %s: %v", format, err)
+	}
+	return out.String()
+}
+
+func TestNewRenderer(t *testing.T) {
+	tests := []struct {
+		format string
+		want   ReportRenderer
+	}{
+		{"", &TextRenderer{TopN: 5}},
+		{"text", &TextRenderer{TopN: 5}},
+		{"JSON", &JSONRenderer{TopN: 5, Indent: true}},
+		{"csv", &CSVRenderer{TopN: 5}},
+		{"html", &HTMLRenderer{TopN: 5}},
+	}
+	for _, tt := range tests {
+		got, err := NewRenderer(tt.format, 5)
+		if err != nil {
+			t.Errorf("NewRenderer(%q): %v", tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NewRenderer(%q) = %#v, want %#v", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := NewRenderer("xml", 5); err == nil || !strings.Contains(err.Error(), "text, json, csv, html") {
+		t.Errorf("got error %v for an unknown format, want the list of formats", err)
+	}
+
+	var f ReportFormat
+	if f.String() != "text" {
+		t.Errorf("default format = %q, want text", f.String())
+	}
+	if err := f.Set("HTML"); err != nil || f.String() != "html" {
+		t.Errorf("Set(HTML) = %v, format %q, want html", err, f.String())
+	}
+	if err := f.Set("xml"); err == nil || f.String() != "html" {
+		t.Errorf("Set(xml) = %v, format %q, want an error and html unchanged", err, f.String())
+	}
+}
+
+// 按空白切分后与 fields 完全相同的行
+func hasTextRow(text string, fields ...string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if reflect.DeepEqual(strings.Fields(line), fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTextReport(t *testing.T) {
+	text := renderReport(t, "text", reportFixture(t))
+
+	// 各部分按顺序出现
+	last := -1
+	for _, section := range []string{"== 概览 ==", "== 日志格式 ==", "== 状态码 ==", "== 请求方法 ==", "== 热门URL (前4) ==",
+		"== 按小时 ==", "== 响应耗时 (ms) ==", "== 最慢的URL (按p99) ==", "== 会话 ==", "== 入口页 ==", "== 出口页 ==", "== 常见访问路径 =="} {
+		i := strings.Index(text, section)
+		if i < 0 {
+			t.Errorf("text report has no section %q", section)
+			continue
+		}
+		if i < last {
+			t.Errorf("section %q is out of order", section)
+		}
+		last = i
+	}
+
+	rows := [][]string{
+		{"总请求数", "5"},
+		{"错误数", "(>=400)", "2", "40.00%"},
+		{"总字节数", "2500", "平均", "500.0"},
+		{"独立访客", "3"},
+		{"时间范围", "2023-12-25T10:00:00Z", "~", "2023-12-25T12:00:00Z"},
+		{"combined", "5", "83.33%"},
+		{"unmatched", "1", "16.67%"},
+		{"200", "3", "60.00%"},
+		{"503", "1", "20.00%"},
+		{"GET", "4"},
+		{"1.", "/index.html", "2"},
+		{"10:00", "2", strings.Repeat("#", 40)},
+		{"13:00", "0"},
+		{"503", "1", "2000.0", "2000.0", "2000.0", "2000.0", "2000.0"},
+		{"会话数", "3", "平均", "1.7", "页"},
+		{"跳出率", "33.33%"},
+	}
+	for _, row := range rows {
+		if !hasTextRow(text, row...) {
+			t.Errorf("text report has no row %q:\n%s", row, text)
+		}
+	}
+	// 文本报告原样输出 URL
+	if !strings.Contains(text, hostileURL) {
+		t.Errorf("text report does not contain %q", hostileURL)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	data := renderReport(t, "json", reportFixture(t))
+	// encoding/json 默认转义 < > &
+	if strings.Contains(data, "<script>") {
+		t.Errorf("JSON report contains unescaped HTML:\n%s", data)
+	}
+
+	var report struct {
+		TotalRequests int64              `json:"total_requests"`
+		ErrorRate     float64            `json:"error_rate"`
+		FirstSeen     time.Time          `json:"first_seen"`
+		StatusCodes   []statusCount      `json:"status_codes"`
+		Methods       []methodCount      `json:"methods"`
+		TopURLs       []URLCount         `json:"top_urls"`
+		Latency       map[string]float64 `json:"latency"`
+		SlowestURLs   []struct {
+			URL   string  `json:"url"`
+			MaxMs float64 `json:"max_ms"`
+		} `json:"slowest_urls"`
+		Sessions struct {
+			Sessions   int64          `json:"sessions"`
+			BounceRate float64        `json:"bounce_rate"`
+			Journeys   []journeyCount `json:"journeys"`
+		} `json:"sessions"`
+		Formats []formatCount `json:"formats"`
+	}
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		t.Fatalf("invalid JSON report: %v\n%s", err, data)
+	}
+
+	if report.TotalRequests != 5 || report.ErrorRate != 0.4 {
+		t.Errorf("got total %d, error rate %v, want 5, 0.4", report.TotalRequests, report.ErrorRate)
+	}
+	if want := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC); !report.FirstSeen.Equal(want) {
+		t.Errorf("got first_seen %v, want %v", report.FirstSeen, want)
+	}
+	wantStatus := []statusCount{{200, 3}, {404, 1}, {503, 1}}
+	if !reflect.DeepEqual(report.StatusCodes, wantStatus) {
+		t.Errorf("got status_codes %+v, want %+v", report.StatusCodes, wantStatus)
+	}
+	wantMethods := []methodCount{{"GET", 4}, {"POST", 1}}
+	if !reflect.DeepEqual(report.Methods, wantMethods) {
+		t.Errorf("got methods %+v, want %+v", report.Methods, wantMethods)
+	}
+	if len(report.TopURLs) != 4 || report.TopURLs[0] != (URLCount{URL: "/index.html", Count: 2}) {
+		t.Errorf("got top_urls %+v, want 4 URLs led by /index.html", report.TopURLs)
+	}
+	for _, key := range []string{"count", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms"} {
+		if _, ok := report.Latency[key]; !ok {
+			t.Errorf("latency has no %q", key)
+		}
+	}
+	if report.Latency["max_ms"] != 2000 {
+		t.Errorf("got latency max_ms %v, want 2000", report.Latency["max_ms"])
+	}
+	if len(report.SlowestURLs) == 0 || report.SlowestURLs[0].URL != "/api/orders" || report.SlowestURLs[0].MaxMs != 2000 {
+		t.Errorf("got slowest_urls %+v, want /api/orders first", report.SlowestURLs)
+	}
+	if report.Sessions.Sessions != 3 || report.Sessions.BounceRate != 1.0/3 {
+		t.Errorf("got %d sessions, bounce rate %v, want 3, 1/3", report.Sessions.Sessions, report.Sessions.BounceRate)
+	}
+	journey := journeyCount{Pages: []string{"/index.html", hostileURL}, Count: 1}
+	found := false
+	for _, j := range report.Sessions.Journeys {
+		found = found || reflect.DeepEqual(j, journey)
+	}
+	if !found {
+		t.Errorf("got journeys %+v, want %+v among them", report.Sessions.Journeys, journey)
+	}
+	if len(report.Formats) != 2 || report.Formats[0].Format != "combined" || report.Formats[1].Format != unmatchedFormat {
+		t.Errorf("got formats %+v, want combined then unmatched", report.Formats)
+	}
+}
+
+func TestCSVReport(t *testing.T) {
+	data := renderReport(t, "csv", reportFixture(t))
+	// 每行都是四列，含逗号和引号的 URL 经过转义
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV report: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(records[0], []string{"section", "key", "metric", "value"}) {
+		t.Errorf("got header %q", records[0])
+	}
+	values := make(map[[3]string]string)
+	for _, record := range records[1:] {
+		values[[3]string{record[0], record[1], record[2]}] = record[3]
+	}
+
+	tests := []struct {
+		section, key, metric string
+		want                 string
+	}{
+		{"summary", "", "total_requests", "5"},
+		{"summary", "", "error_rate", "0.400000"},
+		{"summary", "", "approximate", "false"},
+		{"summary", "", "first_seen", "2023-12-25T10:00:00Z"},
+		{"format", "combined", "lines", "5"},
+		{"format", unmatchedFormat, "lines", "1"},
+		{"status", "503", "count", "1"},
+		{"method", "POST", "count", "1"},
+		{"hour", "10", "count", "2"},
+		{"hour", "23", "count", "0"},
+		{"top_url", `/a,b "quoted"`, "count", "1"},
+		{"top_url", hostileURL, "count", "1"},
+		{"latency", "", "max_ms", "2000"},
+		{"status_latency", "503", "count", "1"},
+		{"url_latency", "/api/orders", "max_ms", "2000"},
+		{"sessions", "", "sessions", "3"},
+		{"sessions", "", "bounce_rate", "0.333333"},
+		{"entry_page", "/index.html", "count", "2"},
+		{"exit_page", hostileURL, "count", "1"},
+		{"journey", "/index.html" + journeySeparator + hostileURL, "count", "1"},
+	}
+	for _, tt := range tests {
+		key := [3]string{tt.section, tt.key, tt.metric}
+		got, ok := values[key]
+		if !ok {
+			t.Errorf("CSV report has no row %q", key)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("row %q = %q, want %q", key, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLReport(t *testing.T) {
+	r := reportFixture(t)
+	var out strings.Builder
+	if err := (&HTMLRenderer{Title: "报告 <u>"}).Render(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+
+	// 来自日志的 URL 和 User-Agent 以及标题都不能成为标签或属性
+	for _, unsafe := range []string{"<script", "<img", "onerror", "<u>"} {
+		if strings.Contains(html, unsafe) {
+			t.Errorf("HTML report contains unescaped %q", unsafe)
+		}
+	}
+	if !strings.Contains(html, "<title>报告 &lt;u&gt;</title>") {
+		t.Error("HTML report title is not escaped")
+	}
+	// 热门URL、最慢的URL、出口页、访问路径各出现一次
+	escaped := "/search/&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;&amp;a=1"
+	if n := strings.Count(html, escaped); n != 4 {
+		t.Errorf("escaped URL appears %d times, want 4", n)
+	}
+	if !strings.Contains(html, "<td>/a,b &#34;quoted&#34;</td>") {
+		t.Error("HTML report does not escape quotes in URLs")
+	}
+
+	for _, heading := range []string{"日志格式", "按小时的请求数", "状态码", "热门URL", "请求方法", "响应耗时（毫秒）", "最慢的URL（按 p99）", "会话"} {
+		if !strings.Contains(html, "<h2>"+heading+"</h2>") {
+			t.Errorf("HTML report has no heading %q", heading)
+		}
+	}
+	if open, closed := strings.Count(html, "<table>"), strings.Count(html, "</table>"); open != closed || open == 0 {
+		t.Errorf("got %d <table> and %d </table>", open, closed)
+	}
+	// 24 个小时加 3 个状态码的柱子
+	if n := strings.Count(html, "<g><title>"); n != 27 {
+		t.Errorf("got %d chart bars, want 27", n)
+	}
+	if !strings.Contains(html, `fill="#d9534f"`) {
+		t.Error("5xx bar is not red")
+	}
+}
+
+// 空结果的各种格式都能输出，不包含可选部分
+func TestReportEmptyResult(t *testing.T) {
+	for _, format := range reportFormats {
+		out := renderReport(t, format, NewAnalysisResult())
+		for _, optional := range []string{"日志格式", "响应耗时", "会话", "latency", "sessions", "formats"} {
+			if strings.Contains(out, optional) {
+				t.Errorf("%s report of an empty result contains %q", format, optional)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+AnalysisResult 的统计与合并：
+
+结果只由计数组成，合并就是逐项相加（时间范围取并集），
+所以按文件分块、按协程分片或者按多个文件分别统计后再合并，
+得到的结果与一次性统计完全相同。
+*/
+
+func NewAnalysisResult() *AnalysisResult {
+	return &AnalysisResult{
+		StatusCodes: make(map[int]int64),
+		URLCounts:   make(map[string]int64),
+		IPCounts:    make(map[string]int64),
+		Methods:     make(map[string]int64),
+	}
+}
+
+// 使用近似统计的空结果
+func newSketchResult(config SketchConfig) *AnalysisResult {
+	config = config.withDefaults()
+	r := NewAnalysisResult()
+	r.URLSketch = NewTopKSketch(config.TopK, config.Epsilon, config.Delta)
+	r.Visitors = NewHyperLogLog(config.VisitorError)
+	return r
+}
+
+// 与 r 统计方式相同的空结果
+func (r *AnalysisResult) emptyCopy() *AnalysisResult {
+	empty := NewAnalysisResult()
+	if r.URLSketch != nil {
+		empty.URLSketch = r.URLSketch.emptyCopy()
+	}
+	if r.Visitors != nil {
+		empty.Visitors = r.Visitors.emptyCopy()
+	}
+	return empty
+}
+
+// 把一条日志计入结果
+func (r *AnalysisResult) Add(entry *LogEntry) {
+	r.TotalRequests++
+	r.StatusCodes[entry.StatusCode]++
+	if r.URLSketch != nil {
+		r.URLSketch.Add(entry.URL, 1)
+	} else {
+		r.URLCounts[entry.URL]++
+	}
+	if r.Visitors != nil {
+		r.Visitors.Add(entry.IP)
+	} else {
+		r.IPCounts[entry.IP]++
+	}
+	r.Methods[entry.Method]++
+	if entry.StatusCode >= 400 {
+		r.ErrorCount++
+	}
+	r.TotalBytes += entry.Size
+	if entry.HasResponseTime {
+		r.addLatency(entry)
+	}
+
+	// 宽松模式下时间可能缺失，不计入时间相关的统计
+	if entry.Timestamp.IsZero() {
+		return
+	}
+	r.HourlyRequests[entry.Timestamp.Hour()]++
+	r.observeTime(entry.Timestamp, entry.Timestamp)
+}
+
+func (r *AnalysisResult) observeTime(first, last time.Time) {
+	if first.IsZero() {
+		return
+	}
+	if r.FirstSeen.IsZero() || first.Before(r.FirstSeen) {
+		r.FirstSeen = first
+	}
+	if last.After(r.LastSeen) {
+		r.LastSeen = last
+	}
+}
+
+// 合并另一个结果，other 不会被修改
+func (r *AnalysisResult) Merge(other *AnalysisResult) {
+	if other == nil {
+		return
+	}
+	if r.StatusCodes == nil {
+		r.StatusCodes = make(map[int]int64, len(other.StatusCodes))
+	}
+	if r.URLCounts == nil {
+		r.URLCounts = make(map[string]int64, len(other.URLCounts))
+	}
+	if r.IPCounts == nil {
+		r.IPCounts = make(map[string]int64, len(other.IPCounts))
+	}
+	if r.Methods == nil {
+		r.Methods = make(map[string]int64, len(other.Methods))
+	}
+	r.mergeSketches(other)
+
+	r.TotalRequests += other.TotalRequests
+	r.ErrorCount += other.ErrorCount
+	r.TotalBytes += other.TotalBytes
+	for code, n := range other.StatusCodes {
+		r.StatusCodes[code] += n
+	}
+	for url, n := range other.URLCounts {
+		if r.URLSketch != nil {
+			r.URLSketch.Add(url, n)
+		} else {
+			r.URLCounts[url] += n
+		}
+	}
+	for ip, n := range other.IPCounts {
+		if r.Visitors != nil {
+			r.Visitors.Add(ip)
+		} else {
+			r.IPCounts[ip] += n
+		}
+	}
+	for method, n := range other.Methods {
+		r.Methods[method] += n
+	}
+	for hour, n := range other.HourlyRequests {
+		r.HourlyRequests[hour] += n
+	}
+	r.mergeLatency(other)
+	r.mergeSessions(other)
+	r.mergeFormats(other)
+	r.observeTime(other.FirstSeen, other.LastSeen)
+}
+
+// 只要有一方使用近似统计，合并结果就使用近似统计，精确计数并入草图
+func (r *AnalysisResult) mergeSketches(other *AnalysisResult) {
+	if other.URLSketch != nil {
+		if r.URLSketch == nil {
+			r.URLSketch = other.URLSketch.emptyCopy()
+			for url, n := range r.URLCounts {
+				r.URLSketch.Add(url, n)
+			}
+			r.URLCounts = make(map[string]int64)
+		}
+		if err := r.URLSketch.Merge(other.URLSketch); err != nil {
+			// 参数不同的 Count-Min 无法逐格相加，只能并入对方候选的估计值
+			for _, u := range other.URLSketch.Top(0) {
+				r.URLSketch.Add(u.URL, u.Count)
+			}
+		}
+	}
+	if other.Visitors != nil {
+		if r.Visitors == nil {
+			r.Visitors = other.Visitors.emptyCopy()
+			for ip := range r.IPCounts {
+				r.Visitors.Add(ip)
+			}
+			r.IPCounts = make(map[string]int64)
+		}
+		r.Visitors.Merge(other.Visitors)
+	}
+}
+
+// 深拷贝
+func (r *AnalysisResult) Clone() *AnalysisResult {
+	clone := r.emptyCopy()
+	clone.Merge(r)
+	return clone
+}
+
+// 独立访客（不同客户端IP）数量，使用近似统计时为估计值
+func (r *AnalysisResult) UniqueVisitors() int64 {
+	if r.Visitors != nil {
+		return r.Visitors.Estimate()
+	}
+	return int64(len(r.IPCounts))
+}
+
+// 错误率：状态码 >= 400 的请求占比
+func (r *AnalysisResult) ErrorRate() float64 {
+	if r.TotalRequests == 0 {
+		return 0
+	}
+	return float64(r.ErrorCount) / float64(r.TotalRequests)
+}
+
+// 平均响应大小（字节）
+func (r *AnalysisResult) AverageSize() float64 {
+	if r.TotalRequests == 0 {
+		return 0
+	}
+	return float64(r.TotalBytes) / float64(r.TotalRequests)
+}
+
+type URLCount struct {
+	URL   string `json:"url"`
+	Count int64  `json:"count"`
+}
+
+// 访问量最高的 n 个 URL，次数相同时按 URL 排序；n <= 0 返回全部。
+// 使用近似统计时最多返回 SketchConfig.TopK 个，次数为估计值
+func (r *AnalysisResult) TopURLs(n int) []URLCount {
+	if r.URLSketch != nil {
+		return r.URLSketch.Top(n)
+	}
+	return topCounts(r.URLCounts, n)
+}
+
+// 按次数从高到低取前 n 项，次数相同时按键排序；n <= 0 返回全部
+func topCounts(counts map[string]int64, n int) []URLCount {
+	urls := make([]URLCount, 0, len(counts))
+	for url, count := range counts {
+		urls = append(urls, URLCount{URL: url, Count: count})
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		if urls[i].Count != urls[j].Count {
+			return urls[i].Count > urls[j].Count
+		}
+		return urls[i].URL < urls[j].URL
+	})
+	if n > 0 && len(urls) > n {
+		urls = urls[:n]
+	}
+	return urls
+}
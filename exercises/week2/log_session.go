@@ -0,0 +1,281 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+访问会话还原：
+
+同一 IP 和 User-Agent 的请求属于同一个访客，相邻两次请求间隔超过
+Timeout（默认30分钟）时开始新的会话。每个会话记录：
+
+- 时长：第一个到最后一个请求的时间差，只有一个请求时为0
+- 页面：静态资源（图片、脚本、样式等）不算页面，URL 去掉查询参数
+- 入口页和出口页：第一个和最后一个页面
+- 访问路径：前 JourneyLength 个页面，连续刷新同一页面只算一次
+
+会话按日志自身的时间划分，时间前进时定期结束超时的会话；
+数据流结束时需要调用 Flush 结束剩余的会话，之前的统计只包含已结束的会话。
+与 AnomalyDetector 一样，它包装另一个分析器，不支持分片。
+*/
+
+type SessionConfig struct {
+	Timeout       time.Duration // 无活动超时，默认30分钟
+	JourneyLength int           // 访问路径统计的页面数，默认3
+	IncludeAssets bool          // 静态资源也算作页面
+}
+
+func (c SessionConfig) withDefaults() SessionConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Minute
+	}
+	if c.JourneyLength <= 0 {
+		c.JourneyLength = 3
+	}
+	return c
+}
+
+// 访问路径中页面之间的分隔符
+const journeySeparator = " → "
+
+// 不算作页面的扩展名
+var assetExtensions = map[string]bool{
+	".css": true, ".js": true, ".map": true, ".json": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".ico": true, ".webp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".eot": true,
+}
+
+// 已结束会话的统计
+type SessionStats struct {
+	Sessions   int64
+	Requests   int64
+	PageViews  int64
+	Bounces    int64            // 只浏览了一个页面的会话
+	Duration   *LatencySketch   // 会话时长分布
+	EntryPages map[string]int64 // 入口页
+	ExitPages  map[string]int64 // 出口页
+	Journeys   map[string]int64 // 访问路径，页面之间用 journeySeparator 连接
+}
+
+func NewSessionStats() *SessionStats {
+	return &SessionStats{
+		Duration:   NewLatencySketch(0),
+		EntryPages: make(map[string]int64),
+		ExitPages:  make(map[string]int64),
+		Journeys:   make(map[string]int64),
+	}
+}
+
+// 平均每个会话浏览的页面数
+func (s *SessionStats) PagesPerSession() float64 {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return float64(s.PageViews) / float64(s.Sessions)
+}
+
+// 跳出率：只浏览了一个页面的会话占比
+func (s *SessionStats) BounceRate() float64 {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return float64(s.Bounces) / float64(s.Sessions)
+}
+
+func (s *SessionStats) TopEntryPages(n int) []URLCount {
+	return topCounts(s.EntryPages, n)
+}
+
+func (s *SessionStats) TopExitPages(n int) []URLCount {
+	return topCounts(s.ExitPages, n)
+}
+
+// 最常见的访问路径，URL 字段为用 journeySeparator 连接的页面
+func (s *SessionStats) TopJourneys(n int) []URLCount {
+	return topCounts(s.Journeys, n)
+}
+
+// 合并另一份统计，other 不会被修改
+func (s *SessionStats) Merge(other *SessionStats) {
+	if other == nil {
+		return
+	}
+	s.Sessions += other.Sessions
+	s.Requests += other.Requests
+	s.PageViews += other.PageViews
+	s.Bounces += other.Bounces
+	s.Duration.Merge(other.Duration)
+	for page, n := range other.EntryPages {
+		s.EntryPages[page] += n
+	}
+	for page, n := range other.ExitPages {
+		s.ExitPages[page] += n
+	}
+	for journey, n := range other.Journeys {
+		s.Journeys[journey] += n
+	}
+}
+
+func (r *AnalysisResult) mergeSessions(other *AnalysisResult) {
+	if other.Sessions == nil {
+		return
+	}
+	if r.Sessions == nil {
+		r.Sessions = NewSessionStats()
+	}
+	r.Sessions.Merge(other.Sessions)
+}
+
+// ---------- 分析器 ----------
+
+// 进行中的会话
+type openSession struct {
+	start, last time.Time
+	requests    int64
+	pageViews   int64
+	entry, exit string
+	journey     []string
+}
+
+type SessionAnalyzer struct {
+	inner  LogAnalyzer
+	config SessionConfig
+
+	mu        sync.Mutex
+	open      map[string]*openSession // 键为 IP 和 User-Agent
+	clock     time.Time               // 见到的最晚的日志时间
+	nextSweep time.Time
+	stats     *SessionStats
+}
+
+func NewSessionAnalyzer(inner LogAnalyzer, config SessionConfig) *SessionAnalyzer {
+	return &SessionAnalyzer{
+		inner:  inner,
+		config: config.withDefaults(),
+		open:   make(map[string]*openSession),
+		stats:  NewSessionStats(),
+	}
+}
+
+// 返回内部分析器的结果，Sessions 字段指向会话统计，之后的调用会继续修改它
+func (a *SessionAnalyzer) Analyze(entries []*LogEntry) *AnalysisResult {
+	a.mu.Lock()
+	for _, entry := range entries {
+		a.observe(entry)
+	}
+	a.mu.Unlock()
+	result := a.inner.Analyze(entries)
+	result.Sessions = a.stats
+	return result
+}
+
+// 结束所有进行中的会话，在数据流结束时调用
+func (a *SessionAnalyzer) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, s := range a.open {
+		a.closeSession(s)
+		delete(a.open, key)
+	}
+}
+
+func (a *SessionAnalyzer) unwrap() LogAnalyzer {
+	return a.inner
+}
+
+// 进行中的会话数
+func (a *SessionAnalyzer) OpenSessions() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.open)
+}
+
+func (a *SessionAnalyzer) observe(entry *LogEntry) {
+	if entry.Timestamp.IsZero() {
+		return
+	}
+	if entry.Timestamp.After(a.clock) {
+		a.clock = entry.Timestamp
+		if !a.clock.Before(a.nextSweep) {
+			a.sweep()
+		}
+	}
+
+	key := entry.IP + "\x00" + entry.UserAgent
+	s := a.open[key]
+	if s != nil && entry.Timestamp.Sub(s.last) > a.config.Timeout {
+		a.closeSession(s)
+		s = nil
+	}
+	if s == nil {
+		s = &openSession{start: entry.Timestamp, last: entry.Timestamp}
+		a.open[key] = s
+	}
+
+	// 乱序的日志计入会话，但不改变会话的时间顺序
+	if entry.Timestamp.After(s.last) {
+		s.last = entry.Timestamp
+	}
+	if entry.Timestamp.Before(s.start) {
+		s.start = entry.Timestamp
+	}
+	s.requests++
+
+	page, ok := a.pageOf(entry.URL)
+	if !ok {
+		return
+	}
+	s.pageViews++
+	if s.entry == "" {
+		s.entry = page
+	}
+	s.exit = page
+	if len(s.journey) < a.config.JourneyLength && (len(s.journey) == 0 || s.journey[len(s.journey)-1] != page) {
+		s.journey = append(s.journey, page)
+	}
+}
+
+// 结束超时的会话。每前进半个超时时间扫描一次，会话最多晚半个超时时间结束
+func (a *SessionAnalyzer) sweep() {
+	for key, s := range a.open {
+		if a.clock.Sub(s.last) > a.config.Timeout {
+			a.closeSession(s)
+			delete(a.open, key)
+		}
+	}
+	a.nextSweep = a.clock.Add(a.config.Timeout / 2)
+}
+
+func (a *SessionAnalyzer) closeSession(s *openSession) {
+	stats := a.stats
+	stats.Sessions++
+	stats.Requests += s.requests
+	stats.PageViews += s.pageViews
+	stats.Duration.Add(s.last.Sub(s.start))
+	if s.pageViews == 1 {
+		stats.Bounces++
+	}
+	if s.pageViews > 0 {
+		stats.EntryPages[s.entry]++
+		stats.ExitPages[s.exit]++
+		stats.Journeys[strings.Join(s.journey, journeySeparator)]++
+	}
+}
+
+// 去掉查询参数后的页面路径，静态资源返回 false
+func (a *SessionAnalyzer) pageOf(url string) (string, bool) {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	if url == "" {
+		return "", false
+	}
+	if !a.config.IncludeAssets && assetExtensions[strings.ToLower(path.Ext(url))] {
+		return "", false
+	}
+	return url, true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 超时切分会话、静态资源、入口/出口页和访问路径
+func TestSessionAnalyzer(t *testing.T) {
+	base := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	const chrome, curl = "Mozilla/5.0 Chrome/120.0", "curl/8.4.0"
+	requests := []struct {
+		minute float64
+		ip     string
+		agent  string
+		url    string
+	}{
+		// 访客 A：首页 → 商品 → 购物车，中间夹着静态资源和一次刷新
+		{0, "10.0.0.1", chrome, "/"},
+		{0, "10.0.0.1", chrome, "/static/app.js"},
+		{1, "10.0.0.1", chrome, "/products?page=2"},
+		{1.5, "10.0.0.1", chrome, "/products"},
+		{4, "10.0.0.1", chrome, "/cart"},
+		// 同一 IP 的另一个客户端是另一个访客，只看了一页
+		{2, "10.0.0.1", curl, "/api/status"},
+		// 访客 B：两个会话，中间间隔超过30分钟
+		{3, "10.0.0.2", chrome, "/"},
+		{10, "10.0.0.2", chrome, "/products"},
+		{50, "10.0.0.2", chrome, "/blog/"},
+		// 时间前进后访客 A 的会话因超时结束，这一条开始新的会话
+		{90, "10.0.0.1", chrome, "/cart"},
+	}
+
+	analyzer := NewSessionAnalyzer(NewBasicAnalyzer(), SessionConfig{})
+	var result *AnalysisResult
+	for _, r := range requests {
+		result = analyzer.Analyze([]*LogEntry{{
+			Timestamp: base.Add(time.Duration(r.minute * float64(time.Minute))),
+			IP:        r.ip, UserAgent: r.agent, URL: r.url,
+			Method: "GET", StatusCode: 200,
+		}})
+	}
+
+	s := result.Sessions
+	if s == nil {
+		t.Fatal("result has no session stats")
+	}
+	if s.Sessions != 4 || analyzer.OpenSessions() != 1 {
+		t.Errorf("before Flush: %d closed, %d open; want 4 and 1", s.Sessions, analyzer.OpenSessions())
+	}
+	analyzer.Flush()
+	if s.Sessions != 5 || analyzer.OpenSessions() != 0 {
+		t.Errorf("after Flush: %d closed, %d open; want 5 and 0", s.Sessions, analyzer.OpenSessions())
+	}
+	if s.Requests != 10 || s.PageViews != 9 {
+		t.Errorf("%d requests, %d page views; want 10 and 9", s.Requests, s.PageViews)
+	}
+	if s.Bounces != 3 || s.BounceRate() != 0.6 {
+		t.Errorf("%d bounces, rate %v; want 3 and 0.6", s.Bounces, s.BounceRate())
+	}
+	if s.Duration.Max() != 7*time.Minute || s.Duration.Count() != 5 {
+		t.Errorf("duration max %v over %d sessions; want 7m over 5", s.Duration.Max(), s.Duration.Count())
+	}
+	if s.EntryPages["/"] != 2 || s.EntryPages["/cart"] != 1 || s.EntryPages["/api/status"] != 1 {
+		t.Errorf("entry pages = %v", s.EntryPages)
+	}
+	if s.ExitPages["/cart"] != 2 || s.ExitPages["/products"] != 1 || s.ExitPages["/blog/"] != 1 {
+		t.Errorf("exit pages = %v", s.ExitPages)
+	}
+	if s.Journeys["/ → /products → /cart"] != 1 || s.Journeys["/ → /products"] != 1 {
+		t.Errorf("journeys = %v", s.Journeys)
+	}
+	if result.TotalRequests != 10 {
+		t.Errorf("inner analyzer counted %d requests, want 10", result.TotalRequests)
+	}
+
+	// 合并与复制
+	clone := result.Clone()
+	clone.Merge(result)
+	if clone.Sessions.Sessions != 10 || s.Sessions != 5 {
+		t.Errorf("merged clone has %d sessions, original %d; want 10 and 5", clone.Sessions.Sessions, s.Sessions)
+	}
+}
+
+// 通过处理器并发处理生成的日志
+func TestProcessorSessions(t *testing.T) {
+	processor, result := processGenerated(t,
+		GeneratorConfig{Seed: 7, Lines: 20000, Duration: 6 * time.Hour},
+		ProcessorConfig{ParserType: "combined", Sessions: &SessionConfig{}},
+		func(p *LogProcessor) { p.SetConcurrency(4) })
+	processor.Flush()
+	if result.Sessions == nil || result.Sessions.Sessions == 0 {
+		t.Fatalf("no sessions in %d requests", result.TotalRequests)
+	}
+	if result.Sessions.Requests != result.TotalRequests {
+		t.Errorf("sessions cover %d requests, want %d", result.Sessions.Requests, result.TotalRequests)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+/*
+近似统计（概率数据结构）：
+
+精确统计每个 URL 和 IP 需要的内存与不同取值的个数成正比，
+大日志中可能有上千万个不同的 URL（带查询参数）和 IP。
+
+- TopKSketch：Count-Min Sketch 估计频次 + 最小堆保留候选热门 URL，
+  内存固定为 O(宽度×深度 + K)，估计值只会偏大，
+  偏大的量以概率 1-Delta 不超过 Epsilon×总数
+- HyperLogLog：用 2^p 个寄存器估计不同 IP 的个数，标准误差约 1.04/√(2^p)
+
+两者都可以精确合并（参数相同的前提下），因此可以用在并发分片中。
+*/
+
+// 近似统计的配置
+type SketchConfig struct {
+	TopK         int     // 保留的热门 URL 数量
+	Epsilon      float64 // Count-Min 误差上限（占总请求数的比例），默认 0.001
+	Delta        float64 // 超出误差上限的概率，默认 0.01
+	VisitorError float64 // HyperLogLog 的相对标准误差，默认 0.01
+}
+
+func (c SketchConfig) withDefaults() SketchConfig {
+	if c.TopK <= 0 {
+		c.TopK = 10
+	}
+	if c.Epsilon <= 0 {
+		c.Epsilon = 0.001
+	}
+	if c.Delta <= 0 || c.Delta >= 1 {
+		c.Delta = 0.01
+	}
+	if c.VisitorError <= 0 {
+		c.VisitorError = 0.01
+	}
+	return c
+}
+
+// 使用近似统计的基础分析器
+func NewSketchAnalyzer(config SketchConfig) *BasicAnalyzer {
+	config = config.withDefaults()
+	a := &BasicAnalyzer{Sketch: &config}
+	a.result = a.newResult()
+	return a
+}
+
+// ---------- 哈希 ----------
+
+// FNV-1a 分布不够均匀，再经过 splitmix64 的混合函数
+func hash64(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ---------- Count-Min Sketch ----------
+
+type CountMinSketch struct {
+	width  int
+	depth  int
+	counts [][]int64
+}
+
+// 宽度 e/ε、深度 ln(1/δ)：估计值超出真实值 ε×总数 的概率不超过 δ
+func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+	width := int(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	return &CountMinSketch{width: width, depth: depth, counts: newCounts(depth, width)}
+}
+
+func newCounts(depth, width int) [][]int64 {
+	counts := make([][]int64, depth)
+	for i := range counts {
+		counts[i] = make([]int64, width)
+	}
+	return counts
+}
+
+// 双重哈希得到每一行的位置
+func (s *CountMinSketch) positions(key string, fn func(row, col int)) {
+	h := hash64(key)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for row := 0; row < s.depth; row++ {
+		fn(row, int((h1+uint32(row)*h2)%uint32(s.width)))
+	}
+}
+
+func (s *CountMinSketch) Add(key string, n int64) {
+	s.positions(key, func(row, col int) {
+		s.counts[row][col] += n
+	})
+}
+
+// 估计值不小于真实值
+func (s *CountMinSketch) Estimate(key string) int64 {
+	estimate := int64(math.MaxInt64)
+	s.positions(key, func(row, col int) {
+		if c := s.counts[row][col]; c < estimate {
+			estimate = c
+		}
+	})
+	return estimate
+}
+
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if s.width != other.width || s.depth != other.depth {
+		return fmt.Errorf("count-min sketch size mismatch: %dx%d vs %dx%d", s.depth, s.width, other.depth, other.width)
+	}
+	for row := range s.counts {
+		for col, c := range other.counts[row] {
+			s.counts[row][col] += c
+		}
+	}
+	return nil
+}
+
+func (s *CountMinSketch) Clone() *CountMinSketch {
+	clone := &CountMinSketch{width: s.width, depth: s.depth, counts: make([][]int64, s.depth)}
+	for row := range s.counts {
+		clone.counts[row] = append([]int64(nil), s.counts[row]...)
+	}
+	return clone
+}
+
+// ---------- Top-K ----------
+
+type topKItem struct {
+	key   string
+	count int64
+}
+
+// 按估计频次排列的最小堆，堆顶是候选中最冷的一个
+type topKHeap struct {
+	items []topKItem
+	index map[string]int
+}
+
+func (h *topKHeap) Len() int           { return len(h.items) }
+func (h *topKHeap) Less(i, j int) bool { return h.items[i].count < h.items[j].count }
+func (h *topKHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].key] = i
+	h.index[h.items[j].key] = j
+}
+func (h *topKHeap) Push(x interface{}) {
+	item := x.(topKItem)
+	h.index[item.key] = len(h.items)
+	h.items = append(h.items, item)
+}
+func (h *topKHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, item.key)
+	return item
+}
+
+type TopKSketch struct {
+	k        int
+	capacity int // 候选数量，多保留一些以减少边界上的误判
+	cms      *CountMinSketch
+	heap     *topKHeap
+}
+
+func NewTopKSketch(k int, epsilon, delta float64) *TopKSketch {
+	return &TopKSketch{
+		k:        k,
+		capacity: 2 * k,
+		cms:      NewCountMinSketch(epsilon, delta),
+		heap:     &topKHeap{index: make(map[string]int)},
+	}
+}
+
+func (s *TopKSketch) Add(key string, n int64) {
+	s.cms.Add(key, n)
+	s.offer(key, s.cms.Estimate(key))
+}
+
+// 用估计值更新候选集合
+func (s *TopKSketch) offer(key string, estimate int64) {
+	h := s.heap
+	if i, ok := h.index[key]; ok {
+		h.items[i].count = estimate
+		heap.Fix(h, i)
+		return
+	}
+	if h.Len() < s.capacity {
+		heap.Push(h, topKItem{key: key, count: estimate})
+		return
+	}
+	if estimate > h.items[0].count {
+		delete(h.index, h.items[0].key)
+		h.items[0] = topKItem{key: key, count: estimate}
+		h.index[key] = 0
+		heap.Fix(h, 0)
+	}
+}
+
+func (s *TopKSketch) Estimate(key string) int64 {
+	return s.cms.Estimate(key)
+}
+
+// 估计频次最高的 n 个键（n <= 0 或大于 K 时返回 K 个）
+func (s *TopKSketch) Top(n int) []URLCount {
+	if n <= 0 || n > s.k {
+		n = s.k
+	}
+	top := make([]URLCount, 0, s.heap.Len())
+	for _, item := range s.heap.items {
+		top = append(top, URLCount{URL: item.key, Count: item.count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].URL < top[j].URL
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// 合并后用合并的 Count-Min 重新估计两边所有候选
+func (s *TopKSketch) Merge(other *TopKSketch) error {
+	if err := s.cms.Merge(other.cms); err != nil {
+		return err
+	}
+	keys := make([]string, 0, s.heap.Len()+other.heap.Len())
+	for _, item := range s.heap.items {
+		keys = append(keys, item.key)
+	}
+	for _, item := range other.heap.items {
+		if _, ok := s.heap.index[item.key]; !ok {
+			keys = append(keys, item.key)
+		}
+	}
+
+	s.heap = &topKHeap{index: make(map[string]int, len(keys))}
+	for _, key := range keys {
+		s.offer(key, s.cms.Estimate(key))
+	}
+	return nil
+}
+
+// 参数相同的空草图
+func (s *TopKSketch) emptyCopy() *TopKSketch {
+	return &TopKSketch{
+		k:        s.k,
+		capacity: s.capacity,
+		cms:      &CountMinSketch{width: s.cms.width, depth: s.cms.depth, counts: newCounts(s.cms.depth, s.cms.width)},
+		heap:     &topKHeap{index: make(map[string]int)},
+	}
+}
+
+func (s *TopKSketch) Clone() *TopKSketch {
+	clone := &TopKSketch{
+		k:        s.k,
+		capacity: s.capacity,
+		cms:      s.cms.Clone(),
+		heap: &topKHeap{
+			items: append([]topKItem(nil), s.heap.items...),
+			index: make(map[string]int, len(s.heap.index)),
+		},
+	}
+	for key, i := range s.heap.index {
+		clone.heap.index[key] = i
+	}
+	return clone
+}
+
+// ---------- HyperLogLog ----------
+
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// 根据期望的相对标准误差选择寄存器个数：误差约为 1.04/√m
+func NewHyperLogLog(stdErr float64) *HyperLogLog {
+	m := math.Pow(1.04/stdErr, 2)
+	p := uint8(math.Ceil(math.Log2(m)))
+	if p < 4 {
+		p = 4
+	} else if p > 18 {
+		p = 18
+	}
+	return &HyperLogLog{precision: p, registers: make([]uint8, 1<<p)}
+}
+
+func (h *HyperLogLog) Add(key string) {
+	x := hash64(key)
+	idx := x >> (64 - h.precision)
+	// 剩余的位中第一个1出现的位置；末尾补1保证不会全为0
+	w := x<<h.precision | 1<<(h.precision-1)
+	rho := uint8(bits.LeadingZeros64(w)) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+func (h *HyperLogLog) Estimate() int64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+
+	// 基数较小时改用线性计数，误差更小
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// 精度不同时先把精度高的一方降到较低的精度再合并
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.precision > h.precision {
+		other = other.reduce(h.precision)
+	} else if other.precision < h.precision {
+		*h = *h.reduce(other.precision)
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// 降低精度：去掉的索引位重新并入 rho 的计算
+func (h *HyperLogLog) reduce(precision uint8) *HyperLogLog {
+	reduced := &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+	shift := h.precision - precision
+	for idx, r := range h.registers {
+		if r == 0 {
+			continue
+		}
+		dropped := uint64(idx) & (1<<shift - 1)
+		rho := r + shift
+		if dropped != 0 {
+			rho = uint8(bits.LeadingZeros64(dropped<<(64-shift))) + 1
+		}
+		if i := idx >> shift; rho > reduced.registers[i] {
+			reduced.registers[i] = rho
+		}
+	}
+	return reduced
+}
+
+func (h *HyperLogLog) emptyCopy() *HyperLogLog {
+	return &HyperLogLog{precision: h.precision, registers: make([]uint8, len(h.registers))}
+}
+
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{precision: h.precision, registers: append([]uint8(nil), h.registers...)}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// 用 Zipf 分布生成数据，对比精确统计与近似统计
+func TestSketchAccuracy(t *testing.T) {
+	config := SketchConfig{TopK: 10, Epsilon: 0.001, Delta: 0.01, VisitorError: 0.01}
+	exact := NewBasicAnalyzer()
+	approx := NewSketchAnalyzer(config)
+
+	rng := rand.New(rand.NewSource(42))
+	urls := rand.NewZipf(rng, 1.2, 1, 100000)
+	batch := make([]*LogEntry, 0, 1000)
+	for i := 0; i < 500000; i++ {
+		batch = append(batch, &LogEntry{
+			IP:         fmt.Sprintf("10.%d.%d.%d", rng.Intn(4), rng.Intn(256), rng.Intn(256)),
+			URL:        fmt.Sprintf("/page/%d", urls.Uint64()),
+			Method:     "GET",
+			StatusCode: 200,
+		})
+		if len(batch) == cap(batch) {
+			exact.Analyze(batch)
+			approx.Analyze(batch)
+			batch = batch[:0]
+		}
+	}
+	exactResult, approxResult := exact.Analyze(batch), approx.Analyze(batch)
+
+	t.Run("count-min", func(t *testing.T) {
+		// 估计值只会偏大，且偏大的量应在 Epsilon×总数 以内
+		bound := int64(config.Epsilon * float64(exactResult.TotalRequests))
+		inExact := make(map[string]bool)
+		for _, u := range exactResult.TopURLs(config.TopK) {
+			inExact[u.URL] = true
+		}
+		overlap := 0
+		for _, u := range approxResult.TopURLs(config.TopK) {
+			if inExact[u.URL] {
+				overlap++
+			}
+			over := u.Count - exactResult.URLCounts[u.URL]
+			if over < 0 || over > bound {
+				t.Errorf("%s: estimate %d, exact %d, want overestimate in [0, %d]",
+					u.URL, u.Count, exactResult.URLCounts[u.URL], bound)
+			}
+		}
+		if overlap < config.TopK-1 {
+			t.Errorf("top %d overlap = %d, want at least %d", config.TopK, overlap, config.TopK-1)
+		}
+	})
+
+	t.Run("hyperloglog", func(t *testing.T) {
+		exactVisitors, approxVisitors := exactResult.UniqueVisitors(), approxResult.UniqueVisitors()
+		relErr := math.Abs(float64(approxVisitors-exactVisitors)) / float64(exactVisitors)
+		if relErr > 3*config.VisitorError {
+			t.Errorf("unique visitors: estimate %d, exact %d, relative error %.4f > %.4f",
+				approxVisitors, exactVisitors, relErr, 3*config.VisitorError)
+		}
+	})
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+loganalyzer 命令行工具：
+
+	go run ./exercises/week2 [选项] [文件...]
+
+不指定文件或文件为 "-" 时读取标准输入，多个文件的结果合并输出。例如：
+
+	go run ./exercises/week2 -filter 'status >= 500' -top 20 access.log access.log.1.gz
+	go run ./exercises/week2 -since 2023-12-25T00:00:00Z -output html -o report.html access.log
+	tail -n 10000 access.log | go run ./exercises/week2 -format combined
+	go run ./exercises/week2 -follow -checkpoint access.cp access.log
+
+generate 子命令生成测试日志：
+
+	go run ./exercises/week2 generate -lines 1000000 -bursts 3 -o access.log.gz
+*/
+
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// 命令行参数
+type cliOptions struct {
+	format      string
+	logFormat   string
+	filter      string
+	since       string
+	until       string
+	allow       string
+	deny        string
+	allowFile   string
+	denyFile    string
+	precedence  string
+	concurrency int
+	output      ReportFormat
+	top         int
+	outFile     string
+	approx      bool
+	follow      bool
+	checkpoint  string
+	interval    time.Duration
+
+	onError        string
+	quarantine     string
+	maxErrorRate   float64
+	progress       time.Duration
+	metricsAddr    string
+	sessions       bool
+	sessionTimeout time.Duration
+	anomaly        bool
+	anomalyWindow  time.Duration
+	alertFile      string
+	alertWebhook   string
+}
+
+// 退出码：0 成功，1 处理失败，2 参数错误
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "generate" {
+		return runGenerate(args[1:], stdout, stderr)
+	}
+
+	fs := flag.NewFlagSet("loganalyzer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts cliOptions
+	fs.StringVar(&opts.format, "format", "auto", "日志格式: common, combined, json, custom, auto")
+	fs.StringVar(&opts.logFormat, "log-format", "", "Nginx log_format 定义，用于 custom 格式或作为 auto 的额外候选")
+	fs.StringVar(&opts.filter, "filter", "", "过滤表达式，如 'status >= 500 && path =~ \"^/api\"'")
+	fs.StringVar(&opts.since, "since", "", "只统计该时间之后的日志，RFC3339 时间或相对时长（如 2h）")
+	fs.StringVar(&opts.until, "until", "", "只统计该时间之前的日志，格式同 -since")
+	fs.StringVar(&opts.allow, "allow", "", "只统计这些IP或网段，逗号分隔")
+	fs.StringVar(&opts.deny, "deny", "", "排除这些IP或网段，逗号分隔")
+	fs.StringVar(&opts.allowFile, "allow-file", "", "从文件读取允许的IP列表，每行一个")
+	fs.StringVar(&opts.denyFile, "deny-file", "", "从文件读取排除的IP列表，每行一个")
+	fs.StringVar(&opts.precedence, "ip-precedence", "deny-wins", "同时命中允许和排除时: deny-wins, allow-wins, longest-match")
+	fs.IntVar(&opts.concurrency, "concurrency", runtime.NumCPU(), "解析协程数，1 表示顺序处理")
+	fs.Var(&opts.output, "output", "输出格式: text, json, csv, html")
+	fs.IntVar(&opts.top, "top", defaultReportTopN, "热门URL和最慢URL的数量")
+	fs.StringVar(&opts.outFile, "o", "", "报告写入该文件，默认输出到标准输出")
+	fs.BoolVar(&opts.approx, "approx", false, "热门URL和独立访客使用近似统计，内存占用固定")
+	fs.BoolVar(&opts.follow, "follow", false, "持续跟踪一个日志文件，Ctrl+C 结束后输出报告")
+	fs.StringVar(&opts.checkpoint, "checkpoint", "", "跟踪模式的检查点文件，重启后从上次的位置继续")
+	fs.DurationVar(&opts.interval, "interval", 5*time.Second, "跟踪模式输出进度的间隔")
+	fs.StringVar(&opts.onError, "on-error", "skip", "无法解析的行: skip, fail-fast, quarantine")
+	fs.StringVar(&opts.quarantine, "quarantine", "", "把无法解析的行写入该文件（隐含 -on-error quarantine）")
+	fs.DurationVar(&opts.progress, "progress", 0, "每隔该时间在标准错误输出处理进度，结束时输出性能报告，0 表示不输出")
+	fs.StringVar(&opts.metricsAddr, "metrics-addr", "", "在该地址（如 :9100）的 /metrics 提供 Prometheus 指标，处理期间有效")
+	fs.BoolVar(&opts.sessions, "sessions", false, "按 IP 和 User-Agent 还原访问会话，报告入口页、出口页和常见访问路径")
+	fs.DurationVar(&opts.sessionTimeout, "session-timeout", 30*time.Minute, "会话的无活动超时，用于 -sessions")
+	fs.BoolVar(&opts.anomaly, "anomaly", false, "检测错误率突增和流量突增、骤降，告警输出到标准错误")
+	fs.DurationVar(&opts.anomalyWindow, "anomaly-window", time.Minute, "异常检测的时间窗口宽度")
+	fs.StringVar(&opts.alertFile, "alert-file", "", "告警以 JSON Lines 追加到该文件（隐含 -anomaly）")
+	fs.StringVar(&opts.alertWebhook, "alert-webhook", "", "告警以 JSON 格式 POST 到该地址（隐含 -anomaly）")
+	fs.Float64Var(&opts.maxErrorRate, "max-error-rate", 0, "无法解析的行占比超过该值（0~1）时中止，0 表示不限制")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "用法: loganalyzer [选项] [文件...]")
+		fmt.Fprintln(stderr, "      loganalyzer generate [选项]")
+		fmt.Fprintln(stderr, "不指定文件或文件为 - 时读取标准输入，gzip 文件自动解压。")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	alerts, closeAlerts, err := buildAlertSink(opts, stderr)
+	if err != nil {
+		printCLIError(stderr, err)
+		return 1
+	}
+	defer closeAlerts()
+	processor, err := buildProcessor(opts, alerts)
+	if err != nil {
+		printCLIError(stderr, err)
+		return 2
+	}
+	detector := processor.anomalyDetector()
+	if detector != nil {
+		detector.OnError = func(err error) {
+			fmt.Fprintf(stderr, "loganalyzer: failed to send alert: %v\n", err)
+		}
+	}
+	policy, err := buildErrorPolicy(opts)
+	if err != nil {
+		printCLIError(stderr, err)
+		return 2
+	}
+	if opts.quarantine != "" {
+		file, err := os.Create(opts.quarantine)
+		if err != nil {
+			printCLIError(stderr, err)
+			return 1
+		}
+		defer file.Close()
+		policy.Quarantine = file
+	}
+	if err := processor.SetErrorPolicy(policy); err != nil {
+		printCLIError(stderr, err)
+		return 2
+	}
+	renderer, err := NewRenderer(opts.output.String(), opts.top)
+	if err != nil {
+		printCLIError(stderr, err)
+		return 2
+	}
+
+	if opts.metricsAddr != "" {
+		metrics := NewMetricsCollector()
+		processor.SetMetrics(metrics)
+		shutdown, err := serveMetrics(opts.metricsAddr, metrics)
+		if err != nil {
+			printCLIError(stderr, err)
+			return 1
+		}
+		defer shutdown()
+		fmt.Fprintf(stderr, "loganalyzer: serving metrics on http://%s/metrics\n", opts.metricsAddr)
+	}
+
+	var monitor *PerformanceMonitor
+	if opts.progress > 0 {
+		monitor = &PerformanceMonitor{}
+		monitor.Start()
+		monitor.StartReporter(stderr, opts.progress)
+		processor.SetMonitor(monitor)
+	}
+
+	files := fs.Args()
+	var result *AnalysisResult
+	var summary ErrorSummary
+	if opts.follow {
+		if len(files) != 1 || files[0] == "-" {
+			fmt.Fprintln(stderr, "loganalyzer: -follow requires exactly one file")
+			return 2
+		}
+		// 日志完全停止时也要结束窗口，才能发现服务中断
+		if detector != nil {
+			detector.StartTicker(time.Second)
+		}
+		result, summary, err = followFile(processor, files[0], opts, stderr)
+		if detector != nil {
+			detector.Stop()
+		}
+	} else {
+		result, summary, err = processFiles(processor, files, stdin)
+	}
+	if monitor != nil {
+		monitor.Stop()
+		fmt.Fprint(stderr, monitor.GetReport())
+	}
+	// 第一个错误就中止时，错误本身已经说明了原因
+	if err == nil || errors.Is(err, ErrErrorBudgetExceeded) {
+		printErrorSummary(stderr, summary)
+	}
+	if err != nil {
+		printCLIError(stderr, err)
+		return 1
+	}
+	// 数据已经读完，结束异常检测的当前窗口和进行中的会话
+	processor.Flush()
+	result.Formats = processor.FormatReport()
+
+	out := stdout
+	if opts.outFile != "" {
+		file, err := os.Create(opts.outFile)
+		if err != nil {
+			printCLIError(stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := renderer.Render(out, result); err != nil {
+		printCLIError(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// generate 子命令：生成测试日志，写入 -o 指定的文件或标准输出
+func runGenerate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("loganalyzer generate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var config GeneratorConfig
+	var start, curve, outFile string
+	fs.Int64Var(&config.Seed, "seed", 1, "随机种子，相同的种子生成相同的日志")
+	fs.StringVar(&config.Format, "format", "combined", "日志格式: common, combined, json")
+	fs.IntVar(&config.Lines, "lines", 10000, "生成的行数")
+	fs.StringVar(&start, "start", "2023-12-25T00:00:00Z", "第一条日志的时间")
+	fs.DurationVar(&config.Duration, "duration", 24*time.Hour, "日志覆盖的时间范围")
+	fs.StringVar(&curve, "curve", "diurnal", "流量曲线: diurnal, flat")
+	fs.IntVar(&config.Clients, "clients", 1000, "访客IP数量")
+	fs.IntVar(&config.Paths, "paths", 500, "不同页面的数量")
+	fs.Float64Var(&config.ErrorRate, "error-rate", 0.02, "正常时段 4xx/5xx 的比例，负数表示关闭")
+	fs.Float64Var(&config.BotRate, "bot-rate", 0.1, "爬虫请求的比例，负数表示关闭")
+	fs.Float64Var(&config.MalformedRate, "malformed-rate", 0.001, "格式错误的行的比例，负数表示关闭")
+	fs.IntVar(&config.Bursts, "bursts", 0, "错误突发的次数")
+	fs.DurationVar(&config.BurstDuration, "burst-duration", 5*time.Minute, "每次突发的时长")
+	fs.Float64Var(&config.BurstErrorRate, "burst-error-rate", 0.5, "突发期间 5xx 的比例")
+	fs.StringVar(&outFile, "o", "", "写入该文件，以 .gz 结尾时压缩，默认输出到标准输出")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "loganalyzer generate: unexpected argument %q\n", fs.Arg(0))
+		return 2
+	}
+
+	var err error
+	if config.Start, err = parseFilterTime(start); err != nil {
+		printCLIError(stderr, fmt.Errorf("-start: %w", err))
+		return 2
+	}
+	switch curve {
+	case "diurnal":
+		config.Curve = DiurnalCurve
+	case "flat":
+		config.Curve = FlatCurve
+	default:
+		fmt.Fprintf(stderr, "loganalyzer: unknown curve %q (want diurnal or flat)\n", curve)
+		return 2
+	}
+	generator, err := NewLogGenerator(config)
+	if err != nil {
+		printCLIError(stderr, err)
+		return 2
+	}
+
+	if outFile != "" {
+		err = generator.WriteFile(outFile)
+	} else {
+		_, err = generator.WriteTo(stdout)
+	}
+	if err != nil {
+		printCLIError(stderr, err)
+		return 1
+	}
+	for _, burst := range generator.Bursts() {
+		fmt.Fprintf(stderr, "error burst: %s ~ %s\n", burst.Start.Format(time.RFC3339), burst.End.Format(time.RFC3339))
+	}
+	return 0
+}
+
+// 过滤表达式的错误额外输出出错位置
+func printCLIError(w io.Writer, err error) {
+	fmt.Fprintf(w, "loganalyzer: %v\n", err)
+	var syntaxErr *FilterSyntaxError
+	if errors.As(err, &syntaxErr) {
+		for _, line := range strings.Split(syntaxErr.Context(), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
+
+// 在后台提供 /metrics，返回关闭服务的函数。先监听再返回，地址被占用等错误可以立即报告
+func serveMetrics(addr string, metrics *MetricsCollector) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	server := &http.Server{Handler: NewMetricsMux(metrics), ReadHeaderTimeout: 5 * time.Second}
+	go server.Serve(listener)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
+
+// 输出被跳过的行数，每种原因附带第一个例子
+func printErrorSummary(w io.Writer, summary ErrorSummary) {
+	if summary.Errors == 0 {
+		return
+	}
+	fmt.Fprintf(w, "loganalyzer: %s\n", summary)
+	for _, cause := range summary.Causes() {
+		if example, ok := summary.Examples[cause]; ok {
+			fmt.Fprintf(w, "  e.g. %s\n", example)
+		}
+	}
+}
+
+// 异常检测的告警目标：标准错误，以及 -alert-file 和 -alert-webhook。
+// 没有启用异常检测时返回 nil；返回的函数关闭告警文件
+func buildAlertSink(opts cliOptions, stderr io.Writer) (AlertSink, func(), error) {
+	if !opts.anomaly && opts.alertFile == "" && opts.alertWebhook == "" {
+		return nil, func() {}, nil
+	}
+	sinks := MultiSink{&WriterSink{W: stderr}}
+	closeFn := func() {}
+	if opts.alertWebhook != "" {
+		sinks = append(sinks, &WebhookSink{URL: opts.alertWebhook})
+	}
+	if opts.alertFile != "" {
+		file, err := NewFileSink(opts.alertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, file)
+		closeFn = func() { file.Close() }
+	}
+	return sinks, closeFn, nil
+}
+
+// 由命令行参数创建处理器：-filter 由 CreateProcessor 编译，时间范围和IP列表作为额外的过滤器。
+// alerts 不为 nil 时启用异常检测
+func buildProcessor(opts cliOptions, alerts AlertSink) (*LogProcessor, error) {
+	config := ProcessorConfig{
+		ParserType: opts.format,
+		LogFormat:  opts.logFormat,
+		Filter:     opts.filter,
+	}
+	if opts.approx {
+		config.Sketch = &SketchConfig{TopK: opts.top}
+	}
+	if opts.sessions {
+		config.Sessions = &SessionConfig{Timeout: opts.sessionTimeout}
+	}
+	if alerts != nil {
+		config.Anomaly = &AnomalyConfig{Window: opts.anomalyWindow}
+		config.Alerts = alerts
+	}
+	processor, err := CreateProcessor(config)
+	if err != nil {
+		return nil, err
+	}
+	processor.SetConcurrency(opts.concurrency)
+
+	now := time.Now()
+	var timeRange TimeRangeFilter
+	if timeRange.StartTime, err = parseCLITime(opts.since, now); err != nil {
+		return nil, fmt.Errorf("-since: %w", err)
+	}
+	if timeRange.EndTime, err = parseCLITime(opts.until, now); err != nil {
+		return nil, fmt.Errorf("-until: %w", err)
+	}
+	if !timeRange.StartTime.IsZero() && !timeRange.EndTime.IsZero() && !timeRange.StartTime.Before(timeRange.EndTime) {
+		return nil, fmt.Errorf("-since must be before -until")
+	}
+	if !timeRange.StartTime.IsZero() || !timeRange.EndTime.IsZero() {
+		processor.AddFilter(&timeRange)
+	}
+
+	ipFilter, err := buildIPFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	if ipFilter != nil {
+		processor.AddFilter(ipFilter)
+	}
+	return processor, nil
+}
+
+// 绝对时间，或相对当前时间的时长（"2h" 表示两小时前）
+func parseCLITime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			d = -d
+		}
+		return now.Add(-d), nil
+	}
+	return parseFilterTime(value)
+}
+
+// -quarantine 隐含隔离模式，和其他模式同时指定时报错
+func buildErrorPolicy(opts cliOptions) (ErrorPolicy, error) {
+	mode, err := ParseErrorMode(opts.onError)
+	if err != nil {
+		return ErrorPolicy{}, fmt.Errorf("-on-error: %w", err)
+	}
+	if opts.quarantine != "" {
+		if mode == FailFast {
+			return ErrorPolicy{}, fmt.Errorf("-quarantine cannot be used with -on-error fail-fast")
+		}
+		mode = QuarantineErrors
+	} else if mode == QuarantineErrors {
+		return ErrorPolicy{}, fmt.Errorf("-on-error quarantine requires -quarantine")
+	}
+	if opts.maxErrorRate < 0 || opts.maxErrorRate > 1 {
+		return ErrorPolicy{}, fmt.Errorf("-max-error-rate must be between 0 and 1")
+	}
+	return ErrorPolicy{Mode: mode, MaxErrorRate: opts.maxErrorRate}, nil
+}
+
+func buildIPFilter(opts cliOptions) (*IPFilter, error) {
+	allowed := splitList(opts.allow)
+	blocked := splitList(opts.deny)
+	if opts.allowFile != "" {
+		list, err := LoadIPList(opts.allowFile)
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, list...)
+	}
+	if opts.denyFile != "" {
+		list, err := LoadIPList(opts.denyFile)
+		if err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, list...)
+	}
+	precedence, err := ParseIPPrecedence(opts.precedence)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 && len(blocked) == 0 {
+		return nil, nil
+	}
+	filter, err := NewIPFilter(allowed, blocked)
+	if err != nil {
+		return nil, err
+	}
+	filter.Precedence = precedence
+	return filter, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 依次处理每个文件。分析器在多次处理之间累计，最后一次的结果就是合并后的结果；
+// 错误统计逐个文件累加，出错时也返回已处理部分的统计
+func processFiles(processor *LogProcessor, files []string, stdin io.Reader) (*AnalysisResult, ErrorSummary, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var result *AnalysisResult
+	var summary ErrorSummary
+	for _, name := range files {
+		var err error
+		if name == "-" {
+			result, err = processor.ProcessReader(stdin)
+			if err != nil {
+				err = fmt.Errorf("stdin: %w", err)
+			}
+		} else {
+			result, err = processor.ProcessFile(name)
+		}
+		summary.Merge(processor.ErrorSummary())
+		if err != nil {
+			return nil, summary, err
+		}
+	}
+	return result, summary, nil
+}
+
+// 跟踪模式：定期在标准错误输出进度，收到中断信号后返回最终结果
+func followFile(processor *LogProcessor, filename string, opts cliOptions, stderr io.Writer) (*AnalysisResult, ErrorSummary, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	processor.SetSnapshotInterval(opts.interval)
+	results, err := processor.Follow(ctx, filename, FollowOptions{
+		Checkpoint: opts.checkpoint,
+		OnError: func(err error) {
+			fmt.Fprintf(stderr, "loganalyzer: %v\n", err)
+		},
+	})
+	if err != nil {
+		return nil, ErrorSummary{}, err
+	}
+
+	var result *AnalysisResult
+	for r := range results {
+		result = r
+		fmt.Fprintf(stderr, "[%s] %d requests, %.2f%% errors, %d visitors\n",
+			time.Now().Format("15:04:05"), r.TotalRequests, r.ErrorRate()*100, r.UniqueVisitors())
+	}
+	if err := processor.Err(); err != nil {
+		return nil, processor.ErrorSummary(), err
+	}
+	if result == nil {
+		result = NewAnalysisResult()
+	}
+	return result, processor.ErrorSummary(), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 运行命令行，返回退出码、标准输出和标准错误
+func runCLIWith(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr strings.Builder
+	code := runCLI(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// 200 行 combined 格式的日志
+func cliTestLog(t *testing.T) string {
+	t.Helper()
+	generator, err := NewLogGenerator(GeneratorConfig{Seed: 1, Format: "combined", Lines: 200, MalformedRate: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	if _, err := generator.WriteTo(&data); err != nil {
+		t.Fatal(err)
+	}
+	return data.String()
+}
+
+func TestRunCLIExitCodes(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.log")
+	tests := []struct {
+		name   string
+		stdin  string
+		args   []string
+		code   int
+		stderr string // 标准错误包含的内容
+	}{
+		{"帮助", "", []string{"-h"}, 0, "用法: loganalyzer"},
+		{"未知参数", "", []string{"-nosuch"}, 2, "flag provided but not defined: -nosuch"},
+		{"参数值无效", "", []string{"-top", "many"}, 2, `invalid value "many" for flag -top`},
+		{"输出格式无效", "", []string{"-output", "xml"}, 2, `unknown output format "xml"`},
+		{"日志格式无效", "", []string{"-format", "xml"}, 2, "loganalyzer:"},
+		{"过滤表达式错误", "", []string{"-filter", "status >"}, 2, "filter column 9: expected a value after >, got end of expression\n  status >\n          ^"},
+		{"错误策略无效", "", []string{"-on-error", "retry"}, 2, "-on-error"},
+		{"隔离文件缺失", "", []string{"-on-error", "quarantine"}, 2, "-on-error quarantine requires -quarantine"},
+		{"跟踪需要一个文件", "", []string{"-follow"}, 2, "-follow requires exactly one file"},
+		{"文件不存在", "", []string{missing}, 1, "missing.log"},
+		{"遇到错误行中止", "not a log line\n", []string{"-format", "common", "-on-error", "fail-fast"}, 1, "loganalyzer:"},
+		{"跳过错误行", "not a log line\n", []string{"-format", "common"}, 0, ""},
+		{"生成日志的参数多余", "", []string{"generate", "extra"}, 2, `unexpected argument "extra"`},
+		{"流量曲线无效", "", []string{"generate", "-curve", "zigzag"}, 2, `unknown curve "zigzag"`},
+		{"生成日志的开始时间无效", "", []string{"generate", "-start", "yesterday"}, 2, "-start:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCLIWith(t, tt.stdin, tt.args...)
+			if code != tt.code {
+				t.Errorf("got exit code %d, want %d\nstderr:\n%s", code, tt.code, stderr)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("got stderr:\n%s\nwant it to contain %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+// -output 切换报告格式，-o 把报告写入文件
+func TestRunCLIOutput(t *testing.T) {
+	data := cliTestLog(t)
+	tests := []struct {
+		output string
+		check  func(t *testing.T, report string)
+	}{
+		{"", func(t *testing.T, report string) {
+			if !strings.HasPrefix(report, "== 概览 ==") || !hasTextRow(report, "总请求数", "200") {
+				t.Errorf("got text report:\n%s", report)
+			}
+		}},
+		{"json", func(t *testing.T, report string) {
+			var d struct {
+				TotalRequests int64 `json:"total_requests"`
+			}
+			if err := json.Unmarshal([]byte(report), &d); err != nil || d.TotalRequests != 200 {
+				t.Errorf("got JSON report with %d requests (%v):\n%s", d.TotalRequests, err, report)
+			}
+		}},
+		{"csv", func(t *testing.T, report string) {
+			records, err := csv.NewReader(strings.NewReader(report)).ReadAll()
+			if err != nil || len(records) < 2 || strings.Join(records[1], ",") != "summary,,total_requests,200" {
+				t.Errorf("got CSV report (%v):\n%s", err, report)
+			}
+		}},
+		{"html", func(t *testing.T, report string) {
+			if !strings.HasPrefix(report, "<!DOCTYPE html>") || !strings.Contains(report, "总请求数<b>200</b>") {
+				t.Errorf("got HTML report:\n%s", report)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			args := []string{"-format", "combined"}
+			if tt.output != "" {
+				args = append(args, "-output", tt.output)
+			}
+			code, stdout, stderr := runCLIWith(t, data, args...)
+			if code != 0 {
+				t.Fatalf("got exit code %d, want 0\nstderr:\n%s", code, stderr)
+			}
+			tt.check(t, stdout)
+
+			// 写入文件时标准输出为空，文件内容相同
+			file := filepath.Join(t.TempDir(), "report")
+			code, stdout, stderr = runCLIWith(t, data, append(args, "-o", file)...)
+			if code != 0 {
+				t.Fatalf("got exit code %d with -o, want 0\nstderr:\n%s", code, stderr)
+			}
+			if stdout != "" {
+				t.Errorf("got stdout %q with -o, want nothing", stdout)
+			}
+			report, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, string(report))
+		})
+	}
+}
+
+// 文件参数与标准输入，- 表示标准输入，多个文件的结果合并
+func TestRunCLIFiles(t *testing.T) {
+	data := cliTestLog(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "access.log")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		total string
+	}{
+		{"标准输入", nil, "200"},
+		{"文件", []string{file}, "200"},
+		{"文件和标准输入", []string{file, "-"}, "400"},
+		{"过滤", []string{"-filter", "status < 0", file}, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIWith(t, data, append([]string{"-format", "combined"}, tt.args...)...)
+			if code != 0 {
+				t.Fatalf("got exit code %d, want 0\nstderr:\n%s", code, stderr)
+			}
+			if !hasTextRow(stdout, "总请求数", tt.total) {
+				t.Errorf("got report:\n%s\nwant %s requests", stdout, tt.total)
+			}
+		})
+	}
+
+	// generate 子命令的输出可以直接分析
+	code, generated, stderr := runCLIWith(t, "", "generate", "-lines", "50", "-format", "json")
+	if code != 0 {
+		t.Fatalf("generate: got exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	if n := strings.Count(generated, "\n"); n != 50 {
+		t.Errorf("generate: got %d lines, want 50", n)
+	}
+	code, stdout, stderr := runCLIWith(t, generated, "-format", "auto")
+	if code != 0 || !hasTextRow(stdout, "总请求数", "50") {
+		t.Errorf("got exit code %d, report:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 /*
@@ -21,285 +36,1190 @@ import (
 // TODO: 设计日志解析器接口
 
 type LogParser interface {
-	// TODO: 定义解析日志行的方法
-	// 输入：原始日志行
-	// 输出：解析后的日志条目和错误
+	// Parse 解析一行日志，失败时返回 *LogProcessingError
+	Parse(line string) (*LogEntry, error)
 }
 
 type LogFilter interface {
-	// TODO: 定义过滤日志的方法
-	// 输入：日志条目
-	// 输出：是否通过过滤
+	// Filter 返回 true 表示条目通过过滤
+	Filter(entry *LogEntry) bool
 }
 
 type LogAnalyzer interface {
-	// TODO: 定义分析日志的方法
-	// 输入：日志条目切片
-	// 输出：分析结果
+	// Analyze 分析一批日志条目并返回到目前为止的累计结果。
+	// 处理大文件时会按批多次调用，分析器应在内部累计统计，
+	// 不能保留传入的切片或条目（切片会被复用）
+	Analyze(entries []*LogEntry) *AnalysisResult
 }
 
 // 练习2：定义数据结构
-// TODO: 定义日志条目结构
 
 type LogEntry struct {
-	// TODO: 定义字段
-	// 提示：时间戳、IP地址、请求方法、URL、状态码、响应大小、用户代理等
+	Timestamp  time.Time
+	IP         string
+	RemoteUser string // "-" 表示未认证，解析后为空
+	Method     string
+	URL        string
+	Protocol   string
+	StatusCode int
+	Size       int64 // 响应字节数，"-" 视为0
+	Referer    string
+	UserAgent  string
+
+	// 请求耗时，只有日志记录了耗时（HasResponseTime）时才有意义，0 也是合法的耗时
+	ResponseTime    time.Duration
+	HasResponseTime bool
+
+	// JSON 日志中没有映射到上面字段的其他字段，键为点分隔的路径
+	Extras map[string]interface{}
+
+	// 宽松模式下部分字段没能解析，对应字段保持零值
+	Partial bool
+	// 自动识别格式时记录本行匹配的格式名
+	Format string
 }
 
-// TODO: 定义分析结果结构
+// 分析结果：所有字段都是可以相加的计数，因此多个结果可以精确合并，方法见 log_result.go
 type AnalysisResult struct {
-	// TODO: 定义统计结果字段
-	// 提示：总请求数、状态码分布、热门页面、错误率、流量统计等
+	TotalRequests  int64
+	StatusCodes    map[int]int64
+	URLCounts      map[string]int64 // 保留完整计数，合并后热门页面仍然准确
+	IPCounts       map[string]int64 // 每个客户端IP的请求数，用于统计独立访客
+	Methods        map[string]int64
+	ErrorCount     int64     // 状态码 >= 400 的请求数
+	TotalBytes     int64     // 响应字节总数
+	HourlyRequests [24]int64 // 按一天中的小时（日志自身时区）统计的访问量
+
+	FirstSeen time.Time // 最早和最晚的日志时间
+	LastSeen  time.Time
+
+	// 响应耗时分布（见 log_latency.go），没有记录耗时的请求不计入；
+	// 使用近似统计时不按 URL 统计
+	Latency       *LatencySketch
+	URLLatency    map[string]*LatencySketch
+	StatusLatency map[int]*LatencySketch
+
+	// 使用近似统计时（见 log_sketch.go）代替 URLCounts 和 IPCounts
+	URLSketch *TopKSketch  `json:"-"`
+	Visitors  *HyperLogLog `json:"-"`
+
+	// 访问会话统计（见 log_session.go），只有使用 SessionAnalyzer 时才有
+	Sessions *SessionStats
+
+	// 自动识别格式时每种格式解析的行数（见 log_detect.go），由 LogProcessor.FormatReport 得到
+	Formats map[string]int64
 }
 
 // 练习3：实现Apache/Nginx日志解析器
-// TODO: 实现CommonLogParser，解析标准的Apache/Nginx日志格式
+// 标准格式：IP - - [timestamp] "method URL protocol" status size
+// 例如：127.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET /index.html HTTP/1.1" 200 1234
+
+// Common Log Format 默认的时间格式
+const CommonTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// 解析失败的原因，作为 LogProcessingError.Cause 包装返回，可用 errors.Is 判断
+var (
+	ErrMalformedLine    = errors.New("malformed log line")
+	ErrInvalidIP        = errors.New("invalid IP address")
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	ErrInvalidRequest   = errors.New("invalid request line")
+	ErrInvalidStatus    = errors.New("invalid status code")
+	ErrInvalidSize      = errors.New("invalid response size")
+	ErrInvalidDuration  = errors.New("invalid response time")
+	ErrInvalidJSON      = errors.New("invalid JSON log line")
+)
 
 type CommonLogParser struct {
-	// TODO: 定义配置字段（如时间格式等）
+	TimeLayout string // 为空时使用 CommonTimeLayout
+
+	// 严格模式（默认）拒绝任何格式不对的行；
+	// 宽松模式尽量保留能解析的字段，并把条目标记为 Partial
+	Lenient bool
 }
 
-// TODO: 实现Parse方法
 func (p *CommonLogParser) Parse(line string) (*LogEntry, error) {
-	// TODO: 解析日志行
-	// 标准格式：IP - - [timestamp] "method URL protocol" status size
-	// 例如：127.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET /index.html HTTP/1.1" 200 1234
-	return nil, nil
+	fields, err := splitLogFields(line)
+	if err != nil && !p.Lenient {
+		return nil, &LogProcessingError{Line: line, Cause: err}
+	}
+
+	if p.Lenient {
+		entry, err := p.salvage(fields)
+		if err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+		return entry, nil
+	}
+
+	if len(fields) != 7 {
+		return nil, &LogProcessingError{
+			Line:  line,
+			Cause: fmt.Errorf("%w: expected 7 fields, got %d", ErrMalformedLine, len(fields)),
+		}
+	}
+	entry := &LogEntry{}
+	if err := p.parseFields(entry, fields); err != nil {
+		return nil, &LogProcessingError{Line: line, Cause: err}
+	}
+	return entry, nil
+}
+
+// 严格解析 IP ident user [time] "request" status size 七个字段
+func (p *CommonLogParser) parseFields(entry *LogEntry, fields []string) error {
+	var err error
+	if entry.IP, err = parseIP(fields[0]); err != nil {
+		return err
+	}
+	entry.RemoteUser = dashToEmpty(fields[2])
+	if entry.Timestamp, err = p.parseTime(fields[3]); err != nil {
+		return err
+	}
+	if entry.Method, entry.URL, entry.Protocol, err = parseRequest(fields[4]); err != nil {
+		return err
+	}
+	if entry.StatusCode, err = parseStatus(fields[5]); err != nil {
+		return err
+	}
+	entry.Size, err = parseSize(fields[6])
+	return err
+}
+
+// 宽松模式：按字段的形态而不是位置识别，缺少ident/user等字段也能处理
+func (p *CommonLogParser) salvage(fields []string) (*LogEntry, error) {
+	entry := &LogEntry{}
+	recognized := 0
+	mark := func(err error) {
+		if err != nil {
+			entry.Partial = true
+		} else {
+			recognized++
+		}
+	}
+
+	var err error
+	rest := fields
+	if len(rest) > 0 && !isBracketed(rest[0]) && !isQuoted(rest[0]) {
+		entry.IP, err = parseIP(rest[0])
+		mark(err)
+		rest = rest[1:]
+	} else {
+		entry.Partial = true
+	}
+
+	// ident 和 user 位于时间字段之前
+	for len(rest) > 0 && !isBracketed(rest[0]) && !isQuoted(rest[0]) {
+		if rest[0] != "-" {
+			entry.RemoteUser = rest[0]
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && isBracketed(rest[0]) {
+		entry.Timestamp, err = p.parseTime(rest[0])
+		mark(err)
+		rest = rest[1:]
+	} else {
+		entry.Partial = true
+	}
+	if len(rest) > 0 && isQuoted(rest[0]) {
+		entry.Method, entry.URL, entry.Protocol, err = parseRequest(rest[0])
+		if err != nil {
+			// 请求行被截断时保留已有的方法和URL
+			parts := strings.Fields(unquoteField(rest[0]))
+			if len(parts) > 0 {
+				entry.Method = parts[0]
+			}
+			if len(parts) > 1 {
+				entry.URL = parts[1]
+			}
+		}
+		mark(err)
+		rest = rest[1:]
+	} else {
+		entry.Partial = true
+	}
+	if len(rest) > 0 {
+		entry.StatusCode, err = parseStatus(rest[0])
+		mark(err)
+		rest = rest[1:]
+	} else {
+		entry.Partial = true
+	}
+	if len(rest) > 0 {
+		entry.Size, err = parseSize(rest[0])
+		mark(err)
+	} else {
+		entry.Partial = true
+	}
+
+	if recognized == 0 {
+		return nil, fmt.Errorf("%w: no recognizable fields", ErrMalformedLine)
+	}
+	return entry, nil
+}
+
+func (p *CommonLogParser) parseTime(field string) (time.Time, error) {
+	layout := p.TimeLayout
+	if layout == "" {
+		layout = CommonTimeLayout
+	}
+	return parseTimeValue(layout, strings.TrimSuffix(strings.TrimPrefix(field, "["), "]"))
+}
+
+// 把日志行拆成字段：[...] 和 "..." 各自作为一个整体，引号内支持 \" 转义
+// 括号或引号没有闭合时返回已拆出的字段和 ErrMalformedLine
+func splitLogFields(line string) ([]string, error) {
+	fields := make([]string, 0, 12)
+	i := 0
+	for i < len(line) {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+		case '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				return append(fields, line[i:]), fmt.Errorf("%w: unterminated '['", ErrMalformedLine)
+			}
+			fields = append(fields, line[i:i+end+1])
+			i += end + 1
+		case '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return append(fields, line[i:]), fmt.Errorf("%w: unterminated quote", ErrMalformedLine)
+			}
+			fields = append(fields, line[i:end+1])
+			i = end + 1
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+		}
+	}
+	return fields, nil
+}
+
+func isBracketed(field string) bool {
+	return strings.HasPrefix(field, "[")
+}
+
+func isQuoted(field string) bool {
+	return strings.HasPrefix(field, "\"")
+}
+
+// 去掉引号并还原转义字符
+func unquoteField(field string) string {
+	if len(field) >= 2 && isQuoted(field) && strings.HasSuffix(field, "\"") {
+		field = field[1 : len(field)-1]
+	} else {
+		field = strings.TrimPrefix(field, "\"")
+	}
+	return unescapeField(field)
+}
+
+// 还原 \" 和 \\ 转义
+func unescapeField(field string) string {
+	if strings.IndexByte(field, '\\') < 0 {
+		return field
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(field)
+}
+
+func dashToEmpty(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+func parseIP(field string) (string, error) {
+	if net.ParseIP(field) == nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIP, field)
+	}
+	return field, nil
+}
+
+// 解析 "GET /index.html HTTP/1.1"
+func parseRequest(field string) (method, url, protocol string, err error) {
+	parts := strings.Fields(unquoteField(field))
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("%w: %s", ErrInvalidRequest, field)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func parseStatus(field string) (int, error) {
+	code, err := strconv.Atoi(field)
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, field)
+	}
+	return code, nil
+}
+
+func parseSize(field string) (int64, error) {
+	if field == "-" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(field, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidSize, field)
+	}
+	return size, nil
 }
 
 // 练习4：实现JSON日志解析器
-// TODO: 实现JSONLogParser，解析JSON格式的日志
+// 例如：{"ts":"2023-12-25T10:00:00Z","remote_ip":"127.0.0.1","http":{"method":"GET","path":"/","status":200}}
+
+// LogEntry 字段对应的 JSON 路径，"http.status" 表示嵌套对象中的字段
+// 为空的字段使用 DefaultJSONFieldMapping 中的路径，"-" 表示不映射
+type JSONFieldMapping struct {
+	Timestamp  string
+	IP         string
+	RemoteUser string
+	Method     string
+	URL        string
+	Protocol   string
+	Status     string
+	Size       string
+	Referer    string
+	UserAgent  string
+	Duration   string // 请求耗时，单位由 JSONLogParser.DurationUnit 决定
+}
+
+var DefaultJSONFieldMapping = JSONFieldMapping{
+	Timestamp:  "time",
+	IP:         "remote_addr",
+	RemoteUser: "remote_user",
+	Method:     "method",
+	URL:        "url",
+	Protocol:   "protocol",
+	Status:     "status",
+	Size:       "bytes",
+	Referer:    "referer",
+	UserAgent:  "user_agent",
+	Duration:   "duration_ms",
+}
+
+// 特殊的时间格式：数字形式的 Unix 时间戳（秒或毫秒）
+const (
+	TimeLayoutUnix   = "unix"
+	TimeLayoutUnixMs = "unix_ms"
+)
+
+// 默认依次尝试的时间格式
+var defaultJSONTimeLayouts = []string{time.RFC3339Nano, CommonTimeLayout, "2006-01-02 15:04:05"}
 
 type JSONLogParser struct {
-	// TODO: 定义配置
+	Fields JSONFieldMapping
+
+	// 依次尝试的时间格式，可以包含 TimeLayoutUnix / TimeLayoutUnixMs；
+	// 为空时尝试常见格式，数字时间戳按数量级自动判断秒或毫秒
+	TimeLayouts []string
+
+	// 数字形式耗时的单位，默认为毫秒；字符串形式（如 "1.5s"）按 time.ParseDuration 解析
+	DurationUnit time.Duration
 }
 
-// TODO: 实现Parse方法
 func (p *JSONLogParser) Parse(line string) (*LogEntry, error) {
-	// TODO: 解析JSON格式的日志
-	return nil, nil
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, &LogProcessingError{Line: line, Cause: fmt.Errorf("%w: %v", ErrInvalidJSON, err)}
+	}
+	// Decode 只读取第一个值：null 会得到空的 doc，其后的内容也会被忽略
+	if doc == nil {
+		return nil, &LogProcessingError{Line: line, Cause: fmt.Errorf("%w: not a JSON object", ErrInvalidJSON)}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, &LogProcessingError{Line: line, Cause: fmt.Errorf("%w: unexpected data after JSON object", ErrInvalidJSON)}
+	}
+
+	entry := &LogEntry{}
+	used := make(map[string]bool)
+	lookup := func(path, def string) (interface{}, bool) {
+		if path == "" {
+			path = def
+		}
+		if path == "-" {
+			return nil, false
+		}
+		value, ok := lookupJSONPath(doc, path)
+		if ok {
+			used[path] = true
+		}
+		return value, ok
+	}
+
+	f, d := p.Fields, DefaultJSONFieldMapping
+	var err error
+	if v, ok := lookup(f.Timestamp, d.Timestamp); ok {
+		if entry.Timestamp, err = p.parseTime(v); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+	}
+	if v, ok := lookup(f.IP, d.IP); ok {
+		if entry.IP, err = parseIP(jsonString(v)); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+	}
+	if v, ok := lookup(f.Status, d.Status); ok {
+		if entry.StatusCode, err = parseStatus(jsonString(v)); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+	}
+	if v, ok := lookup(f.Size, d.Size); ok {
+		if entry.Size, err = parseSize(jsonString(v)); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+	}
+	if v, ok := lookup(f.Duration, d.Duration); ok {
+		if entry.ResponseTime, err = p.parseDuration(v); err != nil {
+			return nil, &LogProcessingError{Line: line, Cause: err}
+		}
+		entry.HasResponseTime = true
+	}
+	if v, ok := lookup(f.RemoteUser, d.RemoteUser); ok {
+		entry.RemoteUser = dashToEmpty(jsonString(v))
+	}
+	if v, ok := lookup(f.Method, d.Method); ok {
+		entry.Method = jsonString(v)
+	}
+	if v, ok := lookup(f.URL, d.URL); ok {
+		entry.URL = jsonString(v)
+	}
+	if v, ok := lookup(f.Protocol, d.Protocol); ok {
+		entry.Protocol = jsonString(v)
+	}
+	if v, ok := lookup(f.Referer, d.Referer); ok {
+		entry.Referer = dashToEmpty(jsonString(v))
+	}
+	if v, ok := lookup(f.UserAgent, d.UserAgent); ok {
+		entry.UserAgent = dashToEmpty(jsonString(v))
+	}
+
+	collectJSONExtras(doc, "", used, &entry.Extras)
+	return entry, nil
+}
+
+func (p *JSONLogParser) parseTime(value interface{}) (time.Time, error) {
+	layouts := p.TimeLayouts
+	if len(layouts) == 0 {
+		layouts = defaultJSONTimeLayouts
+	}
+
+	text := jsonString(value)
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return parseEpoch(number, layouts)
+	}
+	for _, layout := range layouts {
+		if layout == TimeLayoutUnix || layout == TimeLayoutUnixMs {
+			continue
+		}
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, text)
+}
+
+func (p *JSONLogParser) parseDuration(value interface{}) (time.Duration, error) {
+	unit := p.DurationUnit
+	if unit <= 0 {
+		unit = time.Millisecond
+	}
+	text := jsonString(value)
+	if number, err := strconv.ParseFloat(text, 64); err == nil && number >= 0 {
+		return time.Duration(math.Round(number * float64(unit))), nil
+	}
+	if d, err := time.ParseDuration(text); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, text)
+}
+
+// 数字时间戳：优先使用配置的单位，否则按数量级判断（大于1e11视为毫秒）
+func parseEpoch(number float64, layouts []string) (time.Time, error) {
+	millis := math.Abs(number) >= 1e11
+	for _, layout := range layouts {
+		if layout == TimeLayoutUnix {
+			millis = false
+			break
+		}
+		if layout == TimeLayoutUnixMs {
+			millis = true
+			break
+		}
+	}
+
+	if millis {
+		return time.UnixMilli(int64(number)).UTC(), nil
+	}
+	sec, frac := math.Modf(number)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
+// 按点分隔的路径查找值；同名的扁平键（如 "http.status"）优先
+func lookupJSONPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+	child, ok := doc[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupJSONPath(child, rest)
+}
+
+// 把没有被映射的叶子字段以点分隔的路径放入extras
+func collectJSONExtras(doc map[string]interface{}, prefix string, used map[string]bool, extras *map[string]interface{}) {
+	for key, value := range doc {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if used[path] {
+			continue
+		}
+		if child, ok := value.(map[string]interface{}); ok && len(child) > 0 {
+			collectJSONExtras(child, path, used, extras)
+			continue
+		}
+		if *extras == nil {
+			*extras = make(map[string]interface{})
+		}
+		(*extras)[path] = value
+	}
+}
+
+// 把JSON值转换为字符串，数字保持原始写法
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
 }
 
 // 练习5：实现日志过滤器
-// TODO: 实现各种过滤器
+// 过滤器可以用 AndFilter/OrFilter/NotFilter 组合，也可以由表达式编译得到，见 log_filter.go
 
-// 状态码过滤器
+// 状态码过滤器，AllowedCodes 为空时全部通过
 type StatusCodeFilter struct {
 	AllowedCodes []int
 }
 
-// TODO: 实现Filter方法
 func (f *StatusCodeFilter) Filter(entry *LogEntry) bool {
-	// TODO: 检查状态码是否在允许列表中
+	if len(f.AllowedCodes) == 0 {
+		return true
+	}
+	for _, code := range f.AllowedCodes {
+		if entry.StatusCode == code {
+			return true
+		}
+	}
 	return false
 }
 
-// 时间范围过滤器
+// 时间范围过滤器，范围为 [StartTime, EndTime)，零值表示该端不限制。
+// 设置了范围时，没有时间的条目（宽松模式）不通过
 type TimeRangeFilter struct {
 	StartTime time.Time
 	EndTime   time.Time
 }
 
-// TODO: 实现Filter方法
 func (f *TimeRangeFilter) Filter(entry *LogEntry) bool {
-	// TODO: 检查时间是否在指定范围内
-	return false
+	if f.StartTime.IsZero() && f.EndTime.IsZero() {
+		return true
+	}
+	if entry.Timestamp.IsZero() {
+		return false
+	}
+	if !f.StartTime.IsZero() && entry.Timestamp.Before(f.StartTime) {
+		return false
+	}
+	if !f.EndTime.IsZero() && !entry.Timestamp.Before(f.EndTime) {
+		return false
+	}
+	return true
 }
 
-// IP地址过滤器
+// IP地址过滤器，列表中可以是单个IP或CIDR网段，支持IPv6。
+// 同时命中两个列表时由 Precedence 决定结果；两个列表都没命中时，
+// AllowedIPs 为空则通过，否则不通过。
+// 直接构造时无效的条目被忽略，需要检查时使用 NewIPFilter；
+// 列表在第一次过滤时编译成前缀树（见 log_ipfilter.go），之后不应再修改
 type IPFilter struct {
 	AllowedIPs []string
 	BlockedIPs []string
+	Precedence IPPrecedence
+
+	once sync.Once
+	v4   ipTrie
+	v6   ipTrie
+}
+
+// 创建IP过滤器并检查列表中的每一项
+func NewIPFilter(allowed, blocked []string) (*IPFilter, error) {
+	for _, list := range [][]string{allowed, blocked} {
+		for _, spec := range list {
+			if _, err := parsePrefix(spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &IPFilter{AllowedIPs: allowed, BlockedIPs: blocked}, nil
 }
 
-// TODO: 实现Filter方法
 func (f *IPFilter) Filter(entry *LogEntry) bool {
-	// TODO: 检查IP地址
+	f.once.Do(f.compile)
+
+	addr, err := netip.ParseAddr(entry.IP)
+	if err != nil {
+		return false
+	}
+	allowLen, denyLen := f.lookup(addr)
+	return f.Precedence.decide(allowLen, denyLen, len(f.AllowedIPs) == 0)
+}
+
+// 所有过滤器都通过才通过，没有过滤器时全部通过
+type AndFilter struct {
+	Filters []LogFilter
+}
+
+func (f *AndFilter) Filter(entry *LogEntry) bool {
+	for _, filter := range f.Filters {
+		if !filter.Filter(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// 任意一个过滤器通过即通过
+type OrFilter struct {
+	Filters []LogFilter
+}
+
+func (f *OrFilter) Filter(entry *LogEntry) bool {
+	for _, filter := range f.Filters {
+		if filter.Filter(entry) {
+			return true
+		}
+	}
 	return false
 }
 
+// 取反
+type NotFilter struct {
+	Inner LogFilter
+}
+
+func (f *NotFilter) Filter(entry *LogEntry) bool {
+	return !f.Inner.Filter(entry)
+}
+
+// 用函数实现过滤器
+type FilterFunc func(entry *LogEntry) bool
+
+func (f FilterFunc) Filter(entry *LogEntry) bool {
+	return f(entry)
+}
+
 // 练习6：实现日志分析器
-// TODO: 实现基础统计分析器
 
+// 基础统计分析器，零值可直接使用。
+// 统计累计在内部的结果中，支持并发流水线的分片聚合（ShardableAnalyzer）
 type BasicAnalyzer struct {
-	// TODO: 定义内部状态（如计数器、映射等）
+	Sketch *SketchConfig // 非 nil 时热门URL和独立访客使用近似统计，见 NewSketchAnalyzer
+
+	result *AnalysisResult
+}
+
+func NewBasicAnalyzer() *BasicAnalyzer {
+	return &BasicAnalyzer{result: NewAnalysisResult()}
 }
 
-// TODO: 实现Analyze方法
+func (a *BasicAnalyzer) newResult() *AnalysisResult {
+	if a.Sketch == nil {
+		return NewAnalysisResult()
+	}
+	return newSketchResult(*a.Sketch)
+}
+
+// 返回的是内部累计结果本身，后续调用 Analyze 会继续修改它；
+// 需要交给其他协程时请使用 Snapshot
 func (a *BasicAnalyzer) Analyze(entries []*LogEntry) *AnalysisResult {
-	// TODO: 分析日志条目
-	// 计算：
-	// 1. 总请求数
-	// 2. 状态码分布
-	// 3. 最热门的URL
-	// 4. 请求方法分布
-	// 5. 错误率
-	// 6. 平均响应大小
-	// 7. 按小时的访问量分布
-	return nil
+	if a.result == nil {
+		a.result = a.newResult()
+	}
+	for _, entry := range entries {
+		a.result.Add(entry)
+	}
+	return a.result
+}
+
+// 分片使用相同的近似统计参数，保证可以合并
+func (a *BasicAnalyzer) Fork() LogAnalyzer {
+	shard := &BasicAnalyzer{Sketch: a.Sketch}
+	shard.result = shard.newResult()
+	return shard
+}
+
+func (a *BasicAnalyzer) Join(shard LogAnalyzer) {
+	other, ok := shard.(*BasicAnalyzer)
+	if !ok || other.result == nil {
+		return
+	}
+	if a.result == nil {
+		a.result = a.newResult()
+	}
+	a.result.Merge(other.result)
+}
+
+func (a *BasicAnalyzer) Snapshot() *AnalysisResult {
+	if a.result == nil {
+		return a.newResult()
+	}
+	return a.result.Clone()
 }
 
 // 练习7：实现内存优化的日志处理器
-// TODO: 实现大文件日志处理器，考虑内存效率
 
 type LogProcessor struct {
 	parser     LogParser
 	filters    []LogFilter
 	analyzer   LogAnalyzer
-	bufferSize int
+	bufferSize int // 读取缓冲区的字节数
+	batchSize  int // 每批分析的条目数，批次切片会被复用；也是流水线中各通道的容量
+
+	maxLineLength int                 // 单行最大长度，0 表示使用默认值
+	errs          errorTracker        // 错误处理策略和最近一次处理的错误统计，见 log_errors.go
+	monitor       *PerformanceMonitor // 为 nil 时不统计性能，见 log_monitor.go
+	metrics       *MetricsCollector   // 为 nil 时不收集指标，见 log_metrics.go
+
+	workers          int           // 并发解析协程数，见 log_pipeline.go
+	snapshotInterval time.Duration // ProcessStream 输出阶段性结果的间隔
+}
+
+// 默认每批分析的日志条数
+const defaultBatchSize = 1000
+
+// 能根据输入开头的样本调整自身的解析器（如 AutoDetectParser）
+type readerSampler interface {
+	SampleReader(br *bufio.Reader) string
 }
 
-// TODO: 实现创建处理器的构造函数
+// bufferSize 为读取缓冲区的字节数，小于等于0时使用默认值（64KB）
 func NewLogProcessor(parser LogParser, analyzer LogAnalyzer, bufferSize int) *LogProcessor {
-	// TODO: 初始化处理器
-	return nil
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &LogProcessor{
+		parser:           parser,
+		analyzer:         analyzer,
+		bufferSize:       bufferSize,
+		batchSize:        defaultBatchSize,
+		workers:          1,
+		snapshotInterval: defaultSnapshotInterval,
+	}
 }
 
-// TODO: 实现添加过滤器的方法
+// 过滤器按添加顺序执行，全部通过的条目才会被分析
 func (p *LogProcessor) AddFilter(filter LogFilter) {
-	// TODO: 添加过滤器到链中
+	p.filters = append(p.filters, filter)
+}
+
+// 设置每批分析的日志条数，小于等于0时使用默认值
+func (p *LogProcessor) SetBatchSize(n int) {
+	if n <= 0 {
+		n = defaultBatchSize
+	}
+	p.batchSize = n
+}
+
+// 设置单行最大长度，超过的行会被跳过
+func (p *LogProcessor) SetMaxLineLength(n int) {
+	p.maxLineLength = n
 }
 
-// TODO: 实现批量处理日志文件的方法
+// 最近一次处理中因无法解析或过长而跳过的行数
+func (p *LogProcessor) MalformedLines() int64 {
+	return atomic.LoadInt64(&p.errs.errors)
+}
+
+// 处理日志文件，支持 gzip 压缩文件。
+// 逐行读取并按批分析，内存占用与文件大小无关
 func (p *LogProcessor) ProcessFile(filename string) (*AnalysisResult, error) {
-	// TODO: 实现文件处理逻辑
-	// 1. 打开文件
-	// 2. 逐行读取（注意内存使用）
-	// 3. 解析每一行
-	// 4. 应用过滤器
-	// 5. 批量分析（避免全部加载到内存）
-	// 6. 返回结果
-	return nil, nil
+	// 由 ProcessReader 识别格式和解压，这样读取进度按压缩前的文件大小计算
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+	if p.monitor != nil {
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+			p.monitor.AddTotalBytes(info.Size())
+		}
+	}
+
+	result, err := p.ProcessReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return result, nil
 }
 
-// TODO: 实现流式处理方法（使用通道）
+// 处理任意输入流（如标准输入），gzip 数据同样会被自动解压。
+// 错误策略要求中止时返回对应的错误，见 log_errors.go
+func (p *LogProcessor) ProcessReader(r io.Reader) (*AnalysisResult, error) {
+	if p.monitor != nil {
+		r = &countingReader{r: r, monitor: p.monitor}
+	}
+	br, err := decompressReader(r, p.bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	if sampler, ok := p.parser.(readerSampler); ok {
+		sampler.SampleReader(br)
+	}
+	r = br
+
+	if p.workers > 1 {
+		return p.processConcurrent(r)
+	}
+	p.errs.reset(nil)
+
+	batch := make([]*LogEntry, 0, p.batchSize)
+	var result *AnalysisResult
+	flush := func() {
+		result = p.analyzer.Analyze(batch)
+		// 清空引用，让已分析的条目尽快被回收
+		batch = resetBatch(batch)
+	}
+
+	err = p.readLines(r, func(line []byte, lineNum int) error {
+		p.countLine()
+		entry, err := p.parser.Parse(string(line))
+		if err != nil || entry == nil {
+			if err == nil {
+				err = ErrMalformedLine
+			}
+			return p.lineError(string(line), lineNum, err)
+		}
+		if !p.accept(entry) {
+			return nil
+		}
+		if p.metrics != nil {
+			p.metrics.Observe(entry)
+		}
+
+		batch = append(batch, entry)
+		if len(batch) == p.batchSize {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.errs.finish(); err != nil {
+		return nil, err
+	}
+
+	// 最后一批（可能为空）也要交给分析器，以便得到最终结果
+	flush()
+	return result, nil
+}
+
+// 逐行读取并回调，跳过空行，超长行按错误策略处理；line 只在回调期间有效
+func (p *LogProcessor) readLines(r io.Reader, fn func(line []byte, lineNum int) error) error {
+	reader := newLineReader(r, p.maxLineLength)
+	for {
+		line, err := reader.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == ErrLineTooLong {
+			p.countLine()
+			if err := p.lineError("", reader.lineNum, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read error after line %d: %w", reader.lineNum, err)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line, reader.lineNum); err != nil {
+			return err
+		}
+	}
+}
+
+// 依次执行过滤器
+func (p *LogProcessor) accept(entry *LogEntry) bool {
+	for _, filter := range p.filters {
+		if !filter.Filter(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// 流式处理：从通道读取日志行，输出阶段性结果和最终结果，实现见 log_pipeline.go
 func (p *LogProcessor) ProcessStream(lines <-chan string) (<-chan *AnalysisResult, error) {
-	// TODO: 实现流式处理
-	// 使用goroutine和channel进行并发处理
-	return nil, nil
+	return p.ProcessStreamContext(context.Background(), lines)
 }
 
 // 练习8：实现错误处理和恢复
-// TODO: 定义自定义错误类型
 
+// 解析器不知道行号，LineNumber 为0时由读取文件的调用方补上
 type LogProcessingError struct {
 	LineNumber int
 	Line       string
 	Cause      error
 }
 
-// TODO: 实现Error方法
+// 错误信息中最多展示的原始日志长度
+const maxErrorLineLength = 80
+
 func (e *LogProcessingError) Error() string {
-	// TODO: 返回格式化的错误信息
-	return ""
+	// 超长行等情况没有保留原始内容
+	if e.Line == "" {
+		if e.LineNumber > 0 {
+			return fmt.Sprintf("line %d: %v", e.LineNumber, e.Cause)
+		}
+		return e.Cause.Error()
+	}
+	line := e.Line
+	if len(line) > maxErrorLineLength {
+		// 在字符边界截断，避免把多字节的 UTF-8 字符切成两半
+		cut := maxErrorLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		line = line[:cut] + "..."
+	}
+	if e.LineNumber > 0 {
+		return fmt.Sprintf("line %d: %v: %q", e.LineNumber, e.Cause, line)
+	}
+	return fmt.Sprintf("%v: %q", e.Cause, line)
 }
 
-// TODO: 实现Unwrap方法
 func (e *LogProcessingError) Unwrap() error {
-	// TODO: 返回原始错误
-	return nil
+	return e.Cause
 }
 
 // 练习9：实现配置和扩展性
-// TODO: 实现配置驱动的处理器工厂
 
 type ProcessorConfig struct {
-	ParserType   string                 // "common", "json", "custom"
-	FilterConfig map[string]interface{} // 过滤器配置
-	BufferSize   int                    // 缓冲区大小
-	Concurrent   bool                   // 是否启用并发处理
+	ParserType string         // "common", "combined", "json", "custom", "auto"
+	LogFormat  string         // "custom" 使用的 Nginx log_format，"auto" 时作为额外的候选格式
+	Filter     string         // 过滤表达式，语法见 log_filter.go，为空表示不过滤
+	BufferSize int            // 读取缓冲区的字节数，默认64KB；比缓冲区长的行同样能完整读取
+	BatchSize  int            // 每批分析的日志条数，也是流水线中各通道的容量，默认1000
+	Concurrent bool           // 是否启用并发处理
+	Sketch     *SketchConfig  // 非 nil 时热门URL和独立访客使用近似统计
+	Errors     ErrorPolicy    // 无法解析的行的处理策略，默认跳过并计数
+	Sessions   *SessionConfig // 非 nil 时同时还原访问会话
+	Anomaly    *AnomalyConfig // 非 nil 时检测错误率和流量异常，告警发送到 Alerts
+	Alerts     AlertSink      // 为 nil 时只检测不发送
 }
 
-// TODO: 实现工厂函数
 func CreateProcessor(config ProcessorConfig) (*LogProcessor, error) {
-	// TODO: 根据配置创建处理器
-	// 1. 根据类型创建解析器
-	// 2. 根据配置创建过滤器
-	// 3. 创建分析器
-	// 4. 组装处理器
-	return nil, nil
+	parser, err := newParser(config)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := &BasicAnalyzer{}
+	if config.Sketch != nil {
+		basic = NewSketchAnalyzer(*config.Sketch)
+	}
+	var analyzer LogAnalyzer = basic
+	if config.Sessions != nil {
+		analyzer = NewSessionAnalyzer(analyzer, *config.Sessions)
+	}
+	if config.Anomaly != nil {
+		analyzer = NewAnomalyDetector(analyzer, config.Alerts, *config.Anomaly)
+	}
+	processor := NewLogProcessor(parser, analyzer, config.BufferSize)
+	processor.SetBatchSize(config.BatchSize)
+	if err := processor.SetErrorPolicy(config.Errors); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(config.Filter) != "" {
+		filter, err := ParseFilter(config.Filter)
+		if err != nil {
+			return nil, err
+		}
+		processor.AddFilter(filter)
+	}
+	if config.Concurrent {
+		processor.SetConcurrency(runtime.NumCPU())
+	}
+	return processor, nil
+}
+
+// 根据配置创建解析器
+func newParser(config ProcessorConfig) (LogParser, error) {
+	switch config.ParserType {
+	case "", "common":
+		return &CommonLogParser{}, nil
+	case "combined":
+		return &CombinedLogParser{}, nil
+	case "json":
+		return &JSONLogParser{}, nil
+	case "custom":
+		if config.LogFormat == "" {
+			return nil, fmt.Errorf("parser type \"custom\" requires LogFormat")
+		}
+		return NewNginxFormatParser(config.LogFormat)
+	case "auto":
+		if config.LogFormat != "" {
+			return NewAutoDetectParser(config.LogFormat)
+		}
+		return NewAutoDetectParser()
+	default:
+		return nil, fmt.Errorf("unknown parser type %q", config.ParserType)
+	}
 }
 
 // 练习10：实现性能监控和优化
-// TODO: 实现性能监控器
 
+// 处理进度和性能统计，计数器都是原子操作，可以在并发流水线中使用；
+// 定期报告和 LogProcessor 的接入见 log_monitor.go
 type PerformanceMonitor struct {
-	startTime      time.Time
 	processedLines int64
 	errors         int64
+	bytes          int64 // 已读取的输入字节数（gzip 文件为压缩后的字节）
+	totalBytes     int64 // 输入总大小，用于估算剩余时间，0 表示未知
+
+	mu        sync.Mutex
+	startTime time.Time
+	endTime   time.Time     // Stop 之后耗时不再增长
+	peakHeap  uint64        // 采样到的最大堆内存
+	stop      chan struct{} // 关闭时停止定期报告
+	done      chan struct{} // 定期报告协程退出后关闭
 }
 
-// TODO: 实现开始监控
+// 开始监控，清零所有计数
 func (pm *PerformanceMonitor) Start() {
-	// TODO: 开始性能监控
+	pm.Stop()
+	atomic.StoreInt64(&pm.processedLines, 0)
+	atomic.StoreInt64(&pm.errors, 0)
+	atomic.StoreInt64(&pm.bytes, 0)
+	atomic.StoreInt64(&pm.totalBytes, 0)
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.startTime = time.Now()
+	pm.endTime = time.Time{}
+	pm.peakHeap = 0
 }
 
-// TODO: 实现记录处理的行数
 func (pm *PerformanceMonitor) RecordProcessedLine() {
-	// TODO: 增加处理行数计数
+	atomic.AddInt64(&pm.processedLines, 1)
 }
 
-// TODO: 实现记录错误
 func (pm *PerformanceMonitor) RecordError() {
-	// TODO: 增加错误计数
+	atomic.AddInt64(&pm.errors, 1)
+}
+
+func (pm *PerformanceMonitor) RecordBytes(n int64) {
+	atomic.AddInt64(&pm.bytes, n)
+}
+
+// 增加预计要读取的字节数，依次处理多个文件时逐个累加
+func (pm *PerformanceMonitor) AddTotalBytes(n int64) {
+	atomic.AddInt64(&pm.totalBytes, n)
 }
 
-// TODO: 实现获取性能报告
+// 最终报告：处理时间、行数、速率、错误率和内存
 func (pm *PerformanceMonitor) GetReport() string {
-	// TODO: 返回性能报告
-	// 包括：处理时间、行数、速率、错误率等
-	return ""
+	s := pm.Snapshot()
+	var b strings.Builder
+	fmt.Fprintf(&b, "处理时间: %v\n", s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "处理行数: %d（%.0f 行/秒）\n", s.Lines, s.LinesPerSec)
+	fmt.Fprintf(&b, "读取数据: %s（%s/秒）\n", formatBytes(float64(s.Bytes)), formatBytes(s.BytesPerSec))
+	fmt.Fprintf(&b, "错误行数: %d（%.2f%%）\n", s.Errors, s.ErrorRate*100)
+	fmt.Fprintf(&b, "堆内存: 当前 %s，峰值 %s，GC %d 次\n",
+		formatBytes(float64(s.HeapAlloc)), formatBytes(float64(s.PeakHeap)), s.NumGC)
+	return b.String()
 }
 
-// 测试和示例函数
+// 解析器严格模式与宽松模式的示例，完整的功能见 loganalyzer 命令（loganalyzer.go）
 func runMiniProject() {
 	fmt.Println("=== Web日志分析器项目 ===")
-	fmt.Println("这是一个综合练习项目，需要实现以上所有TODO")
+	fmt.Println("命令行工具的用法见 go run ./exercises/week2 -h，例如：")
+	fmt.Println("  go run ./exercises/week2 generate -lines 10000 -o access.log")
+	fmt.Println("  go run ./exercises/week2 -filter 'status >= 500' -output html -o report.html access.log")
 	fmt.Println()
 
-	fmt.Println("项目要求：")
-	fmt.Println("1. 实现至少2种日志格式的解析器")
-	fmt.Println("2. 实现至少3种日志过滤器")
-	fmt.Println("3. 实现完整的统计分析功能")
-	fmt.Println("4. 考虑大文件的内存效率")
-	fmt.Println("5. 使用适当的错误处理")
-	fmt.Println("6. 设计清晰的接口")
+	// 严格模式与宽松模式对比
+	samples := []string{
+		`127.0.0.1 - - [25/Dec/2023:10:00:00 +0000] "GET /index.html HTTP/1.1" 200 1234`,
+		`10.0.0.2 - alice [25/Dec/2023:10:00:05 +0000] "POST /login HTTP/1.1" 302 -`,
+		`10.0.0.3 - - [25/Dec/2023:10:00:07 +0000] "GET /broken`,
+		`not a log line`,
+	}
+	parsers := []struct {
+		name   string
+		parser *CommonLogParser
+	}{
+		{"严格模式", &CommonLogParser{}},
+		{"宽松模式", &CommonLogParser{Lenient: true}},
+	}
+	for _, p := range parsers {
+		fmt.Printf("--- %s ---\n", p.name)
+		for i, line := range samples {
+			entry, err := p.parser.Parse(line)
+			var perr *LogProcessingError
+			if errors.As(err, &perr) {
+				perr.LineNumber = i + 1
+				fmt.Printf("错误: %v\n", perr)
+				continue
+			}
+			fmt.Printf("第%d行: %+v\n", i+1, *entry)
+		}
+	}
 	fmt.Println()
-
-	// TODO: 实现示例用法
-	// config := ProcessorConfig{
-	//     ParserType: "common",
-	//     BufferSize: 1000,
-	//     Concurrent: true,
-	// }
-	// processor, err := CreateProcessor(config)
-	// if err != nil {
-	//     fmt.Printf("创建处理器失败: %v\n", err)
-	//     return
-	// }
-	//
-	// // 添加过滤器
-	// processor.AddFilter(&StatusCodeFilter{AllowedCodes: []int{200, 301, 404}})
-	// processor.AddFilter(&TimeRangeFilter{...})
-	//
-	// // 处理日志文件
-	// result, err := processor.ProcessFile("access.log")
-	// if err != nil {
-	//     fmt.Printf("处理文件失败: %v\n", err)
-	//     return
-	// }
-	//
-	// fmt.Printf("分析结果: %+v\n", result)
-
-	fmt.Println("请实现所有TODO后运行完整测试")
 }
 
 // 使函数可被调用
 var _ = runMiniProject
 
-// 提示：可以创建测试日志文件
+// 创建测试日志文件，更多选项见 log_generator.go 和 generate 子命令
 func createSampleLogFile() {
 	fmt.Println("=== 创建测试日志文件 ===")
-	// TODO: 可以实现一个函数生成测试用的日志文件
-	fmt.Println("可以创建包含不同格式日志的测试文件")
+	for _, format := range []string{"common", "combined", "json"} {
+		filename := "sample_" + format + ".log"
+		generator, err := NewLogGenerator(GeneratorConfig{Seed: 1, Format: format, Lines: 1000, Bursts: 1})
+		if err == nil {
+			err = generator.WriteFile(filename)
+		}
+		if err != nil {
+			fmt.Printf("创建 %s 失败: %v\n", filename, err)
+			continue
+		}
+		fmt.Printf("已创建 %s（1000 行，%s 格式）\n", filename, format)
+	}
 }